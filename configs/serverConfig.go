@@ -13,6 +13,11 @@ type ServerConfig struct {
 	TimeSubtractionMS int64  // Время в миллисекундах для операций вычитания.
 	TimeMultiplyMS    int64  // Время в миллисекундах для операций умножения.
 	TimeDivisionMS    int64  // Время в миллисекундах для операций деления.
+	TimeModuloMS      int64  // Время в миллисекундах для операций взятия остатка.
+	TimePowerMS       int64  // Время в миллисекундах для операций возведения в степень.
+	TimeFunctionMS    int64  // Время в миллисекундах для вычисления встроенных функций.
+	ResultCacheSize   int64  // Сколько результатов задач хранит кеш; 0 отключает кеш.
+	ResultCacheTTLMS  int64  // Время жизни результата в кеше в миллисекундах; 0 — без ограничения.
 }
 
 func NewServerConfig() (*ServerConfig, error) {
@@ -36,6 +41,31 @@ func NewServerConfig() (*ServerConfig, error) {
 		return nil, fmt.Errorf("invalid TIME_DIVISIONS_MS: %w", err)
 	}
 
+	timeMod, err := getEnvInt64("TIME_MODULO_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_MODULO_MS: %w", err)
+	}
+
+	timePow, err := getEnvInt64("TIME_EXPONENTIATION_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_EXPONENTIATION_MS: %w", err)
+	}
+
+	timeFunc, err := getEnvInt64("TIME_FUNCTION_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_FUNCTION_MS: %w", err)
+	}
+
+	cacheSize, err := getEnvInt64("RESULT_CACHE_SIZE", 10000)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RESULT_CACHE_SIZE: %w", err)
+	}
+
+	cacheTTL, err := getEnvInt64("RESULT_CACHE_TTL_MS", 300000)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RESULT_CACHE_TTL_MS: %w", err)
+	}
+
 	restPort := getEnvString("REST_PORT", "8080")
 
 	grpcPort := getEnvString("GRPC_PORT", "50051")
@@ -47,6 +77,11 @@ func NewServerConfig() (*ServerConfig, error) {
 		TimeSubtractionMS: timeSub,
 		TimeMultiplyMS:    timeMul,
 		TimeDivisionMS:    timeDiv,
+		TimeModuloMS:      timeMod,
+		TimePowerMS:       timePow,
+		TimeFunctionMS:    timeFunc,
+		ResultCacheSize:   cacheSize,
+		ResultCacheTTLMS:  cacheTTL,
 	}, nil
 }
 
@@ -13,6 +13,9 @@ type WorkerConfig struct {
 	SubtractionTimeMS int64  // Время в миллисекундах для операций вычитания.
 	MultiplyTimeMS    int64  // Время в миллисекундах для операций умножения.
 	DivisionTimeMS    int64  // Время в миллисекундах для операций деления.
+	ModuloTimeMS      int64  // Время в миллисекундах для операций взятия остатка.
+	PowerTimeMS       int64  // Время в миллисекундах для операций возведения в степень.
+	FunctionTimeMS    int64  // Время в миллисекундах для вычисления встроенных функций.
 }
 
 func NewWorkerConfig() (*WorkerConfig, error) {
@@ -41,6 +44,21 @@ func NewWorkerConfig() (*WorkerConfig, error) {
 		return nil, fmt.Errorf("invalid TIME_DIVISIONS_MS: %w", err)
 	}
 
+	timeMod, err := getWorkerEnvInt64("TIME_MODULO_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_MODULO_MS: %w", err)
+	}
+
+	timePow, err := getWorkerEnvInt64("TIME_EXPONENTIATION_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_EXPONENTIATION_MS: %w", err)
+	}
+
+	timeFunc, err := getWorkerEnvInt64("TIME_FUNCTION_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_FUNCTION_MS: %w", err)
+	}
+
 	return &WorkerConfig{
 		ComputingPower:    power,
 		OrchestratorURL:   getWorkerEnvString("ORCHESTRATOR_URL", "localhost:50051"),
@@ -48,6 +66,9 @@ func NewWorkerConfig() (*WorkerConfig, error) {
 		SubtractionTimeMS: timeSub,
 		MultiplyTimeMS:    timeMul,
 		DivisionTimeMS:    timeDiv,
+		ModuloTimeMS:      timeMod,
+		PowerTimeMS:       timePow,
+		FunctionTimeMS:    timeFunc,
 	}, nil
 }
 
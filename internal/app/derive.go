@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/structxz/calc_v3/internal/app/models"
+	"github.com/structxz/calc_v3/internal/constants"
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"go.uber.org/zap"
+)
+
+// handleDerive возвращает производную выражения по переменной. Если в
+// запросе есть точка at, производная ещё и отправляется на вычисление
+// агентам, как выражение из /calculate, и в ответе приходит его ID.
+func (s *Server) handleDerive(w http.ResponseWriter, r *http.Request) {
+	var req models.DeriveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode request body", zap.Error(err))
+		s.writeError(w, http.StatusUnprocessableEntity, constants.ErrInvalidRequestBody)
+		return
+	}
+	if req.Expression == "" || req.Variable == "" {
+		s.writeError(w, http.StatusUnprocessableEntity, constants.ErrInvalidRequestBody)
+		return
+	}
+	if len(req.Expression) > maxSimplifyLength {
+		s.writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("%s, the limit is %d characters", constants.ErrExpressionTooLong, maxSimplifyLength))
+		return
+	}
+
+	funcs, err := s.requestFunctions(r)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFunction)
+		return
+	}
+	tree, err := calculation.ParseWith(req.Expression, funcs)
+	if err != nil {
+		s.writeExpressionError(w, fmt.Errorf("invalid expression: %w", err), req.Expression)
+		return
+	}
+	derivative, err := calculation.Derive(tree, req.Variable)
+	if err == nil {
+		// Derive упрощает только то, что строит сам; сокращения вроде x / x,
+		// которые появляются при подстановке правил, доделывает Simplify.
+		derivative, err = calculation.Simplify(derivative)
+	}
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("invalid expression: %v", err))
+		return
+	}
+
+	resp := models.DeriveResponse{Variable: req.Variable, Derivative: calculation.Format(derivative)}
+	s.logger.Info("Derivative computed",
+		zap.String(constants.FieldExpression, req.Expression),
+		zap.String("variable", req.Variable),
+		zap.String("derivative", resp.Derivative))
+	if req.At == nil {
+		s.writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	// Производная уже не содержит вызовов функций пользователя, поэтому
+	// вычисляется как обычное выражение без них.
+	arith, err := newArithmetic(resp.Derivative, "", req.Precision, req.Scale, nil)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	dtree, err := s.parseExpression(resp.Derivative, "", req.At, arith, nil)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	unit, err := resultUnit(dtree, req.At, "", arith)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	expr, err := s.submitExpression(submission{expression: resp.Derivative, vars: req.At, arith: arith, unit: unit})
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedSaveExpression)
+		return
+	}
+	resp.ID = expr.ID
+	s.writeJSON(w, http.StatusCreated, resp)
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/structxz/calc_v3/internal/app/models"
+	"github.com/structxz/calc_v3/internal/constants"
+	"github.com/structxz/calc_v3/internal/middleware"
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+)
+
+// identPattern — допустимые имена формул и их параметров.
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (s *Server) handleCreateFormula(w http.ResponseWriter, r *http.Request) {
+	owner, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, constants.ErrUnauthorized)
+		return
+	}
+
+	var req models.FormulaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode request body", zap.Error(err))
+		s.writeError(w, http.StatusUnprocessableEntity, constants.ErrInvalidRequestBody)
+		return
+	}
+
+	if req.Parameters == nil {
+		req.Parameters = []string{}
+	}
+	if !identPattern.MatchString(req.Name) {
+		s.writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("invalid formula name %q", req.Name))
+		return
+	}
+	funcs, err := s.requestFunctions(r)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFunction)
+		return
+	}
+	if err := validateFormula(req.Body, req.Parameters, funcs); err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	now := time.Now()
+	formula := &models.Formula{
+		ID:         uuid.New().String(),
+		Owner:      owner,
+		Name:       req.Name,
+		Body:       req.Body,
+		Parameters: req.Parameters,
+		Version:    1,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if err := s.sqlite.SaveFormula(s.logger, formula); err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+			s.writeError(w, http.StatusConflict, fmt.Sprintf("Formula %s already exists", req.Name))
+			return
+		}
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedSaveFormula)
+		return
+	}
+
+	s.logger.Info("Formula created",
+		zap.String(constants.FieldLogin, owner),
+		zap.String(constants.FieldFormula, formula.Name))
+
+	s.writeJSON(w, http.StatusCreated, models.FormulaResponse{Formula: *formula})
+}
+
+func (s *Server) handleListFormulas(w http.ResponseWriter, r *http.Request) {
+	owner, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, constants.ErrUnauthorized)
+		return
+	}
+
+	formulas, err := s.sqlite.ListFormulas(s.logger, owner)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFormula)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, models.FormulasResponse{Formulas: formulas})
+}
+
+func (s *Server) handleGetFormula(w http.ResponseWriter, r *http.Request) {
+	formula, ok := s.lookupFormula(w, r)
+	if !ok {
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, models.FormulaResponse{Formula: *formula})
+}
+
+func (s *Server) handleUpdateFormula(w http.ResponseWriter, r *http.Request) {
+	formula, ok := s.lookupFormula(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.FormulaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode request body", zap.Error(err))
+		s.writeError(w, http.StatusUnprocessableEntity, constants.ErrInvalidRequestBody)
+		return
+	}
+
+	if req.Parameters == nil {
+		req.Parameters = []string{}
+	}
+	if req.Name != "" && req.Name != formula.Name {
+		s.writeError(w, http.StatusUnprocessableEntity, "formula cannot be renamed")
+		return
+	}
+	funcs, err := s.requestFunctions(r)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFunction)
+		return
+	}
+	if err := validateFormula(req.Body, req.Parameters, funcs); err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	formula.Body = req.Body
+	formula.Parameters = req.Parameters
+	formula.Version++
+	formula.UpdatedAt = time.Now()
+
+	if err := s.sqlite.UpdateFormula(s.logger, formula); err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedSaveFormula)
+		return
+	}
+
+	s.logger.Info("Formula updated",
+		zap.String(constants.FieldLogin, formula.Owner),
+		zap.String(constants.FieldFormula, formula.Name),
+		zap.Int("version", formula.Version))
+
+	s.writeJSON(w, http.StatusOK, models.FormulaResponse{Formula: *formula})
+}
+
+func (s *Server) handleDeleteFormula(w http.ResponseWriter, r *http.Request) {
+	owner, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, constants.ErrUnauthorized)
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	deleted, err := s.sqlite.DeleteFormula(s.logger, owner, name)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedDeleteFormula)
+		return
+	}
+	if !deleted {
+		s.writeError(w, http.StatusNotFound, constants.ErrFormulaNotFound)
+		return
+	}
+
+	s.logger.Info("Formula deleted",
+		zap.String(constants.FieldLogin, owner),
+		zap.String(constants.FieldFormula, name))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListFormulaVersions(w http.ResponseWriter, r *http.Request) {
+	formula, ok := s.lookupFormula(w, r)
+	if !ok {
+		return
+	}
+
+	versions, err := s.sqlite.ListFormulaVersions(s.logger, formula.ID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFormula)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, models.FormulaVersionsResponse{Versions: versions})
+}
+
+// handleEvaluateFormula подставляет значения параметров в формулу и
+// запускает обычное вычисление выражения.
+func (s *Server) handleEvaluateFormula(w http.ResponseWriter, r *http.Request) {
+	formula, ok := s.lookupFormula(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.FormulaEvaluateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode request body", zap.Error(err))
+		s.writeError(w, http.StatusUnprocessableEntity, constants.ErrInvalidRequestBody)
+		return
+	}
+
+	declared := make(map[string]bool, len(formula.Parameters))
+	for _, param := range formula.Parameters {
+		declared[param] = true
+		if _, ok := req.Parameters[param]; !ok {
+			s.writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("missing value for parameter %s", param))
+			return
+		}
+	}
+	for name := range req.Parameters {
+		if !declared[name] {
+			s.writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("unknown parameter %s", name))
+			return
+		}
+	}
+
+	funcs, err := s.requestFunctions(r)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFunction)
+		return
+	}
+	arith, err := newArithmetic(formula.Body, "", req.Precision, req.Scale, funcs)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	tree, err := s.parseExpression(formula.Body, "", req.Parameters, arith, funcs)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	unit, err := resultUnit(tree, req.Parameters, req.OutputUnit, arith)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	format := calculation.Notation(req.OutputFormat)
+	if err := format.Validate(); err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	// Тело формулы всегда записано с десятичной точкой, локаль влияет
+	// только на вывод результата.
+	locale, err := requestLocale(req.Locale)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	expr, err := s.submitExpression(submission{
+		expression:  formula.Body,
+		vars:        req.Parameters,
+		arith:       arith,
+		unit:        unit,
+		format:      format,
+		locale:      locale,
+		strictOrder: req.StrictOrder,
+		noCache:     req.NoCache,
+		funcs:       funcs,
+	})
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedSaveExpression)
+		return
+	}
+
+	s.logger.Info("Formula evaluation started",
+		zap.String(constants.FieldFormula, formula.Name),
+		zap.Int("version", formula.Version),
+		zap.String(constants.FieldExpressionID, expr.ID))
+
+	s.writeJSON(w, http.StatusCreated, models.CalculateResponse{ID: expr.ID})
+}
+
+// lookupFormula находит формулу текущего пользователя по имени из URL.
+// Если формулы нет, пишет ответ с ошибкой и возвращает false.
+func (s *Server) lookupFormula(w http.ResponseWriter, r *http.Request) (*models.Formula, bool) {
+	owner, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, constants.ErrUnauthorized)
+		return nil, false
+	}
+	name := mux.Vars(r)["name"]
+
+	formula, err := s.sqlite.GetFormula(s.logger, owner, name)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFormula)
+		return nil, false
+	}
+	if formula == nil {
+		s.logger.Warn(constants.ErrFormulaNotFound,
+			zap.String(constants.FieldLogin, owner),
+			zap.String(constants.FieldFormula, name))
+		s.writeError(w, http.StatusNotFound, constants.ErrFormulaNotFound)
+		return nil, false
+	}
+	return formula, true
+}
+
+// validateFormula проверяет, что тело формулы разбирается и использует
+// только объявленные параметры. Тело может вызывать функции пользователя funcs.
+func validateFormula(body string, params []string, funcs calculation.Definitions) error {
+	tree, err := calculation.ParseWith(body, funcs)
+	if err != nil {
+		return fmt.Errorf("invalid formula body: %w", err)
+	}
+
+	declared := make(map[string]bool, len(params))
+	for _, param := range params {
+		if !identPattern.MatchString(param) {
+			return fmt.Errorf("invalid parameter name %q", param)
+		}
+		if declared[param] {
+			return fmt.Errorf("duplicate parameter %s", param)
+		}
+		declared[param] = true
+	}
+
+	for _, name := range calculation.FreeVariables(tree) {
+		if !declared[name] {
+			return fmt.Errorf("invalid formula body: undeclared parameter %s", name)
+		}
+	}
+	return nil
+}
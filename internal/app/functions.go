@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/structxz/calc_v3/internal/app/models"
+	"github.com/structxz/calc_v3/internal/constants"
+	"github.com/structxz/calc_v3/internal/middleware"
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+)
+
+func (s *Server) handleCreateFunction(w http.ResponseWriter, r *http.Request) {
+	owner, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, constants.ErrUnauthorized)
+		return
+	}
+
+	var req models.FunctionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode request body", zap.Error(err))
+		s.writeError(w, http.StatusUnprocessableEntity, constants.ErrInvalidRequestBody)
+		return
+	}
+
+	funcs, err := s.userFunctions(owner)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFunction)
+		return
+	}
+	def, err := calculation.ParseDefinition(req.Definition, funcs)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("invalid function definition: %v", err))
+		return
+	}
+	if _, exists := funcs[def.Name]; exists {
+		s.writeError(w, http.StatusConflict, fmt.Sprintf("Function %s already exists", def.Name))
+		return
+	}
+
+	function := &models.Function{
+		ID:         uuid.New().String(),
+		Owner:      owner,
+		Name:       def.Name,
+		Parameters: def.Params,
+		Body:       def.Body,
+		CreatedAt:  time.Now(),
+	}
+	if function.Parameters == nil {
+		function.Parameters = []string{}
+	}
+
+	if err := s.sqlite.SaveFunction(s.logger, function); err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+			s.writeError(w, http.StatusConflict, fmt.Sprintf("Function %s already exists", def.Name))
+			return
+		}
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedSaveFunction)
+		return
+	}
+
+	s.logger.Info("Function created",
+		zap.String(constants.FieldLogin, owner),
+		zap.String(constants.FieldFunction, def.String()))
+
+	s.writeJSON(w, http.StatusCreated, models.FunctionResponse{Function: *function})
+}
+
+func (s *Server) handleListFunctions(w http.ResponseWriter, r *http.Request) {
+	owner, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, constants.ErrUnauthorized)
+		return
+	}
+
+	functions, err := s.sqlite.ListFunctions(s.logger, owner)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFunction)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, models.FunctionsResponse{Functions: functions})
+}
+
+func (s *Server) handleGetFunction(w http.ResponseWriter, r *http.Request) {
+	owner, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, constants.ErrUnauthorized)
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	function, err := s.sqlite.GetFunction(s.logger, owner, name)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFunction)
+		return
+	}
+	if function == nil {
+		s.writeError(w, http.StatusNotFound, constants.ErrFunctionNotFound)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, models.FunctionResponse{Function: *function})
+}
+
+// handleDeleteFunction удаляет функцию, если её не вызывают другие функции
+// и формулы пользователя: иначе их тела перестали бы разбираться.
+func (s *Server) handleDeleteFunction(w http.ResponseWriter, r *http.Request) {
+	owner, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, constants.ErrUnauthorized)
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	funcs, err := s.userFunctions(owner)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFunction)
+		return
+	}
+	delete(funcs, name)
+	for _, other := range funcs {
+		if _, err := calculation.ParseDefinition(other.String(), funcs); err != nil {
+			s.writeError(w, http.StatusConflict, fmt.Sprintf("Function %s is used by function %s", name, other.Name))
+			return
+		}
+	}
+
+	formulas, err := s.sqlite.ListFormulas(s.logger, owner)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFormula)
+		return
+	}
+	for _, formula := range formulas {
+		if _, err := calculation.ParseWith(formula.Body, funcs); err != nil {
+			s.writeError(w, http.StatusConflict, fmt.Sprintf("Function %s is used by formula %s", name, formula.Name))
+			return
+		}
+	}
+
+	deleted, err := s.sqlite.DeleteFunction(s.logger, owner, name)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedDeleteFunction)
+		return
+	}
+	if !deleted {
+		s.writeError(w, http.StatusNotFound, constants.ErrFunctionNotFound)
+		return
+	}
+
+	s.logger.Info("Function deleted",
+		zap.String(constants.FieldLogin, owner),
+		zap.String(constants.FieldFunction, name))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userFunctions загружает функции пользователя owner в виде, в котором их
+// подставляет в выражения парсер.
+func (s *Server) userFunctions(owner string) (calculation.Definitions, error) {
+	functions, err := s.sqlite.ListFunctions(s.logger, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := make(calculation.Definitions, len(functions))
+	for _, function := range functions {
+		funcs[function.Name] = &calculation.Definition{
+			Name:   function.Name,
+			Params: function.Parameters,
+			Body:   function.Body,
+		}
+	}
+	return funcs, nil
+}
+
+// requestFunctions возвращает функции пользователя, от имени которого
+// пришёл запрос, или nil, если пользователь неизвестен.
+func (s *Server) requestFunctions(r *http.Request) (calculation.Definitions, error) {
+	owner, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		return nil, nil
+	}
+	return s.userFunctions(owner)
+}
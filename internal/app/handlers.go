@@ -9,9 +9,11 @@ import (
 
 	"github.com/structxz/calc_v3/internal/app/models"
 	"github.com/structxz/calc_v3/internal/auth"
+	"github.com/structxz/calc_v3/internal/cache"
 	"github.com/structxz/calc_v3/internal/constants"
 	"github.com/structxz/calc_v3/internal/db/sqlite"
 	"github.com/structxz/calc_v3/internal/jwtutil"
+	"github.com/structxz/calc_v3/pkg/calculation"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -34,31 +36,185 @@ func (s *Server) handleCalculate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := s.parseExpression(req.Expression)
+	syntax := calculation.Syntax(req.Syntax)
+	if err := syntax.Validate(); err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	locale, err := requestLocale(req.Locale)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	// Десятичные запятые заменяются точками без сдвига символов, поэтому
+	// позиции ошибок разбора указывают в исходный текст.
+	expression, err := locale.Delocalize(req.Expression)
+	if err != nil {
+		s.writeExpressionError(w, fmt.Errorf("invalid expression: %w", err), req.Expression)
+		return
+	}
+
+	funcs, err := s.requestFunctions(r)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFunction)
+		return
+	}
+
+	arith, err := newArithmetic(expression, syntax, req.Precision, req.Scale, funcs)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	tree, err := s.parseExpression(expression, syntax, req.Variables, arith, funcs)
 	if err != nil {
 		s.logger.Error(constants.LogFailedParseExpression,
 			zap.String(constants.FieldExpression, req.Expression),
 			zap.Error(err))
 
+		s.writeExpressionError(w, err, req.Expression)
+		return
+	}
+
+	unit, err := resultUnit(tree, req.Variables, req.OutputUnit, arith)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	format := calculation.Notation(req.OutputFormat)
+	if err := format.Validate(); err != nil {
 		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 
-	expr := &models.Expression{
-		ID:         uuid.New().String(),
-		Expression: req.Expression,
-		Status:     models.StatusPending,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+	expr, err := s.submitExpression(submission{
+		expression:  req.Expression,
+		source:      expression,
+		syntax:      syntax,
+		vars:        req.Variables,
+		arith:       arith,
+		unit:        unit,
+		format:      format,
+		locale:      locale,
+		strictOrder: req.StrictOrder,
+		noCache:     req.NoCache,
+		funcs:       funcs,
+	})
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedSaveExpression)
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, models.CalculateResponse{ID: expr.ID})
+}
+
+// newArithmetic выбирает режим вычислений по полям precision и scale запроса.
+// Если режим не указан, а в выражении есть мнимые числа (2i), выражение
+// вычисляется в режиме complex. Для обычного режима float возвращает nil.
+// syntax — синтаксис, в котором записано выражение, funcs — функции
+// пользователя, которые оно может вызывать.
+func newArithmetic(expression string, syntax calculation.Syntax, precision string, scale *int, funcs calculation.Definitions) (*calculation.Arithmetic, error) {
+	mode := calculation.Mode(precision)
+	if mode == "" {
+		if tree, err := calculation.ParseSyntax(expression, syntax, funcs); err == nil && calculation.HasImaginary(tree) {
+			mode = calculation.ModeComplex
+		}
+	}
+
+	digits := calculation.DefaultScale
+	if scale != nil {
+		digits = *scale
+	}
+	return calculation.NewArithmetic(mode, digits)
+}
+
+// requestLocale возвращает локаль из поля locale запроса. Без поля локали
+// нет: заголовок Accept-Language не учитывается, чтобы смысл запятых в
+// выражении не зависел от настроек браузера.
+func requestLocale(field string) (calculation.Locale, error) {
+	if field == "" {
+		return calculation.Locale{}, nil
+	}
+	return calculation.ParseLocale(field)
+}
+
+// resultUnit проверяет размерности выражения и возвращает единицу, в которой
+// будет выдан результат: outputUnit из запроса, а если он не указан —
+// каноническую единицу СИ (m/s, N). У безразмерного результата единицы нет.
+// Единицы измерения поддерживаются только в режиме float.
+func resultUnit(tree calculation.Node, vars calculation.Variables, outputUnit string, arith *calculation.Arithmetic) (string, error) {
+	if arith != nil && outputUnit != "" {
+		return "", fmt.Errorf("output_unit %s", constants.ErrRequiresFloatMode)
 	}
 
-	err = s.sqlite.SaveExpression(s.logger, expr)
+	dim, err := calculation.Dimensions(tree, vars)
 	if err != nil {
+		return "", fmt.Errorf("invalid expression: %w", err)
+	}
+	if outputUnit == "" {
+		return dim.String(), nil
+	}
+	if _, err := calculation.ConvertUnit(dim, outputUnit); err != nil {
+		return "", err
+	}
+	return outputUnit, nil
+}
+
+// submission — проверенное выражение со всем, что нужно для его
+// вычисления. Его собирают обработчики запросов и передают submitExpression.
+type submission struct {
+	// expression сохраняется в том виде, в каком его отправили, а
+	// разбирается source — то же выражение с точками вместо десятичных
+	// запятых локали. Пустой source означает, что разбирается expression.
+	expression string
+	source     string
+	syntax     calculation.Syntax // синтаксис выражения, пустой для инфиксного
+	vars       map[string]float64
+	arith      *calculation.Arithmetic // режим точных вычислений; nil — обычный режим float
+	// unit — единица результата, пустая для безразмерных выражений, format —
+	// нотация, в которой результат выдаётся в поле formatted, locale —
+	// локаль, в которой результат выдаётся в поле localized.
+	unit   string
+	format calculation.Notation
+	locale calculation.Locale
+	// strictOrder запрещает перегруппировку ассоциативных цепочек,
+	// noCache — выполнение задач по результатам из кеша.
+	strictOrder bool
+	noCache     bool
+	funcs       calculation.Definitions // функции пользователя, вызовы которых подставляются в выражение
+}
+
+// submitExpression сохраняет уже проверенное выражение и в фоне раскладывает его на задачи.
+func (s *Server) submitExpression(sub submission) (*models.Expression, error) {
+	source := sub.source
+	if source == "" {
+		source = sub.expression
+	}
+
+	expr := &models.Expression{
+		ID:          uuid.New().String(),
+		Expression:  sub.expression,
+		Syntax:      string(sub.syntax),
+		Variables:   sub.vars,
+		Unit:        sub.unit,
+		Format:      string(sub.format),
+		Locale:      sub.locale.Tag,
+		StrictOrder: sub.strictOrder,
+		NoCache:     sub.noCache,
+		Status:      models.StatusPending,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if sub.arith != nil {
+		expr.Precision = string(sub.arith.Mode())
+		expr.Scale = sub.arith.Scale()
+	}
+
+	if err := s.sqlite.SaveExpression(s.logger, expr); err != nil {
 		s.logger.Error(constants.ErrFailedSaveExpression,
-			zap.String(constants.FieldExpression, req.Expression),
+			zap.String(constants.FieldExpression, sub.expression),
 			zap.Error(err))
-		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedSaveExpression)
-		return
+		return nil, err
 	}
 
 	s.logger.Info("Expression received for calculation",
@@ -66,7 +222,7 @@ func (s *Server) handleCalculate(w http.ResponseWriter, r *http.Request) {
 		zap.String(constants.FieldExpression, expr.Expression))
 
 	go func() {
-		if err := s.processExpression(expr); err != nil {
+		if err := s.processExpression(expr, source, sub.funcs); err != nil {
 			s.logger.Error("Failed to process expression",
 				zap.String("id", expr.ID),
 				zap.String(constants.FieldExpression, expr.Expression),
@@ -78,9 +234,19 @@ func (s *Server) handleCalculate(w http.ResponseWriter, r *http.Request) {
 					zap.Error(updateErr))
 			}
 		}
+
+		// Каноническая запись нужна только списку выражений, поэтому
+		// упрощение не задерживает ни ответ, ни задачи для агентов.
+		if normalized := normalize(source, sub.syntax, sub.funcs, sub.arith); normalized != "" {
+			if err := s.sqlite.UpdateExpressionNormalized(s.logger, expr.ID, normalized); err != nil {
+				s.logger.Error("Failed to save normalized expression",
+					zap.String("id", expr.ID),
+					zap.Error(err))
+			}
+		}
 	}()
 
-	s.writeJSON(w, http.StatusCreated, models.CalculateResponse{ID: expr.ID})
+	return expr, nil
 }
 
 func (s *Server) handleListExpressions(w http.ResponseWriter, _ *http.Request) {
@@ -119,10 +285,16 @@ func (s *Server) handleGetExpression(w http.ResponseWriter, r *http.Request) {
 	s.logger.Info("Expression retrieved",
 		zap.String("id", id),
 		zap.String("status", string(expr.Status)),
-		zap.String("result", fmt.Sprintf("%v", *expr.Result)))
+		zap.Any("result", expr.Result))
 	s.writeJSON(w, http.StatusOK, models.ExpressionResponse{Expression: *expr})
 }
 
+// handleCacheStats возвращает счётчики кеша результатов задач: сколько раз
+// оркестратор выполнил задачу сам и сколько раз отдал её агенту.
+func (s *Server) handleCacheStats(w http.ResponseWriter, _ *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string]cache.Stats{"cache": s.results.Stats()})
+}
+
 func (s *Server) handleGetTask(w http.ResponseWriter, _ *http.Request) {
 	task, err := s.sqlite.GetNextTask(s.logger)
 	if err != nil {
@@ -156,7 +328,7 @@ func (s *Server) handleSubmitTaskResult(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := s.sqlite.UpdateTaskResult(s.logger, req.ID, req.Result); err != nil {
+	if err := s.sqlite.UpdateTaskResult(s.logger, req.ID, req.Result, req.ExactResult); err != nil {
 		s.logger.Error("Failed to update task result",
 			zap.String("task_id", req.ID),
 			zap.Error(err))
@@ -189,16 +361,11 @@ func (s *Server) handleSubmitTaskResult(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		result, err := s.sqlite.GetFinalTaskResult(exprID)
-		if err != nil {
+		if err := s.completeExpression(exprID); err != nil {
 			s.logger.Error("Failed to get final result", zap.Error(err))
 			return
 		}
 
-		if err := s.sqlite.UpdateExpressionResult(s.logger, exprID, result); err != nil {
-			s.logger.Error("Failed to update expression result", zap.Error(err))
-		}
-
 		s.logger.Info("All tasks completed — expression marked as done",
 			zap.String("expression_id", exprID))
 	}
@@ -206,6 +373,27 @@ func (s *Server) handleSubmitTaskResult(w http.ResponseWriter, r *http.Request)
 	s.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// completeExpression сохраняет итог выражения, все задачи которого выполнены:
+// результат корневой задачи или вектор/матрицу из результатов нескольких задач.
+func (s *Server) completeExpression(exprID string) error {
+	array, err := s.sqlite.GetFinalArrayResult(exprID)
+	if err != nil {
+		return err
+	}
+	if array != nil {
+		return s.sqlite.UpdateExpressionArrayResult(s.logger, exprID, array)
+	}
+
+	result, exact, err := s.sqlite.GetFinalTaskResult(exprID)
+	if err != nil {
+		return err
+	}
+	if err := s.sqlite.UpdateExpressionResult(s.logger, exprID, result, exact); err != nil {
+		s.logger.Error("Failed to update expression result", zap.Error(err))
+	}
+	return nil
+}
+
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	request, err := checkRightCreds(w, r, s)
 	if err != nil {
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -9,16 +10,48 @@ var (
 	StatusProgress string = "IN_PROGRESS"
 	StatusComplete string = "COMPLETE"
 	StatusError    string = "ERROR"
+	StatusSkipped  string = "SKIPPED" // задача невыбранной ветки условного выражения
 )
 
 type Expression struct {
-	ID         string    `json:"id"`
-	Expression string    `json:"expression,omitempty"`
-	Status     string    `json:"status"`
-	Result     *float64  `json:"result,omitempty"`
-	CreatedAt  time.Time `json:"-"`
-	UpdatedAt  time.Time `json:"-"`
-	Error      string    `json:"error,omitempty"`
+	ID          string             `json:"id"`
+	Expression  string             `json:"expression,omitempty"`
+	Syntax      string             `json:"syntax,omitempty"`
+	Normalized  string             `json:"normalized,omitempty"`
+	Variables   map[string]float64 `json:"variables,omitempty"`
+	Precision   string             `json:"precision,omitempty"`
+	Scale       int                `json:"scale,omitempty"`
+	StrictOrder bool               `json:"strict_order,omitempty"`
+	NoCache     bool               `json:"no_cache,omitempty"`
+	Status      string             `json:"status"`
+	Result      *float64           `json:"result,omitempty"`
+	Unit        string             `json:"unit,omitempty"`
+	Format      string             `json:"output_format,omitempty"`
+	Formatted   string             `json:"formatted,omitempty"`
+	Locale      string             `json:"locale,omitempty"`
+	Localized   string             `json:"localized,omitempty"`
+	Array       json.RawMessage    `json:"array,omitempty"`
+	ExactResult string             `json:"exact_result,omitempty"`
+	Complex     *Complex           `json:"complex,omitempty"`
+	TasksSaved  int                `json:"tasks_saved,omitempty"`
+	CreatedAt   time.Time          `json:"-"`
+	UpdatedAt   time.Time          `json:"-"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// ArrayLayout — раскладка результата-вектора или матрицы: форма и для
+// каждого элемента либо значение константы, либо ID задачи, результатом
+// которой он станет (пустой ID у констант).
+type ArrayLayout struct {
+	Shape   []int     `json:"shape"`
+	Values  []float64 `json:"values"`
+	TaskIDs []string  `json:"task_ids"`
+}
+
+// Complex — результат выражения в режиме complex.
+type Complex struct {
+	Re float64 `json:"re"`
+	Im float64 `json:"im"`
 }
 
 type Task struct {
@@ -27,24 +60,102 @@ type Task struct {
 	Operation        string    `json:"operation"`
 	Arg1             float64   `json:"arg1"`
 	Arg2             float64   `json:"arg2"`
+	Arg3             float64   `json:"arg3,omitempty"`
+	Arg1TaskID       string    `json:"arg1_task_id,omitempty"`
+	Arg2TaskID       string    `json:"arg2_task_id,omitempty"`
+	Arg3TaskID       string    `json:"arg3_task_id,omitempty"`
+	Arg1Exact        string    `json:"arg1_exact,omitempty"`
+	Arg2Exact        string    `json:"arg2_exact,omitempty"`
+	Arg3Exact        string    `json:"arg3_exact,omitempty"`
+	Precision        string    `json:"precision,omitempty"`
+	Scale            int       `json:"scale,omitempty"`
 	Result           *float64  `json:"result,omitempty"`
+	ExactResult      string    `json:"exact_result,omitempty"`
 	Status           string    `json:"status"`
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
 	DependsOnTaskIDs []string  `json:"depends_on_task_ids,omitempty"`
+
+	// NoCache запрещает брать результат задачи из кеша и класть его туда:
+	// выражение отправлено с no_cache.
+	NoCache bool `json:"-"`
+	// CacheKey — ключ кеша, под которым сохраняется результат задачи,
+	// отданной агенту; пустой, если результат в кеш не попадает.
+	CacheKey string `json:"-"`
+
+	// Задача ветки условного выражения выполняется, только если результат
+	// задачи-условия GuardTaskID истинен (GuardValue) или ложен (!GuardValue).
+	GuardTaskID string `json:"guard_task_id,omitempty"`
+	GuardValue  bool   `json:"guard_value,omitempty"`
 }
 
 type CalculateRequest struct {
-	Expression string `json:"expression"`
+	Expression   string             `json:"expression"`
+	Syntax       string             `json:"syntax,omitempty"`
+	Variables    map[string]float64 `json:"variables,omitempty"`
+	Precision    string             `json:"precision,omitempty"`
+	Scale        *int               `json:"scale,omitempty"`
+	OutputUnit   string             `json:"output_unit,omitempty"`
+	OutputFormat string             `json:"output_format,omitempty"`
+	Locale       string             `json:"locale,omitempty"`
+	StrictOrder  bool               `json:"strict_order,omitempty"`
+	NoCache      bool               `json:"no_cache,omitempty"`
+}
+
+// SyntaxError — синтаксическая ошибка в выражении: где она (смещение и
+// длина в байтах), что ожидалось на этом месте и текст выражения с
+// подчёркнутым местом ошибки.
+type SyntaxError struct {
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	Offset   int      `json:"offset"`
+	Length   int      `json:"length"`
+	Expected []string `json:"expected,omitempty"`
+	Snippet  string   `json:"snippet"`
+}
+
+type ErrorResponse struct {
+	Error       string       `json:"error"`
+	SyntaxError *SyntaxError `json:"syntax_error,omitempty"`
 }
 
 type CalculateResponse struct {
 	ID string `json:"id"`
 }
 
+// DeriveRequest — запрос производной выражения по переменной. Если указан
+// At, производная вычисляется в этой точке как обычное выражение.
+type DeriveRequest struct {
+	Expression string             `json:"expression"`
+	Variable   string             `json:"variable"`
+	At         map[string]float64 `json:"at,omitempty"`
+	Precision  string             `json:"precision,omitempty"`
+	Scale      *int               `json:"scale,omitempty"`
+}
+
+type DeriveResponse struct {
+	Variable   string `json:"variable"`
+	Derivative string `json:"derivative"`
+	ID         string `json:"id,omitempty"`
+}
+
+// SimplifyRequest — запрос упрощения выражения. Format задаёт, в каком
+// виде вернуть результат помимо текста: "latex" или "mathml".
+type SimplifyRequest struct {
+	Expression string `json:"expression"`
+	Format     string `json:"format,omitempty"`
+}
+
+type SimplifyResponse struct {
+	Simplified string `json:"simplified"`
+	LaTeX      string `json:"latex,omitempty"`
+	MathML     string `json:"mathml,omitempty"`
+}
+
 type TaskResult struct {
-	ID     string  `json:"id"`
-	Result float64 `json:"result"`
+	ID          string  `json:"id"`
+	Result      float64 `json:"result"`
+	ExactResult string  `json:"exact_result,omitempty"`
 }
 
 type ExpressionResponse struct {
@@ -70,3 +181,75 @@ type RegisterRequest struct {
 }
 
 type FieldUser string
+
+// Formula — сохранённая пользователем именованная формула с параметрами.
+type Formula struct {
+	ID         string    `json:"-"`
+	Owner      string    `json:"-"`
+	Name       string    `json:"name"`
+	Body       string    `json:"body"`
+	Parameters []string  `json:"parameters"`
+	Version    int       `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// FormulaVersion — одна из версий тела формулы.
+type FormulaVersion struct {
+	Version    int       `json:"version"`
+	Body       string    `json:"body"`
+	Parameters []string  `json:"parameters"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type FormulaRequest struct {
+	Name       string   `json:"name"`
+	Body       string   `json:"body"`
+	Parameters []string `json:"parameters"`
+}
+
+type FormulaEvaluateRequest struct {
+	Parameters   map[string]float64 `json:"parameters"`
+	Precision    string             `json:"precision,omitempty"`
+	Scale        *int               `json:"scale,omitempty"`
+	OutputUnit   string             `json:"output_unit,omitempty"`
+	OutputFormat string             `json:"output_format,omitempty"`
+	Locale       string             `json:"locale,omitempty"`
+	StrictOrder  bool               `json:"strict_order,omitempty"`
+	NoCache      bool               `json:"no_cache,omitempty"`
+}
+
+type FormulaResponse struct {
+	Formula Formula `json:"formula"`
+}
+
+type FormulasResponse struct {
+	Formulas []Formula `json:"formulas"`
+}
+
+type FormulaVersionsResponse struct {
+	Versions []FormulaVersion `json:"versions"`
+}
+
+// Function — функция пользователя вида f(x, y) = x^2 + y, которую можно
+// вызывать в его выражениях и формулах.
+type Function struct {
+	ID         string    `json:"-"`
+	Owner      string    `json:"-"`
+	Name       string    `json:"name"`
+	Parameters []string  `json:"parameters"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type FunctionRequest struct {
+	Definition string `json:"definition"`
+}
+
+type FunctionResponse struct {
+	Function Function `json:"function"`
+}
+
+type FunctionsResponse struct {
+	Functions []Function `json:"functions"`
+}
@@ -3,7 +3,9 @@ package server
 import (
 	"github.com/structxz/calc_v3/internal/app/models"
 	"github.com/structxz/calc_v3/internal/constants"
+	"github.com/structxz/calc_v3/pkg/calculation"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -12,8 +14,15 @@ import (
 	"go.uber.org/zap"
 )
 
-func (s *Server) processExpression(expr *models.Expression) error {
-	tokens, err := s.parseExpression(expr.Expression)
+func (s *Server) processExpression(expr *models.Expression, source string, funcs calculation.Definitions) error {
+	arith, err := calculation.NewArithmetic(calculation.Mode(expr.Precision), expr.Scale)
+	if err != nil {
+		return err
+	}
+
+	// Выражение хранится в том виде, в каком его отправили, а разбирается
+	// source — с точками вместо десятичных запятых локали.
+	tree, err := s.parseExpression(source, calculation.Syntax(expr.Syntax), expr.Variables, arith, funcs)
 	if err != nil {
 		s.logger.Error(constants.ErrFailedParseExpression,
 			zap.String("expression", expr.Expression),
@@ -31,7 +40,18 @@ func (s *Server) processExpression(expr *models.Expression) error {
 		return err
 	}
 
-	tasks, err := s.createTasks(expr.ID, tokens)
+	// Агенты считают в единицах СИ; если результат выдаётся в другой
+	// единице (km/h), его остаётся поделить на её множитель.
+	toUnit := 1.0
+	if expr.Unit != "" {
+		unit, err := calculation.ParseUnit(expr.Unit)
+		if err != nil {
+			return err
+		}
+		toUnit = unit.Factor
+	}
+
+	tasks, result, err := s.createTasks(expr, tree, arith, toUnit)
 	if err != nil {
 		s.logger.Error(constants.ErrFailedCreateTasks, zap.Error(err))
 
@@ -41,244 +61,590 @@ func (s *Server) processExpression(expr *models.Expression) error {
 		}
 		return err
 	}
+	if expr.TasksSaved > 0 {
+		if err := s.sqlite.UpdateExpressionTasksSaved(s.logger, expr.ID, expr.TasksSaved); err != nil {
+			return err
+		}
+	}
 
-	for _, task := range tasks {
-		if err := s.sqlite.SaveTask(s.logger, task); err != nil {
-			s.logger.Error(constants.ErrFailedSaveTask, zap.Error(err))
-			return fmt.Errorf("failed to save task: %w", err)
+	// Вектор или матрицу собираем из результатов нескольких задач, поэтому
+	// раскладку сохраняем до того, как задачи станут доступны агентам.
+	if !result.IsScalar() {
+		layout := models.ArrayLayout{Shape: result.Shape}
+		for _, x := range result.Elems {
+			layout.Values = append(layout.Values, x.value)
+			layout.TaskIDs = append(layout.TaskIDs, x.taskID)
 		}
+		if err := s.sqlite.SaveArrayLayout(s.logger, expr.ID, layout); err != nil {
+			return err
+		}
+	}
 
-		for _, depID := range task.DependsOnTaskIDs {
-			if err := s.sqlite.SaveTaskDependencies(s.logger, task.ID, depID); err != nil {
-				s.logger.Error(constants.ErrFailedSaveTaskDependency,
-					zap.String("task_id", task.ID),
-					zap.String("depends_on", depID),
-					zap.Error(err))
-				return fmt.Errorf("failed to save task dependency: %w", err)
+	// Выражение без операций (например, "-5" или "[1, 2]") агентам отдавать
+	// нечего — сразу сохраняем его значение как результат.
+	if len(tasks) == 0 {
+		if !result.IsScalar() {
+			array, err := s.sqlite.GetFinalArrayResult(expr.ID)
+			if err != nil {
+				return err
 			}
+			return s.sqlite.UpdateExpressionArrayResult(s.logger, expr.ID, array)
 		}
+
+		x := result.Elems[0]
+		return s.sqlite.UpdateExpressionResult(s.logger, expr.ID, x.value, x.exact)
+	}
+
+	if err := s.sqlite.SaveTasks(s.logger, tasks); err != nil {
+		s.logger.Error(constants.ErrFailedSaveTask, zap.Error(err))
+		return fmt.Errorf("failed to save tasks: %w", err)
 	}
 
 	s.logger.Info("Expression successfully processed",
 		zap.String("expression_id", expr.ID),
-		zap.Int("task_count", len(tasks)))
+		zap.Int("task_count", len(tasks)),
+		zap.Int("tasks_saved", expr.TasksSaved))
 
 	return nil
 }
 
-func (s *Server) parseExpression(expression string) ([]string, error) {
+// parseExpression разбирает выражение, записанное в синтаксисе syntax
+// (пустой — инфиксный), общим парсером из pkg/calculation и проверяет, что для всех переменных выражения переданы значения, формы
+// векторов и матриц согласованы, а все функции доступны в выбранном режиме
+// точности (arith, nil — режим float). Вызовы функций пользователя из funcs
+// парсер разворачивает на месте.
+func (s *Server) parseExpression(expression string, syntax calculation.Syntax, vars calculation.Variables, arith *calculation.Arithmetic, funcs calculation.Definitions) (calculation.Node, error) {
 	if len(expression) == 0 {
 		return nil, fmt.Errorf("invalid request body")
 	}
 
-	var (
-		tokens     []string
-		parenStack int
-	)
+	tree, err := calculation.ParseSyntax(expression, syntax, funcs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
 
-	for i := 0; i < len(expression); i++ {
-		c := expression[i]
-		if c == ' ' {
-			continue
+	if arith != nil {
+		if err := arith.Check(tree); err != nil {
+			return nil, fmt.Errorf("invalid expression: %w", err)
 		}
+	}
+	if _, err := calculation.ShapeOf(tree); err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
 
-		if c == '(' {
-			tokens = append(tokens, "(")
-			parenStack++
-			continue
-		}
-		if c == ')' {
-			if parenStack == 0 {
-				return nil, fmt.Errorf("invalid expression: unmatched parentheses")
+	var unbound error
+	calculation.Inspect(tree, func(node calculation.Node) bool {
+		switch n := node.(type) {
+		case *calculation.Ident:
+			if arith != nil {
+				_, unbound = arith.Variable(n, vars)
+			} else {
+				_, unbound = vars.Lookup(n)
 			}
-			tokens = append(tokens, ")")
-			parenStack--
-			continue
-		}
-		if isDigit(c) || c == '.' {
-			j := i
-			for j < len(expression) && (isDigit(expression[j]) || expression[j] == '.') {
-				j++
+		case *calculation.ImagLit:
+			if arith == nil {
+				unbound = fmt.Errorf("imaginary number %s at position %d %s", n.Literal, n.ValuePos, constants.ErrImaginaryRequiresComplex)
 			}
-			tokens = append(tokens, expression[i:j])
-			i = j - 1
-			continue
 		}
-		if isOperator(string(c)) {
-			if i > 0 && isOperator(string(expression[i-1])) && !(expression[i-1] == '(' && c == '-') {
-				if c == '-' && expression[i-1] == '-' {
-					return nil, fmt.Errorf("invalid expression: invalid structure")
-				}
-			}
-			if c == '-' && (i == 0 || isOperator(string(expression[i-1])) || expression[i-1] == '(') {
-				tokens = append(tokens, "-1", "*")
-				continue
-			}
-			tokens = append(tokens, string(c))
-			continue
+		return unbound == nil
+	})
+	if unbound != nil {
+		return nil, fmt.Errorf("invalid expression: %w", unbound)
+	}
+
+	return tree, nil
+}
+
+// createTasks раскладывает дерево выражения на задачи для агентов,
+// подставляя вместо переменных их значения из expr.Variables. В режимах
+// точных вычислений константы передаются агентам ещё и в текстовом виде.
+// Цепочки ассоциативных операций (a+b+c+d, max(a, b, c, d)) раскладываются
+// сбалансированным деревом, чтобы агенты выполняли их параллельно, если
+// только в выражении не запрошен строгий порядок вычислений слева направо
+// и оно не вычисляется в режиме integer или decimal.
+// Величины с единицами переводятся в СИ, а итог делится на toUnit —
+// множитель единицы результата. Вместе с задачами возвращается значение
+// выражения: операнд на каждый элемент, у числа он один. Сколько задач
+// удалось не создавать благодаря оптимизациям taskBuilder, записывается в
+// expr.TasksSaved.
+func (s *Server) createTasks(expr *models.Expression, tree calculation.Node, arith *calculation.Arithmetic, toUnit float64) ([]*models.Task, calculation.Array[operand], error) {
+	// Проверка переполнения в режиме integer не ассоциативна: сумма,
+	// которая слева направо помещается в int64, после перегруппировки
+	// может переполниться в промежуточном результате. В режиме decimal
+	// каждое умножение округляется до scale знаков, и от группировки
+	// зависит, что потеряется при округлении.
+	strict := expr.StrictOrder
+	if arith != nil {
+		switch arith.Mode() {
+		case calculation.ModeInteger, calculation.ModeDecimal:
+			strict = true
 		}
-		return nil, fmt.Errorf("invalid expression: unexpected character '%c'", c)
+	}
+	if !strict {
+		tree = calculation.Rebalance(tree)
 	}
 
-	if parenStack != 0 {
-		return nil, fmt.Errorf("invalid expression: unmatched parentheses")
+	b := &taskBuilder{exprID: expr.ID, vars: expr.Variables, arith: arith, strict: strict}
+	result, err := calculation.Fold[operand](tree, b)
+	if err != nil {
+		return nil, calculation.Array[operand]{}, err
 	}
 
-	for i := 0; i < len(tokens)-1; i++ {
-		if tokens[i] == "(" && tokens[i+1] == ")" {
-			return nil, fmt.Errorf("invalid expression: empty expression")
+	if toUnit != 1 {
+		for i, x := range result.Elems {
+			divisor, err := b.constant(toUnit, "")
+			if err != nil {
+				return nil, calculation.Array[operand]{}, err
+			}
+			if x.taskID == "" {
+				result.Elems[i] = operand{value: x.value / toUnit}
+				continue
+			}
+			result.Elems[i] = b.addTask("/", x, divisor)
 		}
 	}
+	expr.TasksSaved = b.saved
+	return b.tasks, result, nil
+}
+
+// taskBuilder создаёт задачу на каждую операцию выражения. Как
+// calculation.Algebra он получает операции от calculation.Fold в порядке
+// обхода дерева в глубину, поэтому задачи складываются в tasks так, что
+// зависимости всегда идут раньше зависящих от них задач. Операции над
+// векторами и матрицами Fold раскладывает на операции над элементами,
+// и независимые элементы агенты считают параллельно.
+//
+// Прежде чем создать задачу, taskBuilder пробует без неё обойтись:
+// тождества вроде x*1 и x+0 сворачиваются в сам операнд, а одинаковые
+// операции над одинаковыми аргументами ((a+b)*(a+b)) выполняет одна задача
+// с несколькими зависящими от неё. Каждая несозданная задача — это один
+// обмен с агентом, их число копится в saved.
+type taskBuilder struct {
+	exprID string
+	vars   calculation.Variables
+	arith  *calculation.Arithmetic
+	strict bool // строгий порядок вычислений слева направо
+	tasks  []*models.Task
+	saved  int // сколько задач сэкономили свёртка и переиспользование
+
+	// guards — условия и ветки, внутри которых сейчас создаются задачи,
+	// от внешней к внутренней; задачи получают последнее из них. Пустой
+	// стек означает, что задачи выполняются безусловно.
+	guards []taskGuard
+	// created сопоставляет ключу операции (см. taskKey) уже созданные для
+	// неё задачи.
+	created map[string][]*models.Task
+	// conditions сопоставляет задаче-условию задачу, результат которой
+	// ровно 1 или 0; для сравнений и логических операций это она сама.
+	conditions map[string]string
+	// nonZero сопоставляет задаче-условию созданную для неё задачу
+	// cond != 0 (см. condition).
+	nonZero map[string]*models.Task
+}
 
-	for i := 0; i < len(tokens)-1; i++ {
-		if isOperator(tokens[i]) && tokens[i+1] == ")" {
-			return nil, fmt.Errorf("invalid expression: invalid structure")
+// taskGuard — условие, от которого зависит выполнение задачи: она
+// выполняется, только если результат задачи taskID равен value.
+type taskGuard struct {
+	taskID string
+	value  bool
+}
+
+// operand — аргумент задачи: либо константа, либо ID задачи, результат
+// которой подставится в эту позицию после её выполнения.
+type operand struct {
+	taskID string
+	value  float64
+	exact  string // точное значение константы; пустое в режиме float
+}
+
+// Leaf возвращает операнд-константу для числа, величины или переменной.
+func (b *taskBuilder) Leaf(node calculation.Node) (operand, error) {
+	switch n := node.(type) {
+	case *calculation.NumberLit:
+		return b.constant(n.Value, n.Decimal())
+	case *calculation.QuantityExpr:
+		if b.arith != nil {
+			return operand{}, fmt.Errorf("unit %s at position %d %s", n.UnitText, n.UnitPos, constants.ErrRequiresFloatMode)
 		}
-		if tokens[i] == "(" && isOperator(tokens[i+1]) {
-			return nil, fmt.Errorf("invalid expression: invalid structure")
+		return b.constant(n.Value(), "")
+	case *calculation.ImagLit:
+		if b.arith == nil {
+			return operand{}, fmt.Errorf("imaginary number %s at position %d %s", n.Literal, n.ValuePos, constants.ErrImaginaryRequiresComplex)
+		}
+		return b.constant(0, n.Decimal())
+	case *calculation.Ident:
+		if b.arith != nil {
+			text, err := b.arith.Variable(n, b.vars)
+			if err != nil {
+				return operand{}, err
+			}
+			return b.constant(0, text)
 		}
-	}
 
-	operands, operators := 0, 0
-	for _, token := range tokens {
-		if isOperator(token) {
-			operators++
-			continue
+		value, err := b.vars.Lookup(n)
+		if err != nil {
+			return operand{}, err
 		}
-		if token != "(" && token != ")" {
-			operands++
+		return b.constant(value, "")
+	default:
+		return operand{}, fmt.Errorf("unsupported expression node %T", node)
+	}
+}
+
+// Negate сворачивает отрицание константы сразу, а отрицание результата
+// задачи превращает в умножение на -1.
+func (b *taskBuilder) Negate(x operand) (operand, error) {
+	if x.taskID == "" {
+		return b.negate(x)
+	}
+	minusOne, err := b.constant(-1, "-1")
+	if err != nil {
+		return operand{}, err
+	}
+	return b.addTask("*", minusOne, x), nil
+}
+
+// Binary создаёт задачу для бинарного оператора, если его не удаётся
+// свернуть по тождеству.
+func (b *taskBuilder) Binary(op string, x, y operand) (operand, error) {
+	if !isOperator(op) {
+		return operand{}, fmt.Errorf("operator '%s' is not supported", op)
+	}
+	if result, ok, err := b.identity(op, x, y); ok || err != nil {
+		if ok {
+			b.saved++
 		}
+		return result, err
 	}
+	result := b.addTask(op, x, y)
+	if isLogical(op) {
+		b.setCondition(result.taskID, result.taskID)
+	}
+	return result, nil
+}
 
-	if operators == 0 {
-		return nil, fmt.Errorf("invalid expression: too few tokens")
+// Truth сообщает значение условия, если оно константа: тогда задачи
+// создаются только для выбранной ветки.
+func (b *taskBuilder) Truth(cond operand) (bool, bool) {
+	if cond.taskID != "" {
+		return false, false
+	}
+	if b.arith != nil {
+		zero, err := b.arith.Literal("0")
+		return cond.exact != zero, err == nil
 	}
+	return cond.value != 0, true
+}
 
-	if len(tokens) == 1 && isOperator(tokens[0]) {
-		return nil, fmt.Errorf("invalid expression: too few tokens")
+// Branch создаёт задачи ветки условного выражения так, что агенты получат
+// их, только если условие cond примет значение taken. Остальные задачи
+// невыбранной ветки хранилище пропускает, когда вычислено условие.
+func (b *taskBuilder) Branch(cond operand, taken bool, fold func() (calculation.Array[operand], error)) (calculation.Array[operand], error) {
+	guard, err := b.condition(cond)
+	if err != nil {
+		return calculation.Array[operand]{}, err
 	}
 
-	if len(tokens) > 1 && isOperator(tokens[len(tokens)-1]) {
-		if len(tokens) == 2 {
-			return nil, fmt.Errorf("invalid expression: too few tokens")
+	b.guards = append(b.guards, taskGuard{taskID: guard.taskID, value: taken})
+	defer func() { b.guards = b.guards[:len(b.guards)-1] }()
+	return fold()
+}
+
+// Select создаёт задачу, которая выбирает значение выполненной ветки.
+func (b *taskBuilder) Select(cond, x, y operand) (operand, error) {
+	guard, err := b.condition(cond)
+	if err != nil {
+		return operand{}, err
+	}
+	return b.addTask(calculation.SelectOp, guard, x, y), nil
+}
+
+// condition приводит условие к задаче, результат которой ровно 1 или 0:
+// по нему хранилище решает, какую ветку пропустить. Сравнения и логические
+// операции уже дают 1 или 0, для остальных значений добавляется задача
+// cond != 0. Условное выражение запрашивает её трижды — для обеих веток и
+// для выбора значения, — поэтому она запоминается за cond и переиспользуется
+// без учёта в saved: это одна задача, а не сэкономленная повторная. Чужую
+// ветку запомненная задача не обслуживает: там она может оказаться
+// пропущенной, и тогда создаётся новая.
+func (b *taskBuilder) condition(cond operand) (operand, error) {
+	if taskID, ok := b.conditions[cond.taskID]; ok {
+		return operand{taskID: taskID}, nil
+	}
+	if task, ok := b.nonZero[cond.taskID]; ok && b.active(task) {
+		return operand{taskID: task.ID}, nil
+	}
+
+	zero, err := b.constant(0, "0")
+	if err != nil {
+		return operand{}, err
+	}
+	result := b.addTask("!=", cond, zero)
+	if b.nonZero == nil {
+		b.nonZero = make(map[string]*models.Task)
+	}
+	b.nonZero[cond.taskID] = b.task(result.taskID)
+	return result, nil
+}
+
+// task возвращает созданную задачу по её ID.
+func (b *taskBuilder) task(id string) *models.Task {
+	for i := len(b.tasks) - 1; i >= 0; i-- {
+		if b.tasks[i].ID == id {
+			return b.tasks[i]
 		}
-		return nil, fmt.Errorf("invalid expression: trailing operator")
 	}
+	return nil
+}
 
-	if operators > 0 && operands <= 1 {
-		return nil, fmt.Errorf("invalid expression: too few tokens")
+// setCondition запоминает, что условие taskID вычисляет задача conditionID.
+func (b *taskBuilder) setCondition(taskID, conditionID string) {
+	if b.conditions == nil {
+		b.conditions = make(map[string]string)
 	}
+	b.conditions[taskID] = conditionID
+}
 
-	if operands <= operators && operators > 0 {
-		return nil, fmt.Errorf("invalid expression: invalid structure")
+// Call создаёт задачи для вызова функции от чисел.
+func (b *taskBuilder) Call(fn *calculation.Function, args []operand) (operand, error) {
+	if fn.TaskArity() == 1 {
+		return b.addTask(fn.Name, args[0]), nil
 	}
 
-	for _, token := range tokens {
-		if token != "(" && token != ")" && !isOperator(token) && strings.Count(token, ".") > 1 {
-			return nil, fmt.Errorf("invalid expression: invalid number format")
+	// Вариативные функции (min, max) ассоциативны, поэтому раскладываем
+	// вызов на бинарные задачи: max(a, b, c, d) = max(max(a, b), max(c, d)),
+	// а в строгом порядке — цепочкой max(max(max(a, b), c), d).
+	if b.strict {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = b.addTask(fn.Name, result, arg)
 		}
+		return result, nil
 	}
+	return b.reduce(fn.Name, args), nil
+}
 
-	return tokens, nil
+// reduce раскладывает ассоциативную операцию над args сбалансированным деревом задач.
+func (b *taskBuilder) reduce(operation string, args []operand) operand {
+	if len(args) == 1 {
+		return args[0]
+	}
+	mid := len(args) / 2
+	left := b.reduce(operation, args[:mid])
+	right := b.reduce(operation, args[mid:])
+	return b.addTask(operation, left, right)
 }
 
+// constant возвращает операнд-константу. В режиме float это value, в
+// режимах точных вычислений значение без потерь берётся из записи literal.
+func (b *taskBuilder) constant(value float64, literal string) (operand, error) {
+	if b.arith == nil {
+		return operand{value: value}, nil
+	}
 
-func (s *Server) createTasks(exprID string, tokens []string) ([]*models.Task, error) {
-	rpnTokens, err := s.toRPN(tokens)
+	exact, err := b.arith.Literal(literal)
 	if err != nil {
-		return nil, err
+		return operand{}, err
 	}
+	value, err = b.arith.Float(exact)
+	if err != nil {
+		return operand{}, err
+	}
+	return operand{value: value, exact: exact}, nil
+}
 
-	var tasks []*models.Task
-	var stack []interface{}
-
-	for _, token := range rpnTokens {
-		if isOperator(token) {
-			if len(stack) < 2 {
-				return nil, fmt.Errorf("invalid RPN expression: too few operands")
-			}
+// negate сворачивает отрицание константы.
+func (b *taskBuilder) negate(x operand) (operand, error) {
+	if b.arith == nil {
+		return operand{value: -x.value}, nil
+	}
 
-			op2 := stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
-			op1 := stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
-
-			task := &models.Task{
-				ID:           uuid.New().String(),
-				ExpressionID: exprID,
-				Operation:    token,
-				Status:       models.StatusPending,
-				CreatedAt:    time.Now(),
-				UpdatedAt:    time.Now(),
-			}
+	exact, err := b.arith.Negate(x.exact)
+	if err != nil {
+		return operand{}, err
+	}
+	return operand{value: -x.value, exact: exact}, nil
+}
 
-			switch v := op1.(type) {
-			case float64:
-				task.Arg1 = v
-			case string:
-				task.DependsOnTaskIDs = append(task.DependsOnTaskIDs, v)
-			}
+// identity сворачивает операцию, результат которой известен без агента:
+// x*1, 1*x, x/1, x+0, 0+x, x-0 и x^1 равны x, а 0*y и y^0 — константам,
+// если y тоже константа. Результат задачи y так не сворачиваем: 0*Inf и
+// 0*NaN не равны нулю, а ошибка при вычислении y должна дойти до
+// пользователя. ok сообщает, удалось ли свернуть операцию.
+func (b *taskBuilder) identity(op string, x, y operand) (result operand, ok bool, err error) {
+	isZero := func(x operand) bool { return b.equals(x, 0, "0") }
+	isOne := func(x operand) bool { return b.equals(x, 1, "1") }
+
+	switch op {
+	case "*":
+		switch {
+		case isOne(y):
+			return x, true, nil
+		case isOne(x):
+			return y, true, nil
+		case x.taskID == "" && y.taskID == "" && (isZero(x) || isZero(y)):
+			result, err = b.constant(0, "0")
+			return result, err == nil, err
+		}
+	case "+":
+		switch {
+		case isZero(y):
+			return x, true, nil
+		case isZero(x):
+			return y, true, nil
+		}
+	case "-":
+		if isZero(y) {
+			return x, true, nil
+		}
+	case "/":
+		if isOne(y) {
+			return x, true, nil
+		}
+	case "^":
+		switch {
+		case isOne(y):
+			return x, true, nil
+		case x.taskID == "" && isZero(y):
+			result, err = b.constant(1, "1")
+			return result, err == nil, err
+		}
+	}
+	return operand{}, false, nil
+}
 
-			switch v := op2.(type) {
-			case float64:
-				task.Arg2 = v
-			case string:
-				task.DependsOnTaskIDs = append(task.DependsOnTaskIDs, v)
-			}
+// equals сообщает, что x — константа value (literal в режимах точных
+// вычислений).
+func (b *taskBuilder) equals(x operand, value float64, literal string) bool {
+	if x.taskID != "" {
+		return false
+	}
+	if b.arith == nil {
+		return x.value == value
+	}
+	exact, err := b.arith.Literal(literal)
+	return err == nil && x.exact == exact
+}
 
-			tasks = append(tasks, task)
-			stack = append(stack, task.ID)
-		} else {
-			num, _ := strconv.ParseFloat(token, 64)
-			stack = append(stack, num)
+// addTask создаёт задачу над одним или двумя операндами и возвращает
+// операнд-ссылку на её результат. Если такая же операция над теми же
+// аргументами уже есть и выполняется всегда, когда выполнялась бы новая
+// задача, возвращается ссылка на неё.
+func (b *taskBuilder) addTask(operation string, args ...operand) operand {
+	key := taskKey(operation, args)
+	for _, task := range b.created[key] {
+		if b.active(task) {
+			b.saved++
+			return operand{taskID: task.ID}
 		}
 	}
 
-	if len(stack) != 1 {
-		return nil, fmt.Errorf("invalid RPN expression: too many operands")
+	var guard taskGuard
+	if len(b.guards) > 0 {
+		guard = b.guards[len(b.guards)-1]
+	}
+	task := &models.Task{
+		ID:           uuid.New().String(),
+		ExpressionID: b.exprID,
+		Operation:    operation,
+		Status:       models.StatusPending,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+		GuardTaskID:  guard.taskID,
+		GuardValue:   guard.value,
+	}
+	for index, arg := range args {
+		setTaskArg(task, index, arg)
 	}
 
-	return tasks, nil
+	if b.created == nil {
+		b.created = make(map[string][]*models.Task)
+	}
+	b.created[key] = append(b.created[key], task)
+	b.tasks = append(b.tasks, task)
+	return operand{taskID: task.ID}
 }
 
-func isOperator(token string) bool {
-	switch token {
-	case "+", "-", "*", "/":
+// active сообщает, выполнится ли задача везде, где выполнялась бы задача,
+// созданная сейчас: безусловно или при одном из текущих условий.
+func (b *taskBuilder) active(task *models.Task) bool {
+	if task.GuardTaskID == "" {
 		return true
-	default:
-		return false
 	}
+	return slices.Contains(b.guards, taskGuard{taskID: task.GuardTaskID, value: task.GuardValue})
 }
 
-func isDigit(c byte) bool {
-	return c >= '0' && c <= '9'
+// taskKey описывает операцию над аргументами строкой, одинаковой у задач,
+// которые вычисляют одно и то же. Аргументы коммутативных операций
+// упорядочиваются, чтобы a+b и b+a совпали.
+func taskKey(operation string, args []operand) string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		if arg.taskID != "" {
+			keys[i] = "task:" + arg.taskID
+		} else {
+			keys[i] = strconv.FormatFloat(arg.value, 'g', -1, 64) + ":" + arg.exact
+		}
+	}
+	if isCommutative(operation) {
+		slices.Sort(keys)
+	}
+	return operation + "(" + strings.Join(keys, ", ") + ")"
 }
 
-func (s *Server) toRPN(tokens []string) ([]string, error) {
-	var stack []string
-	var output []string
-
-	for _, token := range tokens {
-		if isOperator(token) {
+// setTaskArg записывает операнд в позицию index (0 — arg1, 1 — arg2, 2 — arg3).
+func setTaskArg(task *models.Task, index int, arg operand) {
+	if arg.taskID != "" {
+		switch index {
+		case 0:
+			task.Arg1TaskID = arg.taskID
+		case 1:
+			task.Arg2TaskID = arg.taskID
+		default:
+			task.Arg3TaskID = arg.taskID
+		}
+		task.DependsOnTaskIDs = append(task.DependsOnTaskIDs, arg.taskID)
+		return
+	}
 
-			for len(stack) > 0 && isOperator(stack[len(stack)-1]) {
-				output = append(output, stack[len(stack)-1])
-				stack = stack[:len(stack)-1]
-			}
-			stack = append(stack, token)
-		} else {
+	switch index {
+	case 0:
+		task.Arg1, task.Arg1Exact = arg.value, arg.exact
+	case 1:
+		task.Arg2, task.Arg2Exact = arg.value, arg.exact
+	default:
+		task.Arg3, task.Arg3Exact = arg.value, arg.exact
+	}
+}
 
-			if _, err := strconv.ParseFloat(token, 64); err != nil {
-				return nil, fmt.Errorf("invalid number: %s", token)
-			}
-			output = append(output, token)
-		}
+// isOperator сообщает, умеют ли агенты выполнять операцию.
+func isOperator(token string) bool {
+	switch token {
+	case "+", "-", "*", "/", "//", "%", "^", "&", "|", "xor", "<<", ">>":
+		return true
+	default:
+		return isLogical(token)
 	}
+}
 
-	for len(stack) > 0 {
-		output = append(output, stack[len(stack)-1])
-		stack = stack[:len(stack)-1]
+// isCommutative сообщает, что результат операции не зависит от порядка
+// двух её аргументов.
+func isCommutative(token string) bool {
+	switch token {
+	case "+", "*", "==", "!=", "&&", "||", "&", "|", "xor", "min", "max":
+		return true
+	default:
+		return false
 	}
+}
 
-	return output, nil
+// isLogical сообщает, является ли операция сравнением или логической
+// операцией, результат которой ровно 1 или 0.
+func isLogical(token string) bool {
+	switch token {
+	case "<", "<=", ">", ">=", "==", "!=", "&&", "||":
+		return true
+	default:
+		return false
+	}
 }
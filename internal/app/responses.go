@@ -2,9 +2,12 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/structxz/calc_v3/internal/app/models"
 	"github.com/structxz/calc_v3/internal/constants"
+	"github.com/structxz/calc_v3/pkg/calculation"
 
 	"go.uber.org/zap"
 )
@@ -24,3 +27,25 @@ func (s *Server) writeError(w http.ResponseWriter, status int, message string) {
 		s.logger.Error("Failed to write error response", zap.Error(err))
 	}
 }
+
+// writeExpressionError отвечает 422 на ошибку в выражении expression. Если
+// это синтаксическая ошибка, в ответе есть её место, чтобы клиент мог его
+// подчеркнуть.
+func (s *Server) writeExpressionError(w http.ResponseWriter, err error, expression string) {
+	var syntaxErr *calculation.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusUnprocessableEntity, models.ErrorResponse{
+		Error: err.Error(),
+		SyntaxError: &models.SyntaxError{
+			Code:     syntaxErr.Code,
+			Message:  syntaxErr.Message,
+			Offset:   int(syntaxErr.Offset),
+			Length:   syntaxErr.Length,
+			Expected: syntaxErr.Expected,
+			Snippet:  syntaxErr.Snippet(expression),
+		},
+	})
+}
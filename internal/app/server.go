@@ -9,6 +9,7 @@ import (
 
 	"github.com/structxz/calc_v3/pkg/api"
 	"github.com/structxz/calc_v3/configs"
+	"github.com/structxz/calc_v3/internal/cache"
 	"github.com/structxz/calc_v3/internal/orchestrator"
 	"github.com/structxz/calc_v3/internal/db/sqlite"
 	"github.com/structxz/calc_v3/internal/logger"
@@ -25,14 +26,16 @@ type Server struct {
 	logger   *logger.Logger
 	restSrv  *http.Server
 	grpcSrv  *grpc.Server
+	results  *cache.Cache // кеш результатов задач, общий для всех выражений
 }
 
 // New создаёт REST + gRPC сервер
 func New(cfg *configs.ServerConfig, log *logger.Logger, sqliteStorage *sqlite.SQLiteStorage) *Server {
 	s := &Server{
-		config: cfg,
-		logger: log,
-		sqlite: sqliteStorage,
+		config:  cfg,
+		logger:  log,
+		sqlite:  sqliteStorage,
+		results: cache.New(int(cfg.ResultCacheSize), time.Duration(cfg.ResultCacheTTLMS)*time.Millisecond),
 	}
 
 	// --- REST setup ---
@@ -47,8 +50,22 @@ func New(cfg *configs.ServerConfig, log *logger.Logger, sqliteStorage *sqlite.SQ
 	protected := router.PathPrefix("/api/v1").Subrouter()
 	protected.Use(middleware.AuthMiddleware(s.logger))
 	protected.HandleFunc("/calculate", s.handleCalculate).Methods(http.MethodPost)
+	protected.HandleFunc("/derive", s.handleDerive).Methods(http.MethodPost)
+	protected.HandleFunc("/simplify", s.handleSimplify).Methods(http.MethodPost)
 	protected.HandleFunc("/expressions", s.handleListExpressions).Methods(http.MethodGet)
 	protected.HandleFunc("/expressions/{id}", s.handleGetExpression).Methods(http.MethodGet)
+	protected.HandleFunc("/formulas", s.handleCreateFormula).Methods(http.MethodPost)
+	protected.HandleFunc("/formulas", s.handleListFormulas).Methods(http.MethodGet)
+	protected.HandleFunc("/formulas/{name}", s.handleGetFormula).Methods(http.MethodGet)
+	protected.HandleFunc("/formulas/{name}", s.handleUpdateFormula).Methods(http.MethodPut)
+	protected.HandleFunc("/formulas/{name}", s.handleDeleteFormula).Methods(http.MethodDelete)
+	protected.HandleFunc("/formulas/{name}/versions", s.handleListFormulaVersions).Methods(http.MethodGet)
+	protected.HandleFunc("/formulas/{name}/evaluate", s.handleEvaluateFormula).Methods(http.MethodPost)
+	protected.HandleFunc("/functions", s.handleCreateFunction).Methods(http.MethodPost)
+	protected.HandleFunc("/functions", s.handleListFunctions).Methods(http.MethodGet)
+	protected.HandleFunc("/functions/{name}", s.handleGetFunction).Methods(http.MethodGet)
+	protected.HandleFunc("/functions/{name}", s.handleDeleteFunction).Methods(http.MethodDelete)
+	protected.HandleFunc("/cache", s.handleCacheStats).Methods(http.MethodGet)
 
 	s.restSrv = &http.Server{
 		Addr:         ":" + cfg.RestPort,
@@ -60,6 +77,12 @@ func New(cfg *configs.ServerConfig, log *logger.Logger, sqliteStorage *sqlite.SQ
 	return s
 }
 
+// Handler возвращает обработчик REST API, чтобы обслуживать запросы без
+// запуска сервера, например в тестах.
+func (s *Server) Handler() http.Handler {
+	return s.restSrv.Handler
+}
+
 // Start запускает gRPC и REST серверы параллельно
 func (s *Server) Start() error {
 	// Запускаем gRPC в отдельной горутине
@@ -70,7 +93,7 @@ func (s *Server) Start() error {
 		}
 
 		s.grpcSrv = grpc.NewServer()
-		api.RegisterOrchestratorServer(s.grpcSrv, orchestrator.New(s.logger, s.sqlite))
+		api.RegisterOrchestratorServer(s.grpcSrv, orchestrator.New(s.logger, s.sqlite, s.results))
 
 		s.logger.Info("gRPC server started", zap.String("port", s.config.GRPCPort))
 		if err := s.grpcSrv.Serve(lis); err != nil {
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/structxz/calc_v3/internal/app/models"
+	"github.com/structxz/calc_v3/internal/constants"
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"go.uber.org/zap"
+)
+
+// handleSimplify возвращает упрощённое выражение в канонической записи и,
+// если запрошено, в LaTeX или MathML.
+func (s *Server) handleSimplify(w http.ResponseWriter, r *http.Request) {
+	var req models.SimplifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode request body", zap.Error(err))
+		s.writeError(w, http.StatusUnprocessableEntity, constants.ErrInvalidRequestBody)
+		return
+	}
+	if req.Expression == "" {
+		s.writeError(w, http.StatusUnprocessableEntity, constants.ErrInvalidRequestBody)
+		return
+	}
+	if len(req.Expression) > maxSimplifyLength {
+		s.writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("%s, the limit is %d characters", constants.ErrExpressionTooLong, maxSimplifyLength))
+		return
+	}
+	switch req.Format {
+	case "", "text", "latex", "mathml":
+	default:
+		s.writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("%s %q", constants.ErrUnknownOutputFormat, req.Format))
+		return
+	}
+
+	funcs, err := s.requestFunctions(r)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedGetFunction)
+		return
+	}
+	tree, err := calculation.ParseWith(req.Expression, funcs)
+	if err != nil {
+		s.writeExpressionError(w, fmt.Errorf("invalid expression: %w", err), req.Expression)
+		return
+	}
+	simplified, err := calculation.Simplify(tree)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("invalid expression: %v", err))
+		return
+	}
+
+	resp := models.SimplifyResponse{Simplified: calculation.Format(simplified)}
+	switch req.Format {
+	case "latex":
+		resp.LaTeX = calculation.FormatLaTeX(simplified)
+	case "mathml":
+		resp.MathML = calculation.FormatMathML(simplified)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// maxSimplifyLength ограничивает длину выражений, которые упрощаются и
+// дифференцируются, и тех, для которых строится каноническая запись: время
+// упрощения растёт быстрее длины выражения.
+const maxSimplifyLength = 1000
+
+// normalize возвращает каноническую запись выражения, по которой в списке
+// выражений легко заметить одинаковые; выражение в синтаксисе rpn или sexpr
+// записывается в инфиксной форме. Для выражения, которое не удалось
+// разобрать, и для слишком длинного она пустая. Константы сворачиваются в
+// режиме arith (nil — режим float), чтобы запись не показывала значение,
+// которого вычисление не даст.
+func normalize(expression string, syntax calculation.Syntax, funcs calculation.Definitions, arith *calculation.Arithmetic) string {
+	if len(expression) > maxSimplifyLength {
+		return ""
+	}
+	tree, err := calculation.ParseSyntax(expression, syntax, funcs)
+	if err != nil {
+		return ""
+	}
+	simplified, err := calculation.SimplifyWith(tree, arith)
+	if err != nil {
+		return ""
+	}
+	return calculation.Format(simplified)
+}
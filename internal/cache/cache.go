@@ -0,0 +1,150 @@
+// Package cache хранит результаты уже вычисленных агентами задач, чтобы
+// задачу с теми же операцией и операндами оркестратор мог выполнить сам,
+// не отправляя её агенту.
+package cache
+
+import (
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/structxz/calc_v3/internal/app/models"
+)
+
+// Result — результат задачи: значение и его точная запись в режимах
+// точных вычислений (пустая в режиме float).
+type Result struct {
+	Value float64
+	Exact string
+}
+
+// Stats — счётчики кеша.
+type Stats struct {
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Entries  int   `json:"entries"`
+	Capacity int   `json:"capacity"`
+	TTL      int64 `json:"ttl_ms"`
+}
+
+// Cache — потокобезопасный кеш результатов задач с ограничением по числу
+// записей и времени жизни. Когда места нет, вытесняется запись, которую
+// дольше всех не запрашивали.
+type Cache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	order    *list.List // от недавно запрошенных к давно запрошенным
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type entry struct {
+	key     string
+	result  Result
+	expires time.Time
+}
+
+// New создаёт кеш на capacity записей, каждая из которых живёт ttl.
+// Кеш с capacity <= 0 ничего не хранит; ttl <= 0 означает, что записи
+// не устаревают.
+func New(capacity int, ttl time.Duration) *Cache {
+	return &Cache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Key возвращает ключ задачи: операцию, операнды и режим вычислений, в
+// котором она выполняется. Задачи с одинаковым ключом дают одинаковый
+// результат. Операнды, которые ещё ждут результата другой задачи, в ключ
+// не входят, поэтому ключ имеет смысл только у задач, готовых к выполнению.
+func Key(task *models.Task) string {
+	var b strings.Builder
+	b.WriteString(task.Precision)
+	b.WriteByte('/')
+	b.WriteString(strconv.Itoa(task.Scale))
+	b.WriteByte('/')
+	b.WriteString(task.Operation)
+	for _, arg := range []struct {
+		value float64
+		exact string
+	}{{task.Arg1, task.Arg1Exact}, {task.Arg2, task.Arg2Exact}, {task.Arg3, task.Arg3Exact}} {
+		b.WriteByte('/')
+		b.WriteString(strconv.FormatFloat(arg.value, 'g', -1, 64))
+		b.WriteByte(':')
+		b.WriteString(arg.exact)
+	}
+	return b.String()
+}
+
+// Get возвращает результат по ключу, если он есть и не устарел.
+func (c *Cache) Get(key string) (Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok && c.ttl > 0 && time.Now().After(elem.Value.(*entry).expires) {
+		c.remove(elem)
+		ok = false
+	}
+	if !ok {
+		c.misses.Add(1)
+		return Result{}, false
+	}
+
+	c.hits.Add(1)
+	c.order.MoveToFront(elem)
+	return elem.Value.(*entry).result, true
+}
+
+// Put сохраняет результат по ключу, при необходимости вытесняя самую
+// давно запрошенную запись.
+func (c *Cache) Put(key string, result Result) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*entry).result = result
+		elem.Value.(*entry).expires = expires
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	for c.order.Len() >= c.capacity {
+		c.remove(c.order.Back())
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, result: result, expires: expires})
+}
+
+// Stats возвращает текущие значения счётчиков.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	entries := c.order.Len()
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Entries:  entries,
+		Capacity: c.capacity,
+		TTL:      c.ttl.Milliseconds(),
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*entry).key)
+}
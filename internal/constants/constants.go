@@ -17,6 +17,43 @@ const (
 	ErrInvalidModulo                     = "modulo operation requires integer operands"
 	ErrUnexpectedEndExpr                 = "unexpected end of expression"
 	ErrMissingCloseParen                 = "missing closing parenthesis"
+	ErrUnknownFunction                   = "unknown function"
+	ErrUnboundVariable                   = "unbound variable"
+	ErrSqrtOfNegative                    = "square root of negative number"
+	ErrLogOfNonPositive                  = "logarithm of non-positive number"
+	ErrNonIntegerExponent                = "exponent must be an integer in exact arithmetic"
+	ErrIrrationalResult                  = "result is not a rational number"
+	ErrComplexResult                     = "result is a complex number"
+	ErrNonFiniteResult                   = "result is not a finite number"
+	ErrResultTooLarge                    = "result is too large"
+	ErrExpressionTooLong                 = "expression is too long"
+	ErrImaginaryRequiresComplex          = "requires complex mode"
+	ErrComplexModulo                     = "modulo is not defined for complex numbers"
+	ErrComplexNotOrdered                 = "complex numbers cannot be compared"
+	ErrLogOfZero                         = "logarithm of zero"
+	ErrUnknownUnit                       = "unknown unit"
+	ErrIncompatibleUnits                 = "incompatible units"
+	ErrRequiresFloatMode                 = "is only supported in float mode"
+	ErrMissingCloseBracket               = "missing closing bracket"
+	ErrMissingColon                      = "missing ':'"
+	ErrMissingResult                     = "script must end with an expression"
+	ErrBuiltinFunction                   = "cannot redefine built-in function"
+	ErrDuplicateParameter                = "duplicate parameter"
+	ErrUndeclaredParameter               = "undeclared parameter"
+	ErrRecursiveFunction                 = "recursive function call"
+	ErrTooManyUserCalls                  = "too many calls of user-defined functions"
+	ErrShapeMismatch                     = "shape mismatch"
+	ErrNotDifferentiable                 = "cannot differentiate"
+	ErrUnknownOutputFormat               = "unknown output format"
+	ErrUnknownLocale                     = "unknown locale"
+	ErrAmbiguousComma                    = "comma is the decimal separator in locale"
+	ErrUnknownSyntax                     = "unknown syntax"
+	ErrMissingOperand                    = "not enough operands"
+	ErrExtraOperand                      = "operand without an operator"
+	ErrInvalidBitwise                    = "bitwise operators require integer operands"
+	ErrIntegerOverflow                   = "integer overflow"
+	ErrNonIntegerResult                  = "result is not an integer"
+	ErrNegativeShift                     = "shift count must be non-negative"
 	ErrFailedProcessExpression           = "Failed to process expression"
 	ErrFailedSaveExpression              = "Failed to save expression"
 	ErrFailedProcessResult               = "Failed to process result"
@@ -44,6 +81,15 @@ const (
 	ErrInvalidLoginPassword              = "Incorrect login or password"
 	ErrJWTNotSet                         = "jwt token is not set, set it in .env file"
 	ErrNoUserFound                       = "No user found with this login"
+	ErrUnauthorized                      = "You are unauthorized, first go through authentication"
+	ErrFormulaNotFound                   = "Formula not found"
+	ErrFailedSaveFormula                 = "Failed to save formula"
+	ErrFailedGetFormula                  = "Failed to get formula"
+	ErrFailedDeleteFormula               = "Failed to delete formula"
+	ErrFunctionNotFound                  = "Function not found"
+	ErrFailedSaveFunction                = "Failed to save function"
+	ErrFailedGetFunction                 = "Failed to get function"
+	ErrFailedDeleteFunction              = "Failed to delete function"
 )
 
 // Log messages used for logging application events.
@@ -70,6 +116,9 @@ const (
 	LogRegistered                 = "Registration was successful"
 	LogAuthenticated              = "Authentication was successful"
 	LogFinalResultReady           = "Final result of expression is ready"
+	LogFailedCalculateTask        = "Failed to calculate task"
+	LogTaskFailed                 = "Task failed on agent"
+	LogTaskFromCache              = "Task completed from result cache"
 )
 
 // HTTP headers and content types used in the application.
@@ -109,6 +158,8 @@ const (
 	FieldLogin           = "login"
 	FieldPassword        = "password"
 	FieldJWT             = "jwt_token"
+	FieldFormula         = "formula"
+	FieldFunction        = "function"
 )
 
 // Parser log messages used during expression parsing.
@@ -2,22 +2,34 @@ package sqlite
 
 import (
 	"database/sql"
+	"encoding/json"
 	"github.com/structxz/calc_v3/internal/app/models"
 	"github.com/structxz/calc_v3/internal/constants"
 	"github.com/structxz/calc_v3/internal/logger"
+	"github.com/structxz/calc_v3/pkg/calculation"
 	"errors"
 	"fmt"
+	"math/big"
+	"strconv"
 	"time"
 
 	"go.uber.org/zap"
 )
 
 func (s *SQLiteStorage) SaveExpression(logger *logger.Logger, expr *models.Expression) error {
+	variables, err := encodeVariables(expr.Variables)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to encode expression variables (exp_id: %s)", expr.ID),
+			zap.Error(err))
+		return err
+	}
+
 	query := `
-		INSERT INTO expressions (id, expression, status, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?);
+		INSERT INTO expressions (id, expression, syntax, normalized, variables, precision, scale, strict_order, no_cache, unit, output_format, locale, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
 	`
-	_, err := s.Db.Exec(query, expr.ID, expr.Expression, expr.Status, expr.CreatedAt, expr.UpdatedAt)
+	_, err = s.Db.Exec(query, expr.ID, expr.Expression, nullString(expr.Syntax), nullString(expr.Normalized), variables, nullString(expr.Precision), expr.Scale,
+		expr.StrictOrder, expr.NoCache, nullString(expr.Unit), nullString(expr.Format), nullString(expr.Locale), expr.Status, expr.CreatedAt, expr.UpdatedAt)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to insert expression (exp_id: %s)", expr.ID),
 			zap.Error(err))
@@ -28,21 +40,34 @@ func (s *SQLiteStorage) SaveExpression(logger *logger.Logger, expr *models.Expre
 
 func (s *SQLiteStorage) GetExpression(logger *logger.Logger, id string) (*models.Expression, error) {
 	query := `
-		SELECT id, expression, status, result, created_at, updated_at, error
+		SELECT id, expression, syntax, normalized, variables, precision, scale, strict_order, no_cache, unit, output_format, locale, status, result, exact_result, array_result, tasks_saved, created_at, updated_at, error
 		FROM expressions
 		WHERE id = ?
 	`
 
 	var expr models.Expression
 	var result sql.NullFloat64
-	var errorText sql.NullString
+	var syntax, normalized, variables, precision, unit, format, locale, exactResult, arrayResult, errorText sql.NullString
 	var createdAt, updatedAt string
 
 	err := s.Db.QueryRow(query, id).Scan(
 		&expr.ID,
 		&expr.Expression,
+		&syntax,
+		&normalized,
+		&variables,
+		&precision,
+		&expr.Scale,
+		&expr.StrictOrder,
+		&expr.NoCache,
+		&unit,
+		&format,
+		&locale,
 		&expr.Status,
 		&result,
+		&exactResult,
+		&arrayResult,
+		&expr.TasksSaved,
 		&createdAt,
 		&updatedAt,
 		&errorText,
@@ -62,6 +87,23 @@ func (s *SQLiteStorage) GetExpression(logger *logger.Logger, id string) (*models
 	if errorText.Valid {
 		expr.Error = errorText.String
 	}
+	expr.Syntax = syntax.String
+	expr.Normalized = normalized.String
+	expr.Precision = precision.String
+	expr.Unit = unit.String
+	expr.Format = format.String
+	expr.Locale = locale.String
+	expr.ExactResult = exactResult.String
+	if arrayResult.Valid {
+		expr.Array = json.RawMessage(arrayResult.String)
+	}
+	decodeComplex(&expr)
+	formatResult(&expr)
+	if expr.Variables, err = decodeVariables(variables); err != nil {
+		logger.Error(fmt.Sprintf("Failed to decode expression variables (exp_id: %s)", expr.ID),
+			zap.Error(err))
+		return nil, err
+	}
 
 	expr.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
 	expr.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
@@ -69,10 +111,12 @@ func (s *SQLiteStorage) GetExpression(logger *logger.Logger, id string) (*models
 	return &expr, nil
 }
 
-func (s *SQLiteStorage) UpdateExpressionResult(logger *logger.Logger, expressionID string, result float64) error {
+// UpdateExpressionResult сохраняет итог выражения. exact — точное значение
+// результата в режимах с произвольной точностью, в режиме float оно пустое.
+func (s *SQLiteStorage) UpdateExpressionResult(logger *logger.Logger, expressionID string, result float64, exact string) error {
 	_, err := s.Db.Exec(
-		`UPDATE expressions SET result = ?, status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
-		result, models.StatusComplete, expressionID,
+		`UPDATE expressions SET result = ?, exact_result = ?, status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		result, nullString(exact), models.StatusComplete, expressionID,
 	)
 	if err != nil {
 		logger.Error("Failed to update expression result", zap.String("expression_id", expressionID), zap.Error(err))
@@ -80,6 +124,104 @@ func (s *SQLiteStorage) UpdateExpressionResult(logger *logger.Logger, expression
 	return err
 }
 
+// SaveArrayLayout сохраняет раскладку результата-вектора или матрицы, по
+// которой GetFinalArrayResult соберёт его из результатов задач.
+func (s *SQLiteStorage) SaveArrayLayout(logger *logger.Logger, expressionID string, layout models.ArrayLayout) error {
+	data, err := json.Marshal(layout)
+	if err != nil {
+		return err
+	}
+	_, err = s.Db.Exec(`UPDATE expressions SET array_layout = ? WHERE id = ?`, string(data), expressionID)
+	if err != nil {
+		logger.Error("Failed to save array layout", zap.String("expression_id", expressionID), zap.Error(err))
+	}
+	return err
+}
+
+// GetFinalArrayResult собирает результат выражения-массива по сохранённой
+// раскладке и возвращает его в виде JSON ([1,2] или [[1,2],[3,4]]). Для
+// выражений, значение которых — число, возвращает nil.
+func (s *SQLiteStorage) GetFinalArrayResult(expressionID string) (json.RawMessage, error) {
+	var data sql.NullString
+	if err := s.Db.QueryRow(`SELECT array_layout FROM expressions WHERE id = ?`, expressionID).Scan(&data); err != nil {
+		return nil, err
+	}
+	if !data.Valid {
+		return nil, nil
+	}
+
+	var layout models.ArrayLayout
+	if err := json.Unmarshal([]byte(data.String), &layout); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.Db.Query(`SELECT id, result FROM tasks WHERE expression_id = ? AND status = ?`,
+		expressionID, models.StatusComplete)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make(map[string]float64)
+	for rows.Next() {
+		var id string
+		var result float64
+		if err := rows.Scan(&id, &result); err != nil {
+			return nil, err
+		}
+		results[id] = result
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	array := calculation.Array[float64]{Shape: layout.Shape, Elems: layout.Values}
+	for i, taskID := range layout.TaskIDs {
+		if taskID == "" {
+			continue
+		}
+		result, ok := results[taskID]
+		if !ok {
+			return nil, fmt.Errorf("task %s of expression %s has no result", taskID, expressionID)
+		}
+		array.Elems[i] = result
+	}
+	return json.Marshal(array.Nested())
+}
+
+// UpdateExpressionArrayResult сохраняет итог выражения-массива.
+func (s *SQLiteStorage) UpdateExpressionArrayResult(logger *logger.Logger, expressionID string, array json.RawMessage) error {
+	_, err := s.Db.Exec(
+		`UPDATE expressions SET array_result = ?, status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		string(array), models.StatusComplete, expressionID,
+	)
+	if err != nil {
+		logger.Error("Failed to update expression array result", zap.String("expression_id", expressionID), zap.Error(err))
+	}
+	return err
+}
+
+// UpdateExpressionNormalized сохраняет каноническую запись выражения.
+func (s *SQLiteStorage) UpdateExpressionNormalized(logger *logger.Logger, id string, normalized string) error {
+	_, err := s.Db.Exec(`UPDATE expressions SET normalized = ? WHERE id = ?`, normalized, id)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to update expression normalized form (exp_id: %s)", id),
+			zap.Error(err))
+	}
+	return err
+}
+
+// UpdateExpressionTasksSaved сохраняет, сколько задач сэкономила
+// оптимизация выражения перед отправкой агентам.
+func (s *SQLiteStorage) UpdateExpressionTasksSaved(logger *logger.Logger, id string, saved int) error {
+	_, err := s.Db.Exec(`UPDATE expressions SET tasks_saved = ? WHERE id = ?`, saved, id)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to update expression tasks saved (exp_id: %s)", id),
+			zap.Error(err))
+	}
+	return err
+}
+
 func (s *SQLiteStorage) UpdateExpressionStatus(logger *logger.Logger, id string, status string) error {
 	query := `UPDATE expressions SET status = ?, updated_at = ? WHERE id = ?`
 	_, err := s.Db.Exec(query, status, time.Now(), id)
@@ -97,7 +239,7 @@ func (s *SQLiteStorage) UpdateExpressionError(logger *logger.Logger, id string,
 		SET status = ?, error = ?, updated_at = ?
 		WHERE id = ?
 	`
-	_, err := s.Db.Exec(query, "failed", errorMsg, time.Now().UTC(), id)
+	_, err := s.Db.Exec(query, models.StatusError, errorMsg, time.Now().UTC(), id)
 	if err != nil {
 		logger.Error(fmt.Sprintf("sqlite: failed to update expression error (exp_id: %s)", id),
 			zap.Error(err))
@@ -109,7 +251,7 @@ func (s *SQLiteStorage) UpdateExpressionError(logger *logger.Logger, id string,
 
 func (s *SQLiteStorage) ListExpressions(logger *logger.Logger) ([]models.Expression, error) {
 	query := `
-		SELECT id, expression, status, result, created_at, updated_at, error
+		SELECT id, expression, syntax, normalized, variables, precision, scale, strict_order, no_cache, unit, output_format, locale, status, result, exact_result, array_result, tasks_saved, created_at, updated_at, error
 		FROM expressions
 		ORDER BY created_at DESC
 	`
@@ -126,14 +268,27 @@ func (s *SQLiteStorage) ListExpressions(logger *logger.Logger) ([]models.Express
 	for rows.Next() {
 		var expr models.Expression
 		var result sql.NullFloat64
-		var errorText sql.NullString
+		var syntax, normalized, variables, precision, unit, format, locale, exactResult, arrayResult, errorText sql.NullString
 		var createdAt, updatedAt string
 
 		if err := rows.Scan(
 			&expr.ID,
 			&expr.Expression,
+			&syntax,
+			&normalized,
+			&variables,
+			&precision,
+			&expr.Scale,
+			&expr.StrictOrder,
+			&expr.NoCache,
+			&unit,
+			&format,
+			&locale,
 			&expr.Status,
 			&result,
+			&exactResult,
+			&arrayResult,
+			&expr.TasksSaved,
 			&createdAt,
 			&updatedAt,
 			&errorText,
@@ -148,6 +303,22 @@ func (s *SQLiteStorage) ListExpressions(logger *logger.Logger) ([]models.Express
 		if errorText.Valid {
 			expr.Error = errorText.String
 		}
+		expr.Syntax = syntax.String
+		expr.Normalized = normalized.String
+		expr.Precision = precision.String
+		expr.Unit = unit.String
+		expr.Format = format.String
+		expr.Locale = locale.String
+		expr.ExactResult = exactResult.String
+		if arrayResult.Valid {
+			expr.Array = json.RawMessage(arrayResult.String)
+		}
+		decodeComplex(&expr)
+		formatResult(&expr)
+		if expr.Variables, err = decodeVariables(variables); err != nil {
+			logger.Error(fmt.Sprintf("failed to decode expression variables: %v", err), zap.Error(err))
+			continue
+		}
 		expr.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
 		expr.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
 
@@ -170,3 +341,88 @@ func (s *SQLiteStorage) GetExpressionIDByTaskID(taskID string) (string, error) {
 	}
 	return exprID, nil
 }
+
+// encodeVariables сериализует привязки переменных выражения в JSON для хранения.
+func encodeVariables(vars map[string]float64) (sql.NullString, error) {
+	if len(vars) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(vars)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// decodeVariables восстанавливает привязки переменных, сохранённые encodeVariables.
+func decodeVariables(data sql.NullString) (map[string]float64, error) {
+	if !data.Valid || data.String == "" {
+		return nil, nil
+	}
+	var vars map[string]float64
+	if err := json.Unmarshal([]byte(data.String), &vars); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// decodeComplex раскладывает точный результат выражения в режиме complex
+// на действительную и мнимую части. Если мнимая часть не нулевая, у
+// результата нет представления float, и поле result не заполняется.
+func decodeComplex(expr *models.Expression) {
+	if expr.Precision != string(calculation.ModeComplex) || expr.ExactResult == "" {
+		return
+	}
+	c, err := strconv.ParseComplex(expr.ExactResult, 128)
+	if err != nil {
+		return
+	}
+	expr.Complex = &models.Complex{Re: real(c), Im: imag(c)}
+	if imag(c) != 0 {
+		expr.Result = nil
+	}
+}
+
+// formatResult записывает результат выражения в нотации output_format
+// и с разделителями локали, если они указаны. У векторов и комплексных
+// результатов их нет.
+func formatResult(expr *models.Expression) {
+	if expr.Result == nil {
+		return
+	}
+
+	// В режимах integer и decimal результат может не поместиться в float64
+	// без потерь (2^63-1 превращается в 2^63, у десятичной дроби пропадают
+	// последние цифры), поэтому записываем точный: его запись десятичная.
+	var exact *big.Int
+	var decimal string
+	switch calculation.Mode(expr.Precision) {
+	case calculation.ModeInteger:
+		exact, _ = new(big.Int).SetString(expr.ExactResult, 10)
+		decimal = expr.ExactResult
+	case calculation.ModeDecimal:
+		decimal = expr.ExactResult
+	}
+
+	if expr.Format != "" {
+		var text string
+		var err error
+		if exact != nil {
+			text, err = calculation.FormatInteger(exact, calculation.Notation(expr.Format))
+		} else {
+			text, err = calculation.FormatNumber(*expr.Result, calculation.Notation(expr.Format))
+		}
+		if err == nil {
+			expr.Formatted = text
+		}
+	}
+	if expr.Locale != "" {
+		if loc, err := calculation.ParseLocale(expr.Locale); err == nil {
+			if decimal != "" {
+				expr.Localized = loc.FormatDecimal(decimal)
+			} else {
+				expr.Localized = loc.FormatNumber(*expr.Result)
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/structxz/calc_v3/internal/app/models"
+	"github.com/structxz/calc_v3/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// SaveFormula сохраняет новую формулу пользователя вместе с её первой версией.
+func (s *SQLiteStorage) SaveFormula(logger *logger.Logger, formula *models.Formula) error {
+	params, err := json.Marshal(formula.Parameters)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.Db.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO formulas (id, owner, name, body, parameters, version, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		formula.ID, formula.Owner, formula.Name, formula.Body, string(params), formula.Version, formula.CreatedAt, formula.UpdatedAt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to insert formula (name: %s)", formula.Name), zap.Error(err))
+		return err
+	}
+
+	if err := insertFormulaVersion(tx, formula, string(params)); err != nil {
+		logger.Error(fmt.Sprintf("Failed to insert formula version (name: %s)", formula.Name), zap.Error(err))
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateFormula заменяет тело и параметры формулы, увеличивая номер версии.
+// Предыдущие версии остаются в formula_versions.
+func (s *SQLiteStorage) UpdateFormula(logger *logger.Logger, formula *models.Formula) error {
+	params, err := json.Marshal(formula.Parameters)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.Db.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE formulas SET body = ?, parameters = ?, version = ?, updated_at = ?
+		WHERE id = ?`,
+		formula.Body, string(params), formula.Version, formula.UpdatedAt, formula.ID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to update formula (name: %s)", formula.Name), zap.Error(err))
+		return err
+	}
+
+	if err := insertFormulaVersion(tx, formula, string(params)); err != nil {
+		logger.Error(fmt.Sprintf("Failed to insert formula version (name: %s)", formula.Name), zap.Error(err))
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertFormulaVersion(tx *sql.Tx, formula *models.Formula, params string) error {
+	_, err := tx.Exec(`
+		INSERT INTO formula_versions (formula_id, version, body, parameters, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		formula.ID, formula.Version, formula.Body, params, formula.UpdatedAt)
+	return err
+}
+
+// GetFormula возвращает формулу пользователя по имени или nil, если её нет.
+func (s *SQLiteStorage) GetFormula(logger *logger.Logger, owner, name string) (*models.Formula, error) {
+	row := s.Db.QueryRow(`
+		SELECT id, owner, name, body, parameters, version, created_at, updated_at
+		FROM formulas
+		WHERE owner = ? AND name = ?`, owner, name)
+
+	formula, err := scanFormula(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Error(fmt.Sprintf("Failed to get formula (name: %s)", name), zap.Error(err))
+		return nil, err
+	}
+	return formula, nil
+}
+
+// ListFormulas возвращает все формулы пользователя, отсортированные по имени.
+func (s *SQLiteStorage) ListFormulas(logger *logger.Logger, owner string) ([]models.Formula, error) {
+	rows, err := s.Db.Query(`
+		SELECT id, owner, name, body, parameters, version, created_at, updated_at
+		FROM formulas
+		WHERE owner = ?
+		ORDER BY name`, owner)
+	if err != nil {
+		logger.Error("Failed to list formulas", zap.Error(err))
+		return nil, fmt.Errorf("query formulas: %w", err)
+	}
+	defer rows.Close()
+
+	formulas := []models.Formula{}
+	for rows.Next() {
+		formula, err := scanFormula(rows)
+		if err != nil {
+			logger.Error("Failed to scan formula row", zap.Error(err))
+			return nil, err
+		}
+		formulas = append(formulas, *formula)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return formulas, nil
+}
+
+// ListFormulaVersions возвращает историю версий формулы, начиная с первой.
+func (s *SQLiteStorage) ListFormulaVersions(logger *logger.Logger, formulaID string) ([]models.FormulaVersion, error) {
+	rows, err := s.Db.Query(`
+		SELECT version, body, parameters, created_at
+		FROM formula_versions
+		WHERE formula_id = ?
+		ORDER BY version`, formulaID)
+	if err != nil {
+		logger.Error("Failed to list formula versions", zap.Error(err))
+		return nil, fmt.Errorf("query formula versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []models.FormulaVersion{}
+	for rows.Next() {
+		var (
+			version models.FormulaVersion
+			params  string
+		)
+		if err := rows.Scan(&version.Version, &version.Body, &params, &version.CreatedAt); err != nil {
+			logger.Error("Failed to scan formula version row", zap.Error(err))
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(params), &version.Parameters); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return versions, nil
+}
+
+// DeleteFormula удаляет формулу вместе с историей версий.
+// Возвращает false, если формулы не было.
+func (s *SQLiteStorage) DeleteFormula(logger *logger.Logger, owner, name string) (bool, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM formula_versions
+		WHERE formula_id IN (SELECT id FROM formulas WHERE owner = ? AND name = ?)`, owner, name)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete formula versions (name: %s)", name), zap.Error(err))
+		return false, err
+	}
+
+	res, err := tx.Exec(`DELETE FROM formulas WHERE owner = ? AND name = ?`, owner, name)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete formula (name: %s)", name), zap.Error(err))
+		return false, err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return deleted > 0, tx.Commit()
+}
+
+// rowScanner — общий интерфейс *sql.Row и *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFormula(row rowScanner) (*models.Formula, error) {
+	var (
+		formula models.Formula
+		params  string
+	)
+	err := row.Scan(
+		&formula.ID,
+		&formula.Owner,
+		&formula.Name,
+		&formula.Body,
+		&params,
+		&formula.Version,
+		&formula.CreatedAt,
+		&formula.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(params), &formula.Parameters); err != nil {
+		return nil, err
+	}
+	return &formula, nil
+}
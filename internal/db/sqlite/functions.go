@@ -0,0 +1,116 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/structxz/calc_v3/internal/app/models"
+	"github.com/structxz/calc_v3/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// SaveFunction сохраняет новую функцию пользователя.
+func (s *SQLiteStorage) SaveFunction(logger *logger.Logger, function *models.Function) error {
+	params, err := json.Marshal(function.Parameters)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Db.Exec(`
+		INSERT INTO functions (id, owner, name, parameters, body, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		function.ID, function.Owner, function.Name, string(params), function.Body, function.CreatedAt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to insert function (name: %s)", function.Name), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetFunction возвращает функцию пользователя по имени или nil, если её нет.
+func (s *SQLiteStorage) GetFunction(logger *logger.Logger, owner, name string) (*models.Function, error) {
+	row := s.Db.QueryRow(`
+		SELECT id, owner, name, parameters, body, created_at
+		FROM functions
+		WHERE owner = ? AND name = ?`, owner, name)
+
+	function, err := scanFunction(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Error(fmt.Sprintf("Failed to get function (name: %s)", name), zap.Error(err))
+		return nil, err
+	}
+	return function, nil
+}
+
+// ListFunctions возвращает все функции пользователя, отсортированные по имени.
+func (s *SQLiteStorage) ListFunctions(logger *logger.Logger, owner string) ([]models.Function, error) {
+	rows, err := s.Db.Query(`
+		SELECT id, owner, name, parameters, body, created_at
+		FROM functions
+		WHERE owner = ?
+		ORDER BY name`, owner)
+	if err != nil {
+		logger.Error("Failed to list functions", zap.Error(err))
+		return nil, fmt.Errorf("query functions: %w", err)
+	}
+	defer rows.Close()
+
+	functions := []models.Function{}
+	for rows.Next() {
+		function, err := scanFunction(rows)
+		if err != nil {
+			logger.Error("Failed to scan function row", zap.Error(err))
+			return nil, err
+		}
+		functions = append(functions, *function)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return functions, nil
+}
+
+// DeleteFunction удаляет функцию пользователя.
+// Возвращает false, если функции не было.
+func (s *SQLiteStorage) DeleteFunction(logger *logger.Logger, owner, name string) (bool, error) {
+	res, err := s.Db.Exec(`DELETE FROM functions WHERE owner = ? AND name = ?`, owner, name)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete function (name: %s)", name), zap.Error(err))
+		return false, err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+func scanFunction(row rowScanner) (*models.Function, error) {
+	var (
+		function models.Function
+		params   string
+	)
+	err := row.Scan(
+		&function.ID,
+		&function.Owner,
+		&function.Name,
+		&params,
+		&function.Body,
+		&function.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(params), &function.Parameters); err != nil {
+		return nil, err
+	}
+	return &function, nil
+}
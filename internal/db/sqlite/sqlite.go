@@ -2,6 +2,7 @@ package sqlite
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/structxz/calc_v3/internal/constants"
 	"github.com/structxz/calc_v3/internal/logger"
 	"go.uber.org/zap"
@@ -35,8 +36,22 @@ func RunMigrations(logger *logger.Logger, db *sql.DB) error {
 	CREATE TABLE IF NOT EXISTS expressions (
 		id TEXT PRIMARY KEY,
 		expression TEXT NOT NULL,
+		syntax TEXT,
+		normalized TEXT,
+		variables TEXT,
+		precision TEXT,
+		scale INTEGER NOT NULL DEFAULT 0,
+		strict_order INTEGER NOT NULL DEFAULT 0,
+		no_cache INTEGER NOT NULL DEFAULT 0,
+		unit TEXT,
+		output_format TEXT,
+		locale TEXT,
 		status TEXT NOT NULL,
 		result REAL,
+		exact_result TEXT,
+		array_layout TEXT,
+		array_result TEXT,
+		tasks_saved INTEGER NOT NULL DEFAULT 0,
 		error TEXT,
 		created_at DATETIME NOT NULL,
 		updated_at DATETIME NOT NULL
@@ -48,7 +63,15 @@ func RunMigrations(logger *logger.Logger, db *sql.DB) error {
 		operation TEXT NOT NULL,
 		arg1 REAL NOT NULL,
 		arg2 REAL,
+		arg3 REAL,
+		arg1_exact TEXT,
+		arg2_exact TEXT,
+		arg3_exact TEXT,
+		guard_task_id TEXT,
+		guard_value INTEGER NOT NULL DEFAULT 0,
+		cache_key TEXT,
 		result REAL,
+		result_exact TEXT,
 		status TEXT NOT NULL,
 		error TEXT,
 		created_at DATETIME NOT NULL,
@@ -59,7 +82,8 @@ func RunMigrations(logger *logger.Logger, db *sql.DB) error {
 	CREATE TABLE IF NOT EXISTS task_dependencies (
 		task_id TEXT NOT NULL,
     	depends_on_task_id TEXT NOT NULL,
-    	PRIMARY KEY (task_id, depends_on_task_id),
+    	arg_index INTEGER NOT NULL DEFAULT 0,
+    	PRIMARY KEY (task_id, depends_on_task_id, arg_index),
     	FOREIGN KEY (task_id) REFERENCES tasks(id),
     	FOREIGN KEY (depends_on_task_id) REFERENCES tasks(id)
 	);	
@@ -68,6 +92,38 @@ func RunMigrations(logger *logger.Logger, db *sql.DB) error {
 		login TEXT NOT NULL COLLATE NOCASE UNIQUE,
 		password TEXT NOT NULL
 	);
+
+	CREATE TABLE IF NOT EXISTS formulas (
+		id TEXT PRIMARY KEY,
+		owner TEXT NOT NULL COLLATE NOCASE,
+		name TEXT NOT NULL,
+		body TEXT NOT NULL,
+		parameters TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		UNIQUE (owner, name)
+	);
+
+	CREATE TABLE IF NOT EXISTS functions (
+		id TEXT PRIMARY KEY,
+		owner TEXT NOT NULL COLLATE NOCASE,
+		name TEXT NOT NULL,
+		parameters TEXT NOT NULL,
+		body TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		UNIQUE (owner, name)
+	);
+
+	CREATE TABLE IF NOT EXISTS formula_versions (
+		formula_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		body TEXT NOT NULL,
+		parameters TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (formula_id, version),
+		FOREIGN KEY (formula_id) REFERENCES formulas(id)
+	);
 	`
 
 	_, err := db.Exec(schema)
@@ -77,10 +133,145 @@ func RunMigrations(logger *logger.Logger, db *sql.DB) error {
 		return err
 	}
 
+	// CREATE TABLE IF NOT EXISTS не меняет уже существующие таблицы,
+	// поэтому колонки, добавленные позже, докатываем отдельно.
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"task_dependencies", "arg_index", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "variables", "TEXT"},
+		{"expressions", "precision", "TEXT"},
+		{"expressions", "scale", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "exact_result", "TEXT"},
+		{"expressions", "unit", "TEXT"},
+		{"expressions", "strict_order", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "array_layout", "TEXT"},
+		{"expressions", "array_result", "TEXT"},
+		{"expressions", "tasks_saved", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "no_cache", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "normalized", "TEXT"},
+		{"expressions", "output_format", "TEXT"},
+		{"expressions", "locale", "TEXT"},
+		{"expressions", "syntax", "TEXT"},
+		{"tasks", "arg1_exact", "TEXT"},
+		{"tasks", "arg2_exact", "TEXT"},
+		{"tasks", "result_exact", "TEXT"},
+		{"tasks", "arg3", "REAL"},
+		{"tasks", "arg3_exact", "TEXT"},
+		{"tasks", "guard_task_id", "TEXT"},
+		{"tasks", "guard_value", "INTEGER NOT NULL DEFAULT 0"},
+		{"tasks", "cache_key", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := ensureColumn(db, c.table, c.column, c.definition); err != nil {
+			logger.Error("failed to run migrations",
+				zap.String("table", c.table),
+				zap.String("column", c.column),
+				zap.Error(err))
+			return err
+		}
+	}
+
+	if err := rebuildTaskDependencies(db); err != nil {
+		logger.Error("failed to run migrations",
+			zap.String("table", "task_dependencies"),
+			zap.Error(err))
+		return err
+	}
+
 	logger.Info("Database migration completed successfully")
 	return nil
 }
 
+// ensureColumn добавляет колонку в таблицу, если её там ещё нет.
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, colType    string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// rebuildTaskDependencies пересоздаёт task_dependencies, если arg_index ещё
+// не входит в первичный ключ: задача может зависеть от одной и той же
+// задачи в нескольких аргументах, как x * x в сценарии с x = ...
+func rebuildTaskDependencies(db *sql.DB) error {
+	rows, err := db.Query("PRAGMA table_info(task_dependencies)")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	inKey := false
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, colType    string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == "arg_index" && pk > 0 {
+			inKey = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if inKey {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE TABLE task_dependencies_new (
+			task_id TEXT NOT NULL,
+			depends_on_task_id TEXT NOT NULL,
+			arg_index INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (task_id, depends_on_task_id, arg_index),
+			FOREIGN KEY (task_id) REFERENCES tasks(id),
+			FOREIGN KEY (depends_on_task_id) REFERENCES tasks(id)
+		)`,
+		`INSERT INTO task_dependencies_new (task_id, depends_on_task_id, arg_index)
+			SELECT task_id, depends_on_task_id, arg_index FROM task_dependencies`,
+		`DROP TABLE task_dependencies`,
+		`ALTER TABLE task_dependencies_new RENAME TO task_dependencies`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (db *SQLiteStorage) Close() error {
 	return db.Db.Close()
 }
@@ -90,4 +281,12 @@ func nullFloat(f float64) sql.NullFloat64 {
 		Float64: f,
 		Valid:   true,
 	}
+}
+
+// nullString сохраняет пустую строку как NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{
+		String: s,
+		Valid:  s != "",
+	}
 }
\ No newline at end of file
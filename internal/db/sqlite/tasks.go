@@ -11,59 +11,126 @@ import (
 	"go.uber.org/zap"
 )
 
-func (s *SQLiteStorage) SaveTask(logger *logger.Logger, task *models.Task) error {
-	query := `INSERT INTO tasks (id, expression_id, operation, arg1, arg2, result, status, created_at, updated_at)
-	          VALUES (?, ?, ?, ?, ?, NULL, ?, ?, ?)`
-	_, err := s.Db.Exec(query,
-		task.ID,
-		task.ExpressionID,
-		task.Operation,
-		nullFloat(task.Arg1),
-		nullFloat(task.Arg2),
-		task.Status,
-		task.CreatedAt,
-		time.Now(),
-	)
+// SaveTasks сохраняет задачи выражения вместе с их зависимостями в одной
+// транзакции: иначе агент может получить задачу раньше, чем сохранены
+// зависимости её операндов, а выражение — завершиться по первым
+// выполненным задачам, пока остальные ещё не сохранены.
+func (s *SQLiteStorage) SaveTasks(logger *logger.Logger, tasks []*models.Task) error {
+	tx, err := s.Db.Begin()
 	if err != nil {
-		logger.Error("Failed to save task", zap.Error(err))
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return err
 	}
-	return err
+	defer tx.Rollback()
+
+	for _, task := range tasks {
+		query := `INSERT INTO tasks (id, expression_id, operation, arg1, arg2, arg3, arg1_exact, arg2_exact, arg3_exact,
+		                             guard_task_id, guard_value, result, status, created_at, updated_at)
+		          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULL, ?, ?, ?)`
+		_, err := tx.Exec(query,
+			task.ID,
+			task.ExpressionID,
+			task.Operation,
+			nullFloat(task.Arg1),
+			nullFloat(task.Arg2),
+			nullFloat(task.Arg3),
+			nullString(task.Arg1Exact),
+			nullString(task.Arg2Exact),
+			nullString(task.Arg3Exact),
+			nullString(task.GuardTaskID),
+			task.GuardValue,
+			task.Status,
+			task.CreatedAt,
+			time.Now(),
+		)
+		if err != nil {
+			logger.Error("Failed to save task", zap.Error(err))
+			return err
+		}
+
+		// argIndex — позиция операнда (0 — arg1, 1 — arg2, 2 — arg3), в
+		// которую будет подставлен результат зависимости.
+		for argIndex, depID := range []string{task.Arg1TaskID, task.Arg2TaskID, task.Arg3TaskID} {
+			if depID == "" {
+				continue
+			}
+			query := `INSERT OR IGNORE INTO task_dependencies (task_id, depends_on_task_id, arg_index) VALUES (?, ?, ?)`
+			if _, err := tx.Exec(query, task.ID, depID, argIndex); err != nil {
+				logger.Error("Failed to save task dependency", zap.String("taskID", task.ID), zap.String("dependencyID", depID), zap.Error(err))
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
 }
 
 
-func (s *SQLiteStorage) UpdateTaskStatus(logger *logger.Logger, id, status string) error {
-	query := `UPDATE tasks SET status = ?, updated_at = ? WHERE id = ?`
-	_, err := s.Db.Exec(query, status, time.Now(), id)
+// StartTask помечает задачу выполняющейся и запоминает ключ кеша, под
+// которым надо сохранить её результат: ключ хранится вместе с задачей,
+// поэтому ничего не остаётся в памяти, если агент так и не ответит.
+func (s *SQLiteStorage) StartTask(logger *logger.Logger, id, cacheKey string) error {
+	query := `UPDATE tasks SET status = 'RUNNING', cache_key = ?, updated_at = ? WHERE id = ?`
+	_, err := s.Db.Exec(query, sql.NullString{String: cacheKey, Valid: cacheKey != ""}, time.Now(), id)
 	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to update task status (task_id: %s)", id),
+		logger.Error(fmt.Sprintf("Failed to start task (task_id: %s)", id),
 			zap.Error(err))
 		return err
 	}
-	return nil 
+	return nil
+}
+
+// GetTaskCacheKey возвращает ключ кеша, запомненный StartTask; пустой ключ
+// означает, что результат задачи в кеш не попадает.
+func (s *SQLiteStorage) GetTaskCacheKey(logger *logger.Logger, id string) (string, error) {
+	var key sql.NullString
+	err := s.Db.QueryRow(`SELECT cache_key FROM tasks WHERE id = ?`, id).Scan(&key)
+	if err != nil && err != sql.ErrNoRows {
+		logger.Error(fmt.Sprintf("Failed to get task cache key (task_id: %s)", id),
+			zap.Error(err))
+		return "", err
+	}
+	return key.String, nil
 }
 
 
 func (s *SQLiteStorage) GetNextTask(logger *logger.Logger) (*models.Task, error) {
+	// Режим точности хранится у выражения, агенту он нужен вместе с задачей.
+	// Пропущенная задача невыбранной ветки не задерживает зависящие от неё
+	// задачи, а задача ветки ждёт, пока не будет вычислено её условие.
 	query := `
-		SELECT id, expression_id, operation, arg1, arg2, status, created_at, updated_at
-		FROM tasks
-		WHERE status = 'PENDING'
-		AND id NOT IN (
+		SELECT t.id, t.expression_id, t.operation, t.arg1, t.arg2, t.arg3, t.arg1_exact, t.arg2_exact, t.arg3_exact,
+		       e.precision, e.scale, e.no_cache, t.status, t.created_at, t.updated_at
+		FROM tasks t
+		JOIN expressions e ON e.id = t.expression_id
+		WHERE t.status = 'PENDING'
+		AND t.id NOT IN (
 			SELECT td.task_id
 			FROM task_dependencies td
 			JOIN tasks dep ON td.depends_on_task_id = dep.id
-			WHERE dep.status != 'COMPLETED'
+			WHERE dep.status NOT IN (?, ?)
 		)
+		AND (t.guard_task_id IS NULL OR t.guard_task_id IN (SELECT id FROM tasks WHERE status = ?))
+		AND e.status != ?
 		LIMIT 1;
 	`
 
 	var task models.Task
-	err := s.Db.QueryRow(query).Scan(
+	var arg3 sql.NullFloat64
+	var arg1Exact, arg2Exact, arg3Exact, precision sql.NullString
+	err := s.Db.QueryRow(query, models.StatusComplete, models.StatusSkipped, models.StatusComplete, models.StatusError).Scan(
 		&task.ID,
 		&task.ExpressionID,
 		&task.Operation,
 		&task.Arg1,
 		&task.Arg2,
+		&arg3,
+		&arg1Exact,
+		&arg2Exact,
+		&arg3Exact,
+		&precision,
+		&task.Scale,
+		&task.NoCache,
 		&task.Status,
 		&task.CreatedAt,
 		&task.UpdatedAt,
@@ -75,6 +142,11 @@ func (s *SQLiteStorage) GetNextTask(logger *logger.Logger) (*models.Task, error)
 		logger.Error(fmt.Sprintf("failed to get next task: %v", err))
 		return nil, fmt.Errorf("failed to get next task: %w", err)
 	}
+	task.Arg3 = arg3.Float64
+	task.Arg1Exact = arg1Exact.String
+	task.Arg2Exact = arg2Exact.String
+	task.Arg3Exact = arg3Exact.String
+	task.Precision = precision.String
 
 	logger.Info(constants.LogTaskRetrieved,
 		zap.String(constants.FieldTaskID, task.ID),
@@ -83,19 +155,88 @@ func (s *SQLiteStorage) GetNextTask(logger *logger.Logger) (*models.Task, error)
 	return &task, nil
 }
 
-func (s *SQLiteStorage) UpdateTaskResult(logger *logger.Logger, taskID string, result float64) error {
-	query := `UPDATE tasks SET result = ?, status = 'done', updated_at = CURRENT_TIMESTAMP WHERE id = ?`
-	_, err := s.Db.Exec(query, result, taskID)
+// UpdateTaskResult сохраняет результат задачи и подставляет его в операнды
+// зависящих от неё задач согласно arg_index из task_dependencies. Если
+// задача — условие условного выражения, задачи невыбранной ветки
+// пропускаются. exact — точное значение результата; в режиме float оно пустое.
+func (s *SQLiteStorage) UpdateTaskResult(logger *logger.Logger, taskID string, result float64, exact string) error {
+	tx, err := s.Db.Begin()
 	if err != nil {
+		logger.Error("Failed to begin transaction", zap.String("task_id", taskID), zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE tasks SET result = ?, result_exact = ?, status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := tx.Exec(query, result, nullString(exact), models.StatusComplete, taskID); err != nil {
 		logger.Error("Failed to update task result", zap.String("task_id", taskID), zap.Error(err))
+		return err
+	}
+
+	for argIndex, column := range []string{"arg1", "arg2", "arg3"} {
+		query := fmt.Sprintf(`
+			UPDATE tasks SET %[1]s = ?, %[1]s_exact = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id IN (
+				SELECT task_id FROM task_dependencies
+				WHERE depends_on_task_id = ? AND arg_index = ?
+			)`, column)
+		if _, err := tx.Exec(query, result, nullString(exact), taskID, argIndex); err != nil {
+			logger.Error("Failed to propagate task result", zap.String("task_id", taskID), zap.Error(err))
+			return err
+		}
+	}
+
+	if err := skipBranch(tx, taskID, result != 0); err != nil {
+		logger.Error("Failed to skip untaken branch", zap.String("task_id", taskID), zap.Error(err))
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("Failed to commit task result", zap.String("task_id", taskID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// skipBranch помечает пропущенными задачи ветки, которую не выбрало
+// условие guardTaskID со значением value, а затем и задачи вложенных в неё
+// условных выражений, условия которых оказались пропущены.
+func skipBranch(tx *sql.Tx, guardTaskID string, value bool) error {
+	res, err := tx.Exec(`UPDATE tasks SET status = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE guard_task_id = ? AND guard_value != ? AND status = ?`,
+		models.StatusSkipped, guardTaskID, value, models.StatusPending)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		res, err = tx.Exec(`UPDATE tasks SET status = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE status = ? AND expression_id = (SELECT expression_id FROM tasks WHERE id = ?)
+			AND guard_task_id IN (SELECT id FROM tasks WHERE status = ?)`,
+			models.StatusSkipped, models.StatusPending, guardTaskID, models.StatusSkipped)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// UpdateTaskError помечает задачу как завершившуюся ошибкой.
+func (s *SQLiteStorage) UpdateTaskError(logger *logger.Logger, taskID string, errorMsg string) error {
+	query := `UPDATE tasks SET status = ?, error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err := s.Db.Exec(query, models.StatusError, errorMsg, taskID)
+	if err != nil {
+		logger.Error("Failed to update task error", zap.String("task_id", taskID), zap.Error(err))
 	}
 	return err
 }
 
 func (s *SQLiteStorage) AreAllTasksCompleted(logger *logger.Logger, exprID string) (bool, error) {
-	query := `SELECT COUNT(*) FROM tasks WHERE expression_id = ? AND status != 'done'`
+	query := `SELECT COUNT(*) FROM tasks WHERE expression_id = ? AND status NOT IN (?, ?)`
 	var count int
-	err := s.Db.QueryRow(query, exprID).Scan(&count)
+	err := s.Db.QueryRow(query, exprID, models.StatusComplete, models.StatusSkipped).Scan(&count)
 	if err != nil {
 		logger.Error("Failed to check task completion", zap.Error(err))
 		return false, err
@@ -103,17 +244,21 @@ func (s *SQLiteStorage) AreAllTasksCompleted(logger *logger.Logger, exprID strin
 	return count == 0, nil
 }
 
-func (s *SQLiteStorage) GetFinalTaskResult(expressionID string) (float64, error) {
+// GetFinalTaskResult возвращает результат корневой задачи выражения —
+// той, от которой не зависит ни одна другая задача, — и его точное значение.
+func (s *SQLiteStorage) GetFinalTaskResult(expressionID string) (float64, string, error) {
 	row := s.Db.QueryRow(`
-		SELECT result FROM tasks 
-		WHERE expression_id = ? AND status = 'done' 
-		ORDER BY created_at DESC LIMIT 1
-	`, expressionID)
+		SELECT result, result_exact FROM tasks
+		WHERE expression_id = ? AND status = ?
+		AND id NOT IN (SELECT depends_on_task_id FROM task_dependencies)
+		LIMIT 1
+	`, expressionID, models.StatusComplete)
 
 	var result float64
-	err := row.Scan(&result)
+	var exact sql.NullString
+	err := row.Scan(&result, &exact)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
-	return result, nil
+	return result, exact.String, nil
 }
\ No newline at end of file
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"github.com/structxz/calc_v3/internal/app/models"
 	"github.com/structxz/calc_v3/internal/jwtutil"
 	"github.com/structxz/calc_v3/internal/logger"
 	"net/http"
@@ -10,6 +11,8 @@ import (
 	"go.uber.org/zap"
 )
 
+// userKey — ключ контекста запроса, под которым лежит логин пользователя из JWT.
+const userKey models.FieldUser = "user"
 
 func AuthMiddleware(logger *logger.Logger) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
@@ -20,8 +23,14 @@ func AuthMiddleware(logger *logger.Logger) mux.MiddlewareFunc {
 				http.Error(w, "You are unauthorized, first go through authentication", http.StatusUnauthorized)
 				return
 			}
-			ctx := context.WithValue(r.Context(), "user", login)
+			ctx := context.WithValue(r.Context(), userKey, login)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// UserFromContext возвращает логин пользователя, положенный в контекст AuthMiddleware.
+func UserFromContext(ctx context.Context) (string, bool) {
+	login, ok := ctx.Value(userKey).(string)
+	return login, ok && login != ""
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 
+	"github.com/structxz/calc_v3/internal/app/models"
+	"github.com/structxz/calc_v3/internal/cache"
 	"github.com/structxz/calc_v3/internal/constants"
 	"github.com/structxz/calc_v3/internal/db/sqlite"
 	"github.com/structxz/calc_v3/internal/logger"
@@ -16,18 +18,22 @@ type OrchestratorServer struct {
 	api.UnimplementedOrchestratorServer
 	log     *logger.Logger
 	storage *sqlite.SQLiteStorage
+	results *cache.Cache
 }
 
-func New(log *logger.Logger, storage *sqlite.SQLiteStorage) *OrchestratorServer {
+// New создаёт оркестратор. Результаты задач, вычисленных агентами, он
+// складывает в results, а задачи с уже известным результатом выполняет сам.
+func New(log *logger.Logger, storage *sqlite.SQLiteStorage, results *cache.Cache) *OrchestratorServer {
 	return &OrchestratorServer{
 		log:     log,
 		storage: storage,
+		results: results,
 	}
 }
 
 // GetTask выдает следующую доступную задачу агенту
 func (s *OrchestratorServer) GetTask(ctx context.Context, info *api.AgentInfo) (*api.TaskResponse, error) {
-	task, err := s.storage.GetNextTask(s.log)
+	task, err := s.nextTask()
 	if err != nil {
 		return nil, err
 	}
@@ -43,12 +49,17 @@ func (s *OrchestratorServer) GetTask(ctx context.Context, info *api.AgentInfo) (
 		Id:           task.ID,
 		ExpressionId: task.ExpressionID,
 		Operation:    task.Operation,
-		Operands:     []float64{task.Arg1, task.Arg2},
+		Operands:     []float64{task.Arg1, task.Arg2, task.Arg3},
 		DependsOn:    task.DependsOnTaskIDs,
+		Precision:    task.Precision,
+		Scale:        int32(task.Scale),
+	}
+	if task.Precision != "" {
+		respTask.ExactOperands = []string{task.Arg1Exact, task.Arg2Exact, task.Arg3Exact}
 	}
 
-	// Обновляем статус задачи (например, RUNNING)
-	err = s.storage.UpdateTaskStatus(s.log, task.ID, "RUNNING")
+	// Обновляем статус задачи (RUNNING) и запоминаем ключ кеша для её результата
+	err = s.storage.StartTask(s.log, task.ID, task.CacheKey)
 	if err != nil {
 		return nil, err
 	}
@@ -65,28 +76,101 @@ func (s *OrchestratorServer) SubmitTaskResult(ctx context.Context, res *api.Task
 		return nil, errors.New("empty result")
 	}
 
-	err := s.storage.UpdateTaskResult(s.log, res.TaskId, res.Result)
-	if err != nil {
-		return nil, err
+	// Агент не смог вычислить задачу — помечаем ошибкой и задачу, и всё выражение.
+	if res.Error != "" {
+		s.log.Warn(constants.LogTaskFailed,
+			zap.String(constants.FieldTaskID, res.TaskId),
+			zap.String(constants.FieldExpressionID, res.ExpressionId),
+			zap.String("error", res.Error))
+
+		if err := s.storage.UpdateTaskError(s.log, res.TaskId, res.Error); err != nil {
+			return nil, err
+		}
+		if err := s.storage.UpdateExpressionError(s.log, res.ExpressionId, res.Error); err != nil {
+			return nil, err
+		}
+		return &api.SubmitResponse{Success: true}, nil
 	}
 
-	allDone, err := s.storage.AreAllTasksCompleted(s.log, res.ExpressionId)
+	key, err := s.storage.GetTaskCacheKey(s.log, res.TaskId)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.completeTask(res.ExpressionId, res.TaskId, res.Result, res.ExactResult); err != nil {
+		return nil, err
+	}
+	if key != "" {
+		s.results.Put(key, cache.Result{Value: res.Result, Exact: res.ExactResult})
+	}
+
+	return &api.SubmitResponse{Success: true}, nil
+}
+
+// nextTask возвращает следующую задачу для агента. Задачу, результат
+// которой уже есть в кеше, оркестратор выполняет сам и берёт следующую:
+// её выполнение могло сделать готовыми зависящие от неё задачи.
+func (s *OrchestratorServer) nextTask() (*models.Task, error) {
+	for {
+		task, err := s.storage.GetNextTask(s.log)
+		if err != nil || task == nil || task.NoCache {
+			return task, err
+		}
+
+		key := cache.Key(task)
+		result, ok := s.results.Get(key)
+		if !ok {
+			task.CacheKey = key
+			return task, nil
+		}
 
-	if allDone {
-		finalResult, err := s.storage.GetFinalTaskResult(res.ExpressionId)
-		if err != nil {
-			s.log.Error("Failed to get final task result", zap.Error(err))
-		} else {
-			s.storage.UpdateExpressionResult(s.log, res.ExpressionId, finalResult)
-			s.log.Info(constants.LogFinalResultReady,
-				zap.String(constants.FieldExpressionID, res.ExpressionId),
-				zap.Float64("result", finalResult),
-			)
+		s.log.Info(constants.LogTaskFromCache,
+			zap.String(constants.FieldTaskID, task.ID),
+			zap.String(constants.FieldOperation, task.Operation))
+		if err := s.completeTask(task.ExpressionID, task.ID, result.Value, result.Exact); err != nil {
+			return nil, err
 		}
 	}
+}
 
-	return &api.SubmitResponse{Success: true}, nil
+// completeTask сохраняет результат задачи, а если это была последняя
+// задача выражения, — и итог выражения.
+func (s *OrchestratorServer) completeTask(exprID, taskID string, result float64, exact string) error {
+	if err := s.storage.UpdateTaskResult(s.log, taskID, result, exact); err != nil {
+		return err
+	}
+
+	allDone, err := s.storage.AreAllTasksCompleted(s.log, exprID)
+	if err != nil {
+		return err
+	}
+	if !allDone {
+		return nil
+	}
+
+	array, err := s.storage.GetFinalArrayResult(exprID)
+	if err != nil {
+		s.log.Error("Failed to assemble array result", zap.Error(err))
+		return nil
+	}
+	if array != nil {
+		s.storage.UpdateExpressionArrayResult(s.log, exprID, array)
+		s.log.Info(constants.LogFinalResultReady,
+			zap.String(constants.FieldExpressionID, exprID),
+			zap.ByteString("array", array),
+		)
+		return nil
+	}
+
+	finalResult, exactResult, err := s.storage.GetFinalTaskResult(exprID)
+	if err != nil {
+		s.log.Error("Failed to get final task result", zap.Error(err))
+		return nil
+	}
+	s.storage.UpdateExpressionResult(s.log, exprID, finalResult, exactResult)
+	s.log.Info(constants.LogFinalResultReady,
+		zap.String(constants.FieldExpressionID, exprID),
+		zap.Float64("result", finalResult),
+		zap.String("exact_result", exactResult),
+	)
+	return nil
 }
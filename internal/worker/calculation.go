@@ -3,29 +3,52 @@ package worker
 import (
 	"github.com/structxz/calc_v3/internal/app/models"
 	"github.com/structxz/calc_v3/internal/constants"
+	"github.com/structxz/calc_v3/pkg/calculation"
 
 	"go.uber.org/zap"
 )
 
-func (a *Agent) Calculate(task *models.Task) float64 {
-	switch task.Operation {
-	case "+":
-		return task.Arg1 + task.Arg2
-	case "-":
-		return task.Arg1 - task.Arg2
-	case "*":
-		return task.Arg1 * task.Arg2
-	case "/":
-		if task.Arg2 == 0 {
-			a.logger.Error(constants.ErrDivisionByZero,
-				zap.String(constants.FieldTaskID, task.ID))
-			panic(constants.ErrDivisionByZero)
+// Calculate выполняет операцию задачи. В режимах точных вычислений кроме
+// приближённого результата возвращается точный в текстовом виде.
+func (a *Agent) Calculate(task *models.Task) (float64, string, error) {
+	var (
+		result float64
+		exact  string
+		err    error
+	)
+
+	arith, err := calculation.NewArithmetic(calculation.Mode(task.Precision), task.Scale)
+	if err == nil {
+		if arith != nil {
+			result, exact, err = calculateExact(arith, task)
+		} else if fn, ok := calculation.LookupFunction(task.Operation); ok {
+			args := []float64{task.Arg1, task.Arg2}
+			result, err = fn.Call(args[:fn.TaskArity()]...)
+		} else if task.Operation == calculation.SelectOp {
+			result = calculation.Select(task.Arg1, task.Arg2, task.Arg3)
+		} else {
+			result, err = calculation.ApplyBinary(task.Operation, task.Arg1, task.Arg2)
 		}
-		return task.Arg1 / task.Arg2
-	default:
-		a.logger.Error(constants.ErrUnexpectedToken,
+	}
+	if err != nil {
+		a.logger.Error(constants.LogFailedCalculateTask,
 			zap.String(constants.FieldTaskID, task.ID),
-			zap.String(constants.FieldOperation, task.Operation))
-		panic(constants.ErrUnexpectedToken)
+			zap.String(constants.FieldOperation, task.Operation),
+			zap.Error(err))
+		return 0, "", err
+	}
+	return result, exact, nil
+}
+
+func calculateExact(arith *calculation.Arithmetic, task *models.Task) (float64, string, error) {
+	args := []string{task.Arg1Exact, task.Arg2Exact, task.Arg3Exact}
+	exact, err := arith.Apply(task.Operation, args[:calculation.OperandCount(task.Operation)]...)
+	if err != nil {
+		return 0, "", err
+	}
+	result, err := arith.Float(exact)
+	if err != nil {
+		return 0, "", err
 	}
+	return result, exact, nil
 }
@@ -31,7 +31,7 @@ func (a *Agent) getTask() (*models.Task, error) {
 		return nil, fmt.Errorf("task has insufficient operands")
 	}
 
-	return &models.Task{
+	task := &models.Task{
 		ID:           t.Id,
 		ExpressionID: t.ExpressionId,
 		Operation:    t.Operation,
@@ -42,10 +42,29 @@ func (a *Agent) getTask() (*models.Task, error) {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 		DependsOnTaskIDs: t.DependsOn,
-	}, nil
+	}
+	// Третий операнд есть только у задач, выбирающих ветку условного выражения.
+	if len(t.Operands) > 2 {
+		task.Arg3 = t.Operands[2]
+	}
+
+	if t.Precision != "" {
+		if len(t.ExactOperands) < 2 {
+			return nil, fmt.Errorf("task has insufficient exact operands")
+		}
+		task.Precision = t.Precision
+		task.Scale = int(t.Scale)
+		task.Arg1Exact = t.ExactOperands[0]
+		task.Arg2Exact = t.ExactOperands[1]
+		if len(t.ExactOperands) > 2 {
+			task.Arg3Exact = t.ExactOperands[2]
+		}
+	}
+
+	return task, nil
 }
 
-func (a *Agent) sendResult(task *models.Task, result float64) error {
+func (a *Agent) sendResult(task *models.Task, result float64, exact string) error {
 	ctx, cancel := context.WithTimeout(a.ctx, 3*time.Second)
 	defer cancel()
 
@@ -53,6 +72,26 @@ func (a *Agent) sendResult(task *models.Task, result float64) error {
 		TaskId: task.ID,
 		ExpressionId: task.ExpressionID,
 		Result: result,
+		ExactResult: exact,
+	})
+
+	if err != nil {
+		return fmt.Errorf("gRPC SubmitTaskResult failed: %w", err)
+	}
+
+	return nil
+}
+
+// sendError сообщает оркестратору, что задачу невозможно вычислить
+// (например, деление на ноль).
+func (a *Agent) sendError(task *models.Task, taskErr error) error {
+	ctx, cancel := context.WithTimeout(a.ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := a.grpcClient.SubmitTaskResult(ctx, &api.TaskResult{
+		TaskId:       task.ID,
+		ExpressionId: task.ExpressionID,
+		Error:        taskErr.Error(),
 	})
 
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/structxz/calc_v3/internal/constants"
+	"github.com/structxz/calc_v3/pkg/calculation"
 	"go.uber.org/zap"
 )
 
@@ -42,26 +43,45 @@ func (a *Agent) processTask(workerID int) error {
 		zap.String(constants.FieldTaskID, task.ID),
 		zap.String(constants.FieldOperation, task.Operation))
 
-	var operationTime time.Duration = 100 * time.Millisecond
+	time.Sleep(a.operationTime(task.Operation))
 
-	switch task.Operation {
-	case "+":
-		operationTime = 1000 * time.Millisecond
-	case "-":
-		operationTime = 1000 * time.Millisecond
-	case "*":
-		operationTime = 1000 * time.Millisecond
-	case "/":
-		operationTime = 1000 * time.Millisecond
+	result, exact, err := a.Calculate(task)
+	if err != nil {
+		if sendErr := a.sendError(task, err); sendErr != nil {
+			return fmt.Errorf(constants.ErrFormatWithWrap, constants.LogFailedSendResult, sendErr)
+		}
+		return nil
 	}
 
-	time.Sleep(operationTime)
-
-	result := a.Calculate(task)
-
-	if err := a.sendResult(task, result); err != nil {
+	if err := a.sendResult(task, result, exact); err != nil {
 		return fmt.Errorf(constants.ErrFormatWithWrap, constants.LogFailedSendResult, err)
 	}
 
 	return nil
 }
+
+// operationTime возвращает искусственную задержку выполнения операции из конфигурации агента.
+func (a *Agent) operationTime(operation string) time.Duration {
+	var ms int64 = 100
+
+	switch operation {
+	case "+":
+		ms = a.config.AdditionTimeMS
+	case "-":
+		ms = a.config.SubtractionTimeMS
+	case "*":
+		ms = a.config.MultiplyTimeMS
+	case "/", "//":
+		ms = a.config.DivisionTimeMS
+	case "%":
+		ms = a.config.ModuloTimeMS
+	case "^":
+		ms = a.config.PowerTimeMS
+	default:
+		if _, ok := calculation.LookupFunction(operation); ok {
+			ms = a.config.FunctionTimeMS
+		}
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
@@ -28,6 +28,9 @@ type Task struct {
 	Operation     string                 `protobuf:"bytes,3,opt,name=operation,proto3" json:"operation,omitempty"`
 	Operands      []float64              `protobuf:"fixed64,4,rep,packed,name=operands,proto3" json:"operands,omitempty"`
 	DependsOn     []string               `protobuf:"bytes,5,rep,name=depends_on,json=dependsOn,proto3" json:"depends_on,omitempty"`
+	Precision     string                 `protobuf:"bytes,6,opt,name=precision,proto3" json:"precision,omitempty"`
+	Scale         int32                  `protobuf:"varint,7,opt,name=scale,proto3" json:"scale,omitempty"`
+	ExactOperands []string               `protobuf:"bytes,8,rep,name=exact_operands,json=exactOperands,proto3" json:"exact_operands,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -97,6 +100,27 @@ func (x *Task) GetDependsOn() []string {
 	return nil
 }
 
+func (x *Task) GetPrecision() string {
+	if x != nil {
+		return x.Precision
+	}
+	return ""
+}
+
+func (x *Task) GetScale() int32 {
+	if x != nil {
+		return x.Scale
+	}
+	return 0
+}
+
+func (x *Task) GetExactOperands() []string {
+	if x != nil {
+		return x.ExactOperands
+	}
+	return nil
+}
+
 type TaskResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	HasTask       bool                   `protobuf:"varint,1,opt,name=has_task,json=hasTask,proto3" json:"has_task,omitempty"`
@@ -154,6 +178,8 @@ type TaskResult struct {
 	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
 	ExpressionId  string                 `protobuf:"bytes,2,opt,name=expression_id,json=expressionId,proto3" json:"expression_id,omitempty"`
 	Result        float64                `protobuf:"fixed64,3,opt,name=result,proto3" json:"result,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	ExactResult   string                 `protobuf:"bytes,5,opt,name=exact_result,json=exactResult,proto3" json:"exact_result,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -209,6 +235,20 @@ func (x *TaskResult) GetResult() float64 {
 	return 0
 }
 
+func (x *TaskResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *TaskResult) GetExactResult() string {
+	if x != nil {
+		return x.ExactResult
+	}
+	return ""
+}
+
 type AgentInfo struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
@@ -301,22 +341,27 @@ var File_api_messages_proto protoreflect.FileDescriptor
 
 const file_api_messages_proto_rawDesc = "" +
 	"\n" +
-	"\x12api/messages.proto\x12\x18github.com.structxz.calc\"\x94\x01\n" +
+	"\x12api/messages.proto\x12\x18github.com.structxz.calc\"\xef\x01\n" +
 	"\x04Task\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12#\n" +
 	"\rexpression_id\x18\x02 \x01(\tR\fexpressionId\x12\x1c\n" +
 	"\toperation\x18\x03 \x01(\tR\toperation\x12\x1a\n" +
 	"\boperands\x18\x04 \x03(\x01R\boperands\x12\x1d\n" +
 	"\n" +
-	"depends_on\x18\x05 \x03(\tR\tdependsOn\"]\n" +
+	"depends_on\x18\x05 \x03(\tR\tdependsOn\x12\x1c\n" +
+	"\tprecision\x18\x06 \x01(\tR\tprecision\x12\x14\n" +
+	"\x05scale\x18\a \x01(\x05R\x05scale\x12%\n" +
+	"\x0eexact_operands\x18\b \x03(\tR\rexactOperands\"]\n" +
 	"\fTaskResponse\x12\x19\n" +
 	"\bhas_task\x18\x01 \x01(\bR\ahasTask\x122\n" +
-	"\x04task\x18\x02 \x01(\v2\x1e.github.com.structxz.calc.TaskR\x04task\"b\n" +
+	"\x04task\x18\x02 \x01(\v2\x1e.github.com.structxz.calc.TaskR\x04task\"\x9b\x01\n" +
 	"\n" +
 	"TaskResult\x12\x17\n" +
 	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12#\n" +
 	"\rexpression_id\x18\x02 \x01(\tR\fexpressionId\x12\x16\n" +
-	"\x06result\x18\x03 \x01(\x01R\x06result\"&\n" +
+	"\x06result\x18\x03 \x01(\x01R\x06result\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x12!\n" +
+	"\fexact_result\x18\x05 \x01(\tR\vexactResult\"&\n" +
 	"\tAgentInfo\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\"*\n" +
 	"\x0eSubmitResponse\x12\x18\n" +
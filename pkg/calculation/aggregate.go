@@ -0,0 +1,260 @@
+package calculation
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// MaxOrderStatistics is the largest number of values median and percentile
+// accept. Their values are selected by a sorting network of min and max
+// operations, whose size grows as n·log²n.
+const MaxOrderStatistics = 1024
+
+// isAggregate reports whether name is an aggregate function.
+func isAggregate(name string) bool {
+	switch name {
+	case "sum", "mean", "median", "stddev", "percentile":
+		return true
+	}
+	return false
+}
+
+// foldAggregate expands an aggregate function into operations on the
+// values of its arguments. Vectors and matrices contribute all of their
+// elements, so sum(1, 2, 3) and sum([1, 2, 3]) are the same. Sums are
+// computed by a balanced reduction tree, so n values need n-1 additions but
+// only log2(n) of them depend on each other.
+func foldAggregate[T any](call *CallExpr, args []Array[T], alg Algebra[T]) (Array[T], error) {
+	if call.Name == "percentile" {
+		args = args[:len(args)-1]
+	}
+	var values []T
+	for _, arg := range args {
+		values = append(values, arg.Elems...)
+	}
+	n := len(values)
+
+	switch call.Name {
+	case "sum":
+		x, err := reduce(alg, "+", values)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		return Scalar(x), nil
+	case "mean":
+		x, err := mean(alg, call, values)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		return Scalar(x), nil
+	case "stddev":
+		// Population standard deviation: sqrt(sum((x - mean)^2) / n).
+		m, err := mean(alg, call, values)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		two, err := number(alg, call, 2)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		squares := make([]T, n)
+		for i, x := range values {
+			d, err := alg.Binary("-", x, m)
+			if err != nil {
+				return Array[T]{}, err
+			}
+			if squares[i], err = alg.Binary("^", d, two); err != nil {
+				return Array[T]{}, err
+			}
+		}
+		variance, err := mean(alg, call, squares)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		sqrt, _ := LookupFunction("sqrt")
+		x, err := alg.Call(sqrt, []T{variance})
+		if err != nil {
+			return Array[T]{}, err
+		}
+		return Scalar(x), nil
+	case "median", "percentile":
+		if n > MaxOrderStatistics {
+			return Array[T]{}, fmt.Errorf("function %s supports up to %d values, got %d at position %d",
+				call.Name, MaxOrderStatistics, n, call.NamePos)
+		}
+
+		rank := 50.0
+		if call.Name == "percentile" {
+			var err error
+			if rank, err = percentileRank(call); err != nil {
+				return Array[T]{}, err
+			}
+		}
+		x, err := percentile(alg, call, values, rank)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		return Scalar(x), nil
+	default:
+		return Array[T]{}, fmt.Errorf("function %s is not an aggregate at position %d", call.Name, call.NamePos)
+	}
+}
+
+// reduce combines values with the associative operator op as a balanced
+// binary tree: ((a op b) op (c op d)) rather than (((a op b) op c) op d).
+func reduce[T any](alg Algebra[T], op string, values []T) (T, error) {
+	if len(values) == 1 {
+		return values[0], nil
+	}
+	mid := len(values) / 2
+	left, err := reduce(alg, op, values[:mid])
+	if err != nil {
+		return left, err
+	}
+	right, err := reduce(alg, op, values[mid:])
+	if err != nil {
+		return right, err
+	}
+	return alg.Binary(op, left, right)
+}
+
+// mean returns the arithmetic mean of values.
+func mean[T any](alg Algebra[T], call *CallExpr, values []T) (T, error) {
+	sum, err := reduce(alg, "+", values)
+	if err != nil {
+		return sum, err
+	}
+	if len(values) == 1 {
+		return sum, nil
+	}
+	count, err := number(alg, call, len(values))
+	if err != nil {
+		return count, err
+	}
+	return alg.Binary("/", sum, count)
+}
+
+// number returns the integer n as a value of the algebra.
+func number[T any](alg Algebra[T], call *CallExpr, n int) (T, error) {
+	literal := strconv.Itoa(n)
+	return alg.Leaf(&NumberLit{ValuePos: call.NamePos, Literal: literal, Value: float64(n)})
+}
+
+// percentileRank returns the rank given by the last argument of a call of
+// percentile. It must be a constant between 0 and 100.
+func percentileRank(call *CallExpr) (float64, error) {
+	arg := call.Args[len(call.Args)-1]
+	rank, err := Evaluate(arg)
+	if err != nil || rank < 0 || rank > 100 {
+		return 0, fmt.Errorf("function percentile expects a constant rank between 0 and 100 at position %d", arg.Pos())
+	}
+	return rank, nil
+}
+
+// percentile returns the rank-th percentile of values, interpolating
+// linearly between the two nearest order statistics like most spreadsheets.
+func percentile[T any](alg Algebra[T], call *CallExpr, values []T, rank float64) (T, error) {
+	pos := rank / 100 * float64(len(values)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+
+	sorted := newSortingNetwork(alg, values)
+	low, err := sorted.at(lo)
+	if err != nil || hi == lo {
+		return low, err
+	}
+	high, err := sorted.at(hi)
+	if err != nil {
+		return high, err
+	}
+
+	// low + (high - low) * fraction
+	diff, err := alg.Binary("-", high, low)
+	if err != nil {
+		return diff, err
+	}
+	frac := pos - float64(lo)
+	literal := strconv.FormatFloat(frac, 'f', -1, 64)
+	weight, err := alg.Leaf(&NumberLit{ValuePos: call.NamePos, Literal: literal, Value: frac})
+	if err != nil {
+		return weight, err
+	}
+	if diff, err = alg.Binary("*", diff, weight); err != nil {
+		return diff, err
+	}
+	return alg.Binary("+", low, diff)
+}
+
+// sortingNetwork sorts values with Batcher's odd-even merge sort built from
+// min and max operations, so that the order of values computed by other
+// tasks does not have to be known in advance. The network is first laid out
+// symbolically; at materialises only the operations an output depends on.
+type sortingNetwork[T any] struct {
+	alg    Algebra[T]
+	values []T
+	wires  []*wire
+	memo   map[*wire]T
+}
+
+// wire is a value inside the network: an input or the min or max of two wires.
+type wire struct {
+	input int    // Index of the input value, or -1.
+	op    string // "min" or "max".
+	a, b  *wire
+}
+
+func newSortingNetwork[T any](alg Algebra[T], values []T) *sortingNetwork[T] {
+	n := len(values)
+	wires := make([]*wire, n)
+	for i := range wires {
+		wires[i] = &wire{input: i}
+	}
+
+	for p := 1; p < n; p <<= 1 {
+		for k := p; k >= 1; k >>= 1 {
+			for j := k % p; j+k < n; j += 2 * k {
+				for i := 0; i < k && i+j+k < n; i++ {
+					if (i+j)/(2*p) != (i+j+k)/(2*p) {
+						continue
+					}
+					a, b := wires[i+j], wires[i+j+k]
+					wires[i+j] = &wire{input: -1, op: "min", a: a, b: b}
+					wires[i+j+k] = &wire{input: -1, op: "max", a: a, b: b}
+				}
+			}
+		}
+	}
+
+	return &sortingNetwork[T]{alg: alg, values: values, wires: wires, memo: make(map[*wire]T)}
+}
+
+// at returns the k-th smallest value.
+func (s *sortingNetwork[T]) at(k int) (T, error) {
+	return s.eval(s.wires[k])
+}
+
+func (s *sortingNetwork[T]) eval(w *wire) (T, error) {
+	if w.input >= 0 {
+		return s.values[w.input], nil
+	}
+	if x, ok := s.memo[w]; ok {
+		return x, nil
+	}
+
+	a, err := s.eval(w.a)
+	if err != nil {
+		return a, err
+	}
+	b, err := s.eval(w.b)
+	if err != nil {
+		return b, err
+	}
+	fn, _ := LookupFunction(w.op)
+	x, err := s.alg.Call(fn, []T{a, b})
+	if err != nil {
+		return x, err
+	}
+	s.memo[w] = x
+	return x, nil
+}
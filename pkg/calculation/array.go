@@ -0,0 +1,596 @@
+package calculation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// MaxDeterminantSize is the largest matrix det accepts. The determinant is
+// expanded into minors, whose number grows as 2^n.
+const MaxDeterminantSize = 6
+
+// Shape is the shape of a value: empty for numbers, [n] for vectors of n
+// elements and [rows, cols] for matrices.
+type Shape []int
+
+// String formats the shape as "number", "[3]" or "[2,3]".
+func (s Shape) String() string {
+	if len(s) == 0 {
+		return "number"
+	}
+	dims := make([]string, len(s))
+	for i, n := range s {
+		dims[i] = strconv.Itoa(n)
+	}
+	return "[" + strings.Join(dims, ",") + "]"
+}
+
+func (s Shape) equal(o Shape) bool {
+	if len(s) != len(o) {
+		return false
+	}
+	for i := range s {
+		if s[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Array is a number, a vector or a matrix with elements of type T stored in
+// row-major order.
+type Array[T any] struct {
+	Shape Shape
+	Elems []T
+}
+
+// Scalar returns an array holding the single number x.
+func Scalar[T any](x T) Array[T] {
+	return Array[T]{Elems: []T{x}}
+}
+
+// IsScalar reports whether the array is a plain number.
+func (a Array[T]) IsScalar() bool { return len(a.Shape) == 0 }
+
+// at returns the element at row i and column j of a matrix.
+func (a Array[T]) at(i, j int) T { return a.Elems[i*a.Shape[1]+j] }
+
+// Nested returns the value as T, []T or [][]T, ready to be encoded as JSON.
+func (a Array[T]) Nested() any {
+	switch len(a.Shape) {
+	case 0:
+		return a.Elems[0]
+	case 1:
+		return a.Elems
+	default:
+		rows := make([][]T, a.Shape[0])
+		for i := range rows {
+			rows[i] = a.Elems[i*a.Shape[1] : (i+1)*a.Shape[1]]
+		}
+		return rows
+	}
+}
+
+// Algebra supplies the scalar operations from which Fold builds vector and
+// matrix arithmetic. Evaluating an expression and splitting it into tasks
+// are both folds over the same tree with different algebras.
+//
+// Conditionals are folded lazily. If Truth knows the value of the condition,
+// Fold folds only the taken branch. Otherwise it folds both branches through
+// Branch, which may arrange for a branch to be computed only when taken, and
+// joins their values with Select.
+type Algebra[T any] interface {
+	Leaf(node Node) (T, error)              // Value of a number, quantity or variable.
+	Negate(x T) (T, error)                  // Unary minus.
+	Binary(op string, x, y T) (T, error)    // An arithmetic, comparison or logical operator.
+	Call(fn *Function, args []T) (T, error) // Function of numbers.
+
+	Truth(cond T) (value, known bool)                                           // Value of a condition, if already known.
+	Branch(cond T, taken bool, fold func() (Array[T], error)) (Array[T], error) // Folds the branch used when cond is true or false.
+	Select(cond, x, y T) (T, error)                                             // x if cond is true, y otherwise.
+}
+
+// Fold computes the value of the tree rooted at node with the operations of
+// alg. Operators and functions of numbers apply to vectors and matrices
+// element-wise, with numbers broadcast to the shape of the other operands.
+// Functions of arrays such as dot and det are expanded into operations on
+// their elements.
+func Fold[T any](node Node, alg Algebra[T]) (Array[T], error) {
+	switch n := node.(type) {
+	case *NumberLit, *ImagLit, *Ident, *QuantityExpr:
+		x, err := alg.Leaf(node)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		return Scalar(x), nil
+	case *ParenExpr:
+		return Fold(n.X, alg)
+	case *Script:
+		return foldScript(n, alg)
+	case *UserCall:
+		return foldScript(n.Body, alg)
+	case *Ref:
+		if s, ok := alg.(*scope[T]); ok {
+			if x, ok := s.values[n.Binding]; ok {
+				return x, nil
+			}
+		}
+		return Fold(n.Binding.Value, alg)
+	case *ListExpr:
+		return foldList(n, alg)
+	case *UnaryExpr:
+		x, err := Fold(n.X, alg)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		switch n.Op {
+		case "-":
+			return elementwise([]Array[T]{x}, func(args []T) (T, error) {
+				return alg.Negate(args[0])
+			})
+		case "!":
+			// !x is x == 0.
+			zero, err := alg.Leaf(&NumberLit{ValuePos: n.OpPos, Literal: "0"})
+			if err != nil {
+				return Array[T]{}, err
+			}
+			return elementwise([]Array[T]{x}, func(args []T) (T, error) {
+				return alg.Binary("==", args[0], zero)
+			})
+		case "~":
+			// ~x is x xor -1: in two's complement -1 has every bit set.
+			minusOne, err := alg.Leaf(&NumberLit{ValuePos: n.OpPos, Literal: "-1", Value: -1})
+			if err != nil {
+				return Array[T]{}, err
+			}
+			return elementwise([]Array[T]{x}, func(args []T) (T, error) {
+				return alg.Binary("xor", args[0], minusOne)
+			})
+		default:
+			return Array[T]{}, fmt.Errorf("%s '%s' at position %d", constants.ErrUnexpectedToken, n.Op, n.OpPos)
+		}
+	case *CondExpr:
+		return foldCond(n, alg)
+	case *BinaryExpr:
+		left, err := Fold(n.Left, alg)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		if n.Op == "&&" || n.Op == "||" {
+			if shape, err := ShapeOf(n.Right); err == nil && left.IsScalar() && len(shape) == 0 {
+				return foldLogical(n, left.Elems[0], alg)
+			}
+		}
+		right, err := Fold(n.Right, alg)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		if !broadcastable(left.Shape, right.Shape) {
+			return Array[T]{}, fmt.Errorf("%s: %s and %s for '%s' at position %d",
+				constants.ErrShapeMismatch, left.Shape, right.Shape, n.Op, n.OpPos)
+		}
+		return elementwise([]Array[T]{left, right}, func(args []T) (T, error) {
+			return alg.Binary(n.Op, args[0], args[1])
+		})
+	case *CallExpr:
+		fn, ok := LookupFunction(n.Name)
+		if !ok {
+			return Array[T]{}, fmt.Errorf("%s '%s' at position %d", constants.ErrUnknownFunction, n.Name, n.NamePos)
+		}
+
+		args := make([]Array[T], len(n.Args))
+		for i, arg := range n.Args {
+			x, err := Fold(arg, alg)
+			if err != nil {
+				return Array[T]{}, err
+			}
+			args[i] = x
+		}
+
+		if fn.TakesArrays() {
+			if isAggregate(fn.Name) {
+				return foldAggregate(n, args, alg)
+			}
+			return foldLinear(n, args, alg)
+		}
+		for _, arg := range args[1:] {
+			if !broadcastable(args[0].Shape, arg.Shape) {
+				return Array[T]{}, fmt.Errorf("%s: %s and %s in %s at position %d",
+					constants.ErrShapeMismatch, args[0].Shape, arg.Shape, n.Name, n.NamePos)
+			}
+		}
+		return elementwise(args, func(elems []T) (T, error) {
+			return alg.Call(fn, elems)
+		})
+	default:
+		return Array[T]{}, fmt.Errorf("unsupported node %T", node)
+	}
+}
+
+// ShapeOf checks that the vector and matrix operations in the tree rooted
+// at node fit together and returns the shape of its value.
+func ShapeOf(node Node) (Shape, error) {
+	value, err := Fold[struct{}](node, shapeAlgebra{})
+	if err != nil {
+		return nil, err
+	}
+	return value.Shape, nil
+}
+
+// shapeAlgebra computes nothing and lets Fold check shapes only.
+type shapeAlgebra struct{}
+
+func (shapeAlgebra) Leaf(Node) (struct{}, error)                         { return struct{}{}, nil }
+func (shapeAlgebra) Negate(struct{}) (struct{}, error)                   { return struct{}{}, nil }
+func (shapeAlgebra) Binary(string, struct{}, struct{}) (struct{}, error) { return struct{}{}, nil }
+func (shapeAlgebra) Call(*Function, []struct{}) (struct{}, error)        { return struct{}{}, nil }
+func (shapeAlgebra) Truth(struct{}) (bool, bool)                         { return false, false }
+func (shapeAlgebra) Select(_, _, _ struct{}) (struct{}, error)           { return struct{}{}, nil }
+
+func (shapeAlgebra) Branch(_ struct{}, _ bool, fold func() (Array[struct{}], error)) (Array[struct{}], error) {
+	return fold()
+}
+
+// scope is the algebra a script is folded with: the algebra of the caller
+// together with the values of the bindings folded so far.
+type scope[T any] struct {
+	Algebra[T]
+	values map[*Binding]Array[T]
+}
+
+// foldScript folds the bindings the result depends on, in source order, and
+// then the result, in which every reference to a binding is the value
+// folded once. The bindings are folded before the result, so a binding used
+// only in a branch of a conditional is computed whichever branch is taken.
+// A script nested in another one, the expansion of a call of a user-defined
+// function, adds its bindings to the scope of the outer script.
+func foldScript[T any](n *Script, alg Algebra[T]) (Array[T], error) {
+	s, ok := alg.(*scope[T])
+	if !ok {
+		s = &scope[T]{Algebra: alg, values: make(map[*Binding]Array[T])}
+	}
+	for _, binding := range n.Used() {
+		x, err := Fold(binding.Value, Algebra[T](s))
+		if err != nil {
+			return Array[T]{}, err
+		}
+		s.values[binding] = x
+	}
+	return Fold(n.Result, Algebra[T](s))
+}
+
+// foldCond folds a conditional. The condition must be a number, and both
+// branches must have the same shape so that the shape of the value does not
+// depend on the condition.
+func foldCond[T any](n *CondExpr, alg Algebra[T]) (Array[T], error) {
+	cond, err := Fold(n.Cond, alg)
+	if err != nil {
+		return Array[T]{}, err
+	}
+	if !cond.IsScalar() {
+		return Array[T]{}, fmt.Errorf("%s: condition at position %d is a %s, expected a number",
+			constants.ErrShapeMismatch, n.Cond.Pos(), cond.Shape)
+	}
+	c := cond.Elems[0]
+
+	if value, known := alg.Truth(c); known {
+		if value {
+			return Fold(n.Then, alg)
+		}
+		return Fold(n.Else, alg)
+	}
+
+	then, err := alg.Branch(c, true, func() (Array[T], error) { return Fold(n.Then, alg) })
+	if err != nil {
+		return Array[T]{}, err
+	}
+	els, err := alg.Branch(c, false, func() (Array[T], error) { return Fold(n.Else, alg) })
+	if err != nil {
+		return Array[T]{}, err
+	}
+	if !then.Shape.equal(els.Shape) {
+		return Array[T]{}, fmt.Errorf("%s: branches of the conditional at position %d are %s and %s",
+			constants.ErrShapeMismatch, n.Pos(), then.Shape, els.Shape)
+	}
+	return elementwise([]Array[T]{then, els}, func(args []T) (T, error) {
+		return alg.Select(c, args[0], args[1])
+	})
+}
+
+// foldLogical folds a && b and a || b of numbers lazily, as the conditionals
+// a ? b != 0 : 0 and a ? 1 : b != 0: the right operand is computed only when
+// the left one does not decide the result, so x != 0 && y / x > 2 does not
+// divide by zero.
+func foldLogical[T any](n *BinaryExpr, left T, alg Algebra[T]) (Array[T], error) {
+	decided := "0"
+	if n.Op == "||" {
+		decided = "1"
+	}
+	constant, err := alg.Leaf(&NumberLit{ValuePos: n.OpPos, Literal: decided, Value: truth(decided == "1")})
+	if err != nil {
+		return Array[T]{}, err
+	}
+	fold := func() (Array[T], error) {
+		right, err := Fold(n.Right, alg)
+		if err != nil || isBoolean(n.Right) {
+			return right, err
+		}
+		zero, err := alg.Leaf(&NumberLit{ValuePos: n.OpPos, Literal: "0"})
+		if err != nil {
+			return Array[T]{}, err
+		}
+		return elementwise([]Array[T]{right}, func(args []T) (T, error) {
+			return alg.Binary("!=", args[0], zero)
+		})
+	}
+
+	// The right operand is computed when the left one is true for && and
+	// false for ||.
+	needed := n.Op == "&&"
+	if value, known := alg.Truth(left); known {
+		if value == needed {
+			return fold()
+		}
+		return Scalar(constant), nil
+	}
+
+	right, err := alg.Branch(left, needed, fold)
+	if err != nil {
+		return Array[T]{}, err
+	}
+	x, y := right.Elems[0], constant
+	if !needed {
+		x, y = y, x
+	}
+	value, err := alg.Select(left, x, y)
+	if err != nil {
+		return Array[T]{}, err
+	}
+	return Scalar(value), nil
+}
+
+// isBoolean reports whether the value of node is always 1 or 0: that of a
+// comparison, a logical operator or a negation.
+func isBoolean(node Node) bool {
+	switch n := node.(type) {
+	case *ParenExpr:
+		return isBoolean(n.X)
+	case *UnaryExpr:
+		return n.Op == "!"
+	case *BinaryExpr:
+		switch n.Op {
+		case "<", "<=", ">", ">=", "==", "!=", "&&", "||":
+			return true
+		}
+	}
+	return false
+}
+
+// foldList builds a vector from a list of numbers or a matrix from a list of
+// vectors of equal length.
+func foldList[T any](list *ListExpr, alg Algebra[T]) (Array[T], error) {
+	var result Array[T]
+	for i, elem := range list.Elems {
+		x, err := Fold(elem, alg)
+		if err != nil {
+			return Array[T]{}, err
+		}
+
+		if i == 0 {
+			if len(x.Shape) > 1 {
+				return Array[T]{}, fmt.Errorf("list at position %d nests deeper than a matrix", list.Lbrack)
+			}
+			result.Shape = append(Shape{len(list.Elems)}, x.Shape...)
+		} else if !x.Shape.equal(result.Shape[1:]) {
+			return Array[T]{}, fmt.Errorf("%s: element at position %d is a %s, expected %s",
+				constants.ErrShapeMismatch, elem.Pos(), x.Shape, result.Shape[1:])
+		}
+		result.Elems = append(result.Elems, x.Elems...)
+	}
+	return result, nil
+}
+
+// broadcastable reports whether operands of shapes a and b can be combined
+// element-wise: they are equal or one of them is a number.
+func broadcastable(a, b Shape) bool {
+	return len(a) == 0 || len(b) == 0 || a.equal(b)
+}
+
+// elementwise applies f to the corresponding elements of args, repeating
+// numbers for every element of the other arguments.
+func elementwise[T any](args []Array[T], f func([]T) (T, error)) (Array[T], error) {
+	var result Array[T]
+	size := 1
+	for _, arg := range args {
+		if !arg.IsScalar() {
+			result.Shape = arg.Shape
+			size = len(arg.Elems)
+		}
+	}
+
+	result.Elems = make([]T, size)
+	elems := make([]T, len(args))
+	for i := range result.Elems {
+		for j, arg := range args {
+			if arg.IsScalar() {
+				elems[j] = arg.Elems[0]
+			} else {
+				elems[j] = arg.Elems[i]
+			}
+		}
+		x, err := f(elems)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		result.Elems[i] = x
+	}
+	return result, nil
+}
+
+// foldLinear expands a function of vectors or matrices into operations on
+// their elements.
+func foldLinear[T any](call *CallExpr, args []Array[T], alg Algebra[T]) (Array[T], error) {
+	fail := func(format string, a ...any) (Array[T], error) {
+		return Array[T]{}, fmt.Errorf("function %s %s at position %d", call.Name, fmt.Sprintf(format, a...), call.NamePos)
+	}
+
+	switch call.Name {
+	case "dot":
+		u, v := args[0], args[1]
+		if len(u.Shape) != 1 || !u.Shape.equal(v.Shape) {
+			return fail("expects two vectors of equal length, got %s and %s", u.Shape, v.Shape)
+		}
+		x, err := dot(alg, u.Elems, v.Elems)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		return Scalar(x), nil
+	case "matmul":
+		a, b := args[0], args[1]
+		if a.IsScalar() || b.IsScalar() || (len(a.Shape) == 1 && len(b.Shape) == 1) {
+			return fail("expects a matrix and a matrix or vector, got %s and %s", a.Shape, b.Shape)
+		}
+		// A vector on the left is a row, a vector on the right is a column.
+		rows, inner, cols := 1, a.Shape[0], 1
+		if len(a.Shape) == 2 {
+			rows, inner = a.Shape[0], a.Shape[1]
+		}
+		if len(b.Shape) == 2 {
+			cols = b.Shape[1]
+		}
+		if b.Shape[0] != inner {
+			return fail("cannot multiply %s by %s", a.Shape, b.Shape)
+		}
+
+		result := Array[T]{Elems: make([]T, 0, rows*cols)}
+		switch {
+		case len(a.Shape) == 1:
+			result.Shape = Shape{cols}
+		case len(b.Shape) == 1:
+			result.Shape = Shape{rows}
+		default:
+			result.Shape = Shape{rows, cols}
+		}
+
+		// Every element of the product is an independent dot product of a
+		// row of a and a column of b.
+		for i := 0; i < rows; i++ {
+			row := a.Elems[i*inner : (i+1)*inner]
+			for j := 0; j < cols; j++ {
+				col := make([]T, inner)
+				for k := range col {
+					col[k] = b.Elems[k*cols+j]
+				}
+				x, err := dot(alg, row, col)
+				if err != nil {
+					return Array[T]{}, err
+				}
+				result.Elems = append(result.Elems, x)
+			}
+		}
+		return result, nil
+	case "transpose":
+		a := args[0]
+		if len(a.Shape) != 2 {
+			return fail("expects a matrix, got %s", a.Shape)
+		}
+		result := Array[T]{Shape: Shape{a.Shape[1], a.Shape[0]}, Elems: make([]T, 0, len(a.Elems))}
+		for j := 0; j < a.Shape[1]; j++ {
+			for i := 0; i < a.Shape[0]; i++ {
+				result.Elems = append(result.Elems, a.at(i, j))
+			}
+		}
+		return result, nil
+	case "det":
+		a := args[0]
+		if len(a.Shape) != 2 || a.Shape[0] != a.Shape[1] {
+			return fail("expects a square matrix, got %s", a.Shape)
+		}
+		if a.Shape[0] > MaxDeterminantSize {
+			return fail("supports matrices up to %dx%d, got %s", MaxDeterminantSize, MaxDeterminantSize, a.Shape)
+		}
+		d := &determinant[T]{alg: alg, a: a, minors: make(map[int]T)}
+		x, err := d.minor(0, 1<<a.Shape[0]-1)
+		if err != nil {
+			return Array[T]{}, err
+		}
+		return Scalar(x), nil
+	default:
+		return fail("is not defined for vectors and matrices")
+	}
+}
+
+// dot returns the sum of the products of corresponding elements of u and v.
+func dot[T any](alg Algebra[T], u, v []T) (T, error) {
+	var sum T
+	for i := range u {
+		product, err := alg.Binary("*", u[i], v[i])
+		if err != nil {
+			return sum, err
+		}
+		if i == 0 {
+			sum = product
+			continue
+		}
+		if sum, err = alg.Binary("+", sum, product); err != nil {
+			return sum, err
+		}
+	}
+	return sum, nil
+}
+
+// determinant computes a determinant by cofactor expansion along the first
+// row. Minors are memoised by the set of columns they keep, so every minor
+// is computed once however many larger minors share it.
+type determinant[T any] struct {
+	alg    Algebra[T]
+	a      Array[T]
+	minors map[int]T
+}
+
+// minor returns the determinant of the submatrix of rows row..n-1 and of
+// the columns in the bit set cols.
+func (d *determinant[T]) minor(row, cols int) (T, error) {
+	if x, ok := d.minors[cols]; ok {
+		return x, nil
+	}
+
+	var result T
+	sign := 0
+	for j := 0; j < d.a.Shape[1]; j++ {
+		if cols&(1<<j) == 0 {
+			continue
+		}
+
+		term := d.a.at(row, j)
+		if rest := cols &^ (1 << j); rest != 0 {
+			sub, err := d.minor(row+1, rest)
+			if err != nil {
+				return result, err
+			}
+			if term, err = d.alg.Binary("*", term, sub); err != nil {
+				return result, err
+			}
+		}
+
+		var err error
+		switch {
+		case sign == 0:
+			result = term
+		case sign%2 == 1:
+			result, err = d.alg.Binary("-", result, term)
+		default:
+			result, err = d.alg.Binary("+", result, term)
+		}
+		if err != nil {
+			return result, err
+		}
+		sign++
+	}
+
+	d.minors[cols] = result
+	return result, nil
+}
@@ -0,0 +1,323 @@
+package calculation
+
+import "strings"
+
+// Pos is a byte offset into the source expression.
+type Pos int
+
+// NoPos marks a position that does not occur in the source.
+const NoPos Pos = -1
+
+// Node is a node of the expression syntax tree.
+type Node interface {
+	Pos() Pos // Position of the first character of the node.
+	End() Pos // Position of the first character immediately after the node.
+}
+
+// NumberLit is a numeric literal such as 42, 2.5, 1e-9, 0xFF or 1_000.
+type NumberLit struct {
+	ValuePos Pos     // Position of the literal.
+	Literal  string  // Literal text as written in the source.
+	Value    float64 // Parsed value of the literal.
+}
+
+// ImagLit is an imaginary literal such as 2i or 0.5i.
+type ImagLit struct {
+	ValuePos Pos     // Position of the literal.
+	Literal  string  // Literal text as written in the source, including the trailing i.
+	Value    float64 // Imaginary part of the literal.
+}
+
+// QuantityExpr is a number with a unit of measurement such as 4 km/h.
+type QuantityExpr struct {
+	X        *NumberLit // Magnitude in the given unit.
+	UnitPos  Pos        // Position of the unit.
+	UnitText string     // Unit as written in the source, without spaces, e.g. "km/h".
+	UnitEnd  Pos        // Position immediately after the unit.
+	Unit     Unit       // Parsed unit.
+}
+
+// Decimal returns the literal as a plain decimal number, such as 255 for
+// 0xFF, which reads the same in every mode of precise arithmetic.
+func (n *NumberLit) Decimal() string {
+	if _, text, err := parseNumber(n.Literal); err == nil {
+		return text
+	}
+	return n.Literal
+}
+
+// Decimal returns the literal as a plain decimal number followed by i.
+func (n *ImagLit) Decimal() string {
+	if _, text, err := parseNumber(strings.TrimSuffix(n.Literal, "i")); err == nil {
+		return text + "i"
+	}
+	return n.Literal
+}
+
+// Value returns the magnitude of the quantity in SI base units.
+func (n *QuantityExpr) Value() float64 { return n.X.Value * n.Unit.Factor }
+
+// Ident is a variable reference such as rate.
+type Ident struct {
+	NamePos Pos    // Position of the identifier.
+	Name    string // Variable name.
+}
+
+// UnaryExpr is a prefix operation such as -x or !x.
+type UnaryExpr struct {
+	OpPos Pos    // Position of the operator.
+	Op    string // Operator: "-" or "!".
+	X     Node   // Operand.
+}
+
+// BinaryExpr is an infix operation such as x + y.
+type BinaryExpr struct {
+	Left  Node   // Left operand.
+	OpPos Pos    // Position of the operator.
+	Op    string // Operator, e.g. "+", "<=" or "&&".
+	Right Node   // Right operand.
+}
+
+// CallExpr is a call of a built-in function such as max(a, b, c).
+type CallExpr struct {
+	NamePos Pos    // Position of the function name.
+	Name    string // Function name.
+	Lparen  Pos    // Position of "(", or NoPos in RPN.
+	Args    []Node // Function arguments.
+	Rparen  Pos    // Position of ")", or NoPos in RPN.
+}
+
+// UserCall is a call of a user-defined function such as f(3, 4). The
+// parser expands it in place: Body binds the parameters of the function to
+// the arguments, so each argument is computed once, and ends with the body
+// of the function. Positions inside the body are those of its definition.
+type UserCall struct {
+	NamePos Pos     // Position of the function name.
+	Name    string  // Function name.
+	Lparen  Pos     // Position of "(", or NoPos in RPN.
+	Args    []Node  // Function arguments, the values of the bindings of Body.
+	Rparen  Pos     // Position of ")", or NoPos in RPN.
+	Body    *Script // Expansion of the call.
+}
+
+// ListExpr is a vector or matrix literal such as [1, 2] or [[1, 2], [3, 4]].
+type ListExpr struct {
+	Lbrack Pos    // Position of "[".
+	Elems  []Node // Elements of the list.
+	Rbrack Pos    // Position of "]".
+}
+
+// CondExpr is a conditional expression, written cond ? a : b or
+// if(cond, a, b). Only the branch selected by the condition is evaluated.
+type CondExpr struct {
+	If       Pos  // Position of "if", or NoPos for cond ? a : b.
+	Cond     Node // Condition; any non-zero value is true.
+	Question Pos  // Position of "?", or of "(" after if.
+	Then     Node // Value if the condition is true.
+	Colon    Pos  // Position of ":", or of the comma before the else branch.
+	Else     Node // Value if the condition is false.
+	Rparen   Pos  // Position of ")" after if, or NoPos for cond ? a : b.
+}
+
+// ParenExpr is an expression wrapped in parentheses.
+type ParenExpr struct {
+	Lparen Pos  // Position of "(".
+	X      Node // Expression inside the parentheses.
+	Rparen Pos  // Position of ")".
+}
+
+// Script is a sequence of bindings followed by the expression that gives
+// its value, such as "x = 3*4; y = x + 2; y * x".
+type Script struct {
+	Bindings []*Binding // Bindings in source order.
+	Result   Node       // Value of the script.
+}
+
+// Binding is a statement name = value of a script. Later statements refer
+// to the value through Ref nodes, so it is computed once however many
+// times it is used.
+type Binding struct {
+	NamePos Pos    // Position of the name, or NoPos for a parameter of a user-defined function.
+	Name    string // Bound name.
+	Value   Node   // Bound expression.
+	Semi    Pos    // Position of ";" ending the statement, or NoPos for a parameter.
+}
+
+// Ref is a reference to a binding of a script, such as x in y * x.
+type Ref struct {
+	NamePos Pos      // Position of the name.
+	Name    string   // Bound name.
+	Binding *Binding // Binding the name refers to.
+}
+
+func (n *NumberLit) Pos() Pos    { return n.ValuePos }
+func (n *ImagLit) Pos() Pos      { return n.ValuePos }
+func (n *QuantityExpr) Pos() Pos { return n.X.Pos() }
+func (n *Ident) Pos() Pos        { return n.NamePos }
+func (n *UnaryExpr) Pos() Pos    { return n.OpPos }
+func (n *BinaryExpr) Pos() Pos   { return n.Left.Pos() }
+func (n *CallExpr) Pos() Pos     { return n.NamePos }
+func (n *ListExpr) Pos() Pos     { return n.Lbrack }
+func (n *ParenExpr) Pos() Pos    { return n.Lparen }
+func (n *Ref) Pos() Pos          { return n.NamePos }
+func (n *UserCall) Pos() Pos     { return n.NamePos }
+func (n *Script) Pos() Pos {
+	if len(n.Bindings) == 0 {
+		return n.Result.Pos()
+	}
+	return n.Bindings[0].Pos()
+}
+func (n *Binding) Pos() Pos {
+	if n.NamePos == NoPos {
+		return n.Value.Pos()
+	}
+	return n.NamePos
+}
+func (n *CondExpr) Pos() Pos {
+	if n.If != NoPos {
+		return n.If
+	}
+	return n.Cond.Pos()
+}
+
+func (n *NumberLit) End() Pos    { return n.ValuePos + Pos(len(n.Literal)) }
+func (n *ImagLit) End() Pos      { return n.ValuePos + Pos(len(n.Literal)) }
+func (n *QuantityExpr) End() Pos { return n.UnitEnd }
+func (n *Ident) End() Pos        { return n.NamePos + Pos(len(n.Name)) }
+func (n *UnaryExpr) End() Pos    { return n.X.End() }
+func (n *BinaryExpr) End() Pos   { return n.Right.End() }
+func (n *CallExpr) End() Pos     { return callEnd(n.NamePos, n.Name, n.Rparen) }
+func (n *ListExpr) End() Pos     { return n.Rbrack + 1 }
+func (n *ParenExpr) End() Pos    { return n.Rparen + 1 }
+func (n *Script) End() Pos       { return n.Result.End() }
+func (n *Ref) End() Pos          { return n.NamePos + Pos(len(n.Name)) }
+func (n *UserCall) End() Pos     { return callEnd(n.NamePos, n.Name, n.Rparen) }
+func (n *Binding) End() Pos {
+	if n.Semi == NoPos {
+		return n.Value.End()
+	}
+	return n.Semi + 1
+}
+func (n *CondExpr) End() Pos {
+	if n.Rparen != NoPos {
+		return n.Rparen + 1
+	}
+	return n.Else.End()
+}
+
+// callEnd returns the end of a call: after ")", or after the name of a
+// call in RPN, which has no parentheses.
+func callEnd(namePos Pos, name string, rparen Pos) Pos {
+	if rparen == NoPos {
+		return namePos + Pos(len(name))
+	}
+	return rparen + 1
+}
+
+// Inspect traverses the tree rooted at node in depth-first order, calling f
+// for each node. If f returns false, the children of that node are skipped.
+// The value of a binding is visited once, as a child of the binding rather
+// than of the references to it. The arguments of a call of a user-defined
+// function are visited as the values of the bindings of its expansion.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *QuantityExpr:
+		Inspect(n.X, f)
+	case *UnaryExpr:
+		Inspect(n.X, f)
+	case *BinaryExpr:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *CallExpr:
+		for _, arg := range n.Args {
+			Inspect(arg, f)
+		}
+	case *ListExpr:
+		for _, elem := range n.Elems {
+			Inspect(elem, f)
+		}
+	case *ParenExpr:
+		Inspect(n.X, f)
+	case *CondExpr:
+		Inspect(n.Cond, f)
+		Inspect(n.Then, f)
+		Inspect(n.Else, f)
+	case *Script:
+		for _, binding := range n.Bindings {
+			Inspect(binding, f)
+		}
+		Inspect(n.Result, f)
+	case *Binding:
+		Inspect(n.Value, f)
+	case *UserCall:
+		Inspect(n.Body, f)
+	}
+}
+
+// Used returns the bindings the result of the script depends on, directly
+// or through other bindings, in source order. The others need not be computed.
+func (n *Script) Used() []*Binding {
+	used := make(map[*Binding]bool)
+	var visit func(Node)
+	visit = func(node Node) {
+		Inspect(node, func(node Node) bool {
+			if ref, ok := node.(*Ref); ok && !used[ref.Binding] {
+				used[ref.Binding] = true
+				visit(ref.Binding.Value)
+			}
+			return true
+		})
+	}
+	visit(n.Result)
+
+	var bindings []*Binding
+	for _, binding := range n.Bindings {
+		if used[binding] {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings
+}
+
+// FreeVariables returns the names of the variables referenced in the tree
+// rooted at node, in order of first appearance.
+func FreeVariables(node Node) []string {
+	var names []string
+	seen := make(map[string]bool)
+	Inspect(node, func(n Node) bool {
+		if ident, ok := n.(*Ident); ok && !seen[ident.Name] {
+			seen[ident.Name] = true
+			names = append(names, ident.Name)
+		}
+		return true
+	})
+	return names
+}
+
+// HasImaginary reports whether the tree rooted at node contains an imaginary literal.
+func HasImaginary(node Node) bool {
+	found := false
+	Inspect(node, func(n Node) bool {
+		if _, ok := n.(*ImagLit); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// HasUnits reports whether the tree rooted at node contains a quantity with a unit.
+func HasUnits(node Node) bool {
+	found := false
+	Inspect(node, func(n Node) bool {
+		if _, ok := n.(*QuantityExpr); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
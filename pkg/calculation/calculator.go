@@ -1,34 +1,26 @@
 package calculation
 
 import (
-	"errors"
-
 	"go.uber.org/zap"
 )
 
 var logger *zap.Logger
 
+// EvaluateExpression parses an expression and computes its value.
 func EvaluateExpression(expression string) (float64, error) {
-	if expression == "" {
-		return 0, errors.New("expression is empty")
-	}
-
-	tokens := tokenize(expression)
-	if len(tokens) == 0 {
-		return 0, errors.New("invalid expression")
-	}
-
-	if logger != nil {
-		logger.Debug("Tokens generated", zap.Strings("tokens", tokens))
-	}
+	return EvaluateExpressionWith(expression, nil)
+}
 
-	parser := &Parser{tokens: tokens, pos: 0}
-	result, err := parser.parse()
+// EvaluateExpressionWith parses an expression and computes its value,
+// taking variable values from vars.
+func EvaluateExpressionWith(expression string, vars Variables) (float64, error) {
+	tree, err := Parse(expression)
 	if err != nil {
 		if logger != nil {
 			logger.Error("Parser failed", zap.Error(err), zap.String("expression", expression))
 		}
 		return 0, err
 	}
-	return result, nil
+
+	return EvaluateWith(tree, vars)
 }
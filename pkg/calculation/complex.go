@@ -0,0 +1,167 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// complexSystem is arithmetic on complex128 values. Values are formatted as
+// "re+imi", dropping a zero real or imaginary part: "5+5i", "2i", "-4".
+type complexSystem struct{}
+
+func (complexSystem) parse(text string) (any, error) {
+	c, err := strconv.ParseComplex(text, 128)
+	if err != nil {
+		return nil, fmt.Errorf("invalid complex number %q", text)
+	}
+	return c, nil
+}
+
+func (complexSystem) format(v any) string {
+	return FormatComplex(v.(complex128))
+}
+
+func (complexSystem) float(v any) float64 {
+	return real(v.(complex128))
+}
+
+func (complexSystem) negate(v any) (any, error) {
+	// 0 - v rather than -v: a negative zero imaginary part would put
+	// sqrt(-4) on the other side of the branch cut.
+	return 0 - v.(complex128), nil
+}
+
+func (complexSystem) binary(op string, x, y any) (any, error) {
+	a, b := x.(complex128), y.(complex128)
+
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, errors.New(constants.ErrDivisionByZero)
+		}
+		return a / b, nil
+	case "%":
+		if imag(a) != 0 || imag(b) != 0 {
+			return nil, errors.New(constants.ErrComplexModulo)
+		}
+		r, err := ApplyBinary(op, real(a), real(b))
+		return complex(r, 0), err
+	case "^":
+		if a == 0 && real(b) < 0 {
+			return nil, errors.New(constants.ErrDivisionByZero)
+		}
+		return complexPow(a, b), nil
+	default:
+		return nil, fmt.Errorf("%s '%s'", constants.ErrUnexpectedToken, op)
+	}
+}
+
+func (complexSystem) supports(name string) bool {
+	fn, ok := LookupFunction(name)
+	return ok && !fn.TakesArrays()
+}
+
+func (complexSystem) isZero(v any) bool { return v.(complex128) == 0 }
+
+func (complexSystem) sign(v any) (int, error) {
+	c := v.(complex128)
+	switch {
+	case imag(c) != 0:
+		return 0, errors.New(constants.ErrComplexNotOrdered)
+	case real(c) < 0:
+		return -1, nil
+	case real(c) > 0:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (complexSystem) call(name string, args []any) (any, error) {
+	x := args[0].(complex128)
+
+	switch name {
+	case "sqrt":
+		return cmplx.Sqrt(x), nil
+	case "sin":
+		return cmplx.Sin(x), nil
+	case "cos":
+		return cmplx.Cos(x), nil
+	case "log":
+		if x == 0 {
+			return nil, errors.New(constants.ErrLogOfZero)
+		}
+		return cmplx.Log(x), nil
+	case "exp":
+		return cmplx.Exp(x), nil
+	case "abs":
+		return complex(cmplx.Abs(x), 0), nil
+	case "round":
+		return complex(math.Round(real(x)), math.Round(imag(x))), nil
+	case "min", "max":
+		fn, _ := LookupFunction(name)
+		reals := make([]float64, len(args))
+		for i, arg := range args {
+			c := arg.(complex128)
+			if imag(c) != 0 {
+				return nil, errors.New(constants.ErrComplexNotOrdered)
+			}
+			reals[i] = real(c)
+		}
+		r, err := fn.Call(reals...)
+		return complex(r, 0), err
+	default:
+		return nil, fmt.Errorf("%s '%s'", constants.ErrUnknownFunction, name)
+	}
+}
+
+// maxSquaringExponent bounds the integer exponents that complexPow computes
+// by repeated squaring.
+const maxSquaringExponent = 64
+
+// complexPow raises a to the power b. Small integer exponents use repeated
+// squaring, which keeps results such as i^2 = -1 free of rounding noise that
+// cmplx.Pow introduces through exp and log.
+func complexPow(a, b complex128) complex128 {
+	n := real(b)
+	if imag(b) != 0 || n != math.Trunc(n) || math.Abs(n) > maxSquaringExponent {
+		return cmplx.Pow(a, b)
+	}
+
+	result, base := complex(1, 0), a
+	for e := int(math.Abs(n)); e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result *= base
+		}
+		base *= base
+	}
+	if n < 0 {
+		return 1 / result
+	}
+	return result
+}
+
+// FormatComplex formats c as "re+imi", omitting a zero real or imaginary part.
+func FormatComplex(c complex128) string {
+	re, im := real(c), imag(c)
+	switch {
+	case im == 0:
+		return strconv.FormatFloat(re, 'g', -1, 64)
+	case re == 0:
+		return strconv.FormatFloat(im, 'g', -1, 64) + "i"
+	default:
+		return strings.Trim(strconv.FormatComplex(c, 'g', -1, 128), "()")
+	}
+}
@@ -0,0 +1,279 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// maxExactExponent limits integer exponents in the exact modes.
+const maxExactExponent = 10000
+
+// maxExactBits limits the size in bits of the numerator and denominator of
+// exact results, about 40 000 decimal digits, so that a single task cannot
+// build a number with millions of digits: nesting powers or multiplying
+// large results would get around the limit on exponents alone.
+const maxExactBits = 1 << 17
+
+// maxExactShift limits left shifts in the exact modes for the same reason.
+const maxExactShift = 100000
+
+var (
+	bigOne = big.NewInt(1)
+	bigTen = big.NewInt(10)
+)
+
+// decimalSystem is fixed-point decimal arithmetic: every value, including
+// literals, is rounded half-to-even to scale fractional digits.
+type decimalSystem struct {
+	scale int
+}
+
+func (d decimalSystem) parse(text string) (any, error) {
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal %q", text)
+	}
+	return d.round(r), nil
+}
+
+func (d decimalSystem) format(v any) string {
+	s := v.(*big.Rat).FloatString(d.scale)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+func (d decimalSystem) float(v any) float64 {
+	f, _ := v.(*big.Rat).Float64()
+	return f
+}
+
+func (d decimalSystem) negate(v any) (any, error) {
+	return new(big.Rat).Neg(v.(*big.Rat)), nil
+}
+
+func (d decimalSystem) binary(op string, x, y any) (any, error) {
+	r, err := ratBinary(op, x.(*big.Rat), y.(*big.Rat))
+	if err != nil {
+		return nil, err
+	}
+	return d.round(r), nil
+}
+
+func (d decimalSystem) supports(name string) bool {
+	switch name {
+	case "sqrt", "abs", "round", "min", "max":
+		return true
+	}
+	return false
+}
+
+func (decimalSystem) isZero(v any) bool { return v.(*big.Rat).Sign() == 0 }
+
+func (decimalSystem) sign(v any) (int, error) { return v.(*big.Rat).Sign(), nil }
+
+func (d decimalSystem) call(name string, args []any) (any, error) {
+	x := args[0].(*big.Rat)
+
+	switch name {
+	case "sqrt":
+		if x.Sign() < 0 {
+			return nil, errors.New(constants.ErrSqrtOfNegative)
+		}
+		// floor(2·sqrt(x)·10^scale), then halve with rounding up.
+		n := new(big.Rat).Mul(x, new(big.Rat).SetInt(pow10(2*d.scale)))
+		n.Mul(n, big.NewRat(4, 1))
+		root := new(big.Int).Sqrt(new(big.Int).Quo(n.Num(), n.Denom()))
+		root.Add(root, bigOne).Rsh(root, 1)
+		return new(big.Rat).SetFrac(root, pow10(d.scale)), nil
+	case "min", "max":
+		return ratFold(name, args), nil
+	default:
+		return ratCall(name, x)
+	}
+}
+
+// round rounds r half-to-even to the scale of the system.
+func (d decimalSystem) round(r *big.Rat) *big.Rat {
+	unit := pow10(d.scale)
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(unit))
+	if scaled.IsInt() {
+		return r
+	}
+
+	num := new(big.Int).Abs(scaled.Num())
+	q, rem := new(big.Int).QuoRem(num, scaled.Denom(), new(big.Int))
+	switch rem.Lsh(rem, 1).Cmp(scaled.Denom()) {
+	case 1:
+		q.Add(q, bigOne)
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, bigOne)
+		}
+	}
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+	return new(big.Rat).SetFrac(q, unit)
+}
+
+// ratBinary applies a binary operator to exact rational operands and
+// rejects results larger than maxExactBits.
+func ratBinary(op string, x, y *big.Rat) (*big.Rat, error) {
+	r, err := ratApply(op, x, y)
+	if err != nil {
+		return nil, err
+	}
+	if ratBits(r) > maxExactBits {
+		return nil, errExactTooLarge()
+	}
+	return r, nil
+}
+
+// ratBits returns the size in bits of the larger of the numerator and
+// denominator of r.
+func ratBits(r *big.Rat) int {
+	return max(r.Num().BitLen(), r.Denom().BitLen())
+}
+
+func errExactTooLarge() error {
+	return fmt.Errorf("%s, the limit is %d bits", constants.ErrResultTooLarge, maxExactBits)
+}
+
+func ratApply(op string, x, y *big.Rat) (*big.Rat, error) {
+	switch op {
+	case "+":
+		return new(big.Rat).Add(x, y), nil
+	case "-":
+		return new(big.Rat).Sub(x, y), nil
+	case "*":
+		return new(big.Rat).Mul(x, y), nil
+	case "/":
+		if y.Sign() == 0 {
+			return nil, errors.New(constants.ErrDivisionByZero)
+		}
+		return new(big.Rat).Quo(x, y), nil
+	case "%":
+		if y.Sign() == 0 {
+			return nil, errors.New(constants.ErrModuloByZero)
+		}
+		// x - y·trunc(x/y): the result has the sign of x, like math.Mod.
+		q := new(big.Rat).Quo(x, y)
+		trunc := new(big.Int).Quo(q.Num(), q.Denom())
+		return new(big.Rat).Sub(x, new(big.Rat).Mul(y, new(big.Rat).SetInt(trunc))), nil
+	case "//":
+		if y.Sign() == 0 {
+			return nil, errors.New(constants.ErrDivisionByZero)
+		}
+		q := new(big.Rat).Quo(x, y)
+		floor := new(big.Int).Div(q.Num(), q.Denom())
+		return new(big.Rat).SetInt(floor), nil
+	case "^":
+		return ratPow(x, y)
+	case "&", "|", "xor", "<<", ">>":
+		if !x.IsInt() || !y.IsInt() {
+			return nil, errors.New(constants.ErrInvalidBitwise)
+		}
+		r, err := bigBitwise(op, x.Num(), y.Num())
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Rat).SetInt(r), nil
+	default:
+		return nil, fmt.Errorf("%s '%s'", constants.ErrUnexpectedToken, op)
+	}
+}
+
+// bigBitwise applies a bitwise operator to integers of any size, which
+// behave as two's complement numbers with infinitely many sign bits.
+func bigBitwise(op string, x, y *big.Int) (*big.Int, error) {
+	switch op {
+	case "&":
+		return new(big.Int).And(x, y), nil
+	case "|":
+		return new(big.Int).Or(x, y), nil
+	case "xor":
+		return new(big.Int).Xor(x, y), nil
+	}
+	if y.Sign() < 0 {
+		return nil, errors.New(constants.ErrNegativeShift)
+	}
+	if op == ">>" {
+		if !y.IsInt64() {
+			return big.NewInt(int64(min(x.Sign(), 0))), nil
+		}
+		return new(big.Int).Rsh(x, uint(y.Int64())), nil
+	}
+	if y.CmpAbs(big.NewInt(maxExactShift)) > 0 {
+		return nil, fmt.Errorf("shift count %s is too large, the limit is %d", y, maxExactShift)
+	}
+	return new(big.Int).Lsh(x, uint(y.Int64())), nil
+}
+
+// ratPow raises x to an integer power.
+func ratPow(x, y *big.Rat) (*big.Rat, error) {
+	if !y.IsInt() {
+		return nil, errors.New(constants.ErrNonIntegerExponent)
+	}
+	n := y.Num()
+	if n.CmpAbs(big.NewInt(maxExactExponent)) > 0 {
+		return nil, fmt.Errorf("exponent %s is too large, the limit is %d", n, maxExactExponent)
+	}
+	if x.Sign() == 0 && n.Sign() < 0 {
+		return nil, errors.New(constants.ErrDivisionByZero)
+	}
+
+	e := new(big.Int).Abs(n)
+	// A base of b bits raised to the power e has at least (b-1)*e bits, so
+	// the size is checked before the power is computed.
+	if (ratBits(x)-1)*int(e.Int64()) > maxExactBits {
+		return nil, errExactTooLarge()
+	}
+	num := new(big.Int).Exp(x.Num(), e, nil)
+	den := new(big.Int).Exp(x.Denom(), e, nil)
+	if n.Sign() < 0 {
+		num, den = den, num
+	}
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+// ratCall implements the unary functions that stay exact on rationals.
+func ratCall(name string, x *big.Rat) (*big.Rat, error) {
+	switch name {
+	case "abs":
+		return new(big.Rat).Abs(x), nil
+	case "round":
+		// Half away from zero, like math.Round.
+		half := new(big.Rat).Add(new(big.Rat).Abs(x), big.NewRat(1, 2))
+		r := new(big.Int).Quo(half.Num(), half.Denom())
+		if x.Sign() < 0 {
+			r.Neg(r)
+		}
+		return new(big.Rat).SetInt(r), nil
+	default:
+		return nil, fmt.Errorf("%s '%s'", constants.ErrUnknownFunction, name)
+	}
+}
+
+// ratFold implements min and max over exact rational arguments.
+func ratFold(name string, args []any) *big.Rat {
+	result := args[0].(*big.Rat)
+	for _, arg := range args[1:] {
+		v := arg.(*big.Rat)
+		cmp := v.Cmp(result)
+		if (name == "min" && cmp < 0) || (name == "max" && cmp > 0) {
+			result = v
+		}
+	}
+	return result
+}
+
+// pow10 returns 10^n.
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
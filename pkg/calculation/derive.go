@@ -0,0 +1,461 @@
+package calculation
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// Derive returns the derivative of the expression rooted at node with
+// respect to variable. The bindings of a script and the calls of
+// user-defined functions are substituted into the expression first, so the
+// derivative is written in terms of the variables only. Sums with zero,
+// products with zero and one and operations on constants are simplified
+// as the derivative is built. Comparisons, logical operations and round
+// are piecewise constant, so their derivative is zero; a conditional
+// expression is differentiated branch by branch. Vectors and matrices are
+// differentiated element-wise.
+func Derive(node Node, variable string) (Node, error) {
+	if _, err := ShapeOf(node); err != nil {
+		return nil, err
+	}
+	d := &deriver{variable: variable}
+	return d.derive(inline(node, make(map[*Binding]Node)))
+}
+
+// inline returns a copy of the tree with references to bindings replaced by
+// the bound values and calls of user-defined functions by their bodies.
+// Parentheses are dropped: Format puts back those the operators need.
+func inline(node Node, values map[*Binding]Node) Node {
+	switch n := node.(type) {
+	case *Script:
+		for _, binding := range n.Bindings {
+			values[binding] = inline(binding.Value, values)
+		}
+		return inline(n.Result, values)
+	case *UserCall:
+		return inline(n.Body, values)
+	case *Ref:
+		if value, ok := values[n.Binding]; ok {
+			return value
+		}
+		return n
+	case *ParenExpr:
+		return inline(n.X, values)
+	case *UnaryExpr:
+		return &UnaryExpr{OpPos: n.OpPos, Op: n.Op, X: inline(n.X, values)}
+	case *BinaryExpr:
+		return &BinaryExpr{Left: inline(n.Left, values), OpPos: n.OpPos, Op: n.Op, Right: inline(n.Right, values)}
+	case *CondExpr:
+		cond := *n
+		cond.Cond, cond.Then, cond.Else = inline(n.Cond, values), inline(n.Then, values), inline(n.Else, values)
+		return &cond
+	case *CallExpr:
+		call := *n
+		call.Args = make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			call.Args[i] = inline(arg, values)
+		}
+		return &call
+	case *ListExpr:
+		list := *n
+		list.Elems = make([]Node, len(n.Elems))
+		for i, elem := range n.Elems {
+			list.Elems[i] = inline(elem, values)
+		}
+		return &list
+	default:
+		return node
+	}
+}
+
+// deriver differentiates trees without bindings with respect to variable.
+type deriver struct {
+	variable string
+}
+
+func (d *deriver) dependsOn(node Node) bool {
+	return slices.Contains(FreeVariables(node), d.variable)
+}
+
+func (d *deriver) derive(node Node) (Node, error) {
+	if !d.dependsOn(node) {
+		return zeros(node), nil
+	}
+
+	switch n := node.(type) {
+	case *Ident:
+		return literal(1), nil
+	case *ParenExpr:
+		return d.derive(n.X)
+	case *UnaryExpr:
+		if n.Op != "-" {
+			return zeros(node), nil
+		}
+		dx, err := d.derive(n.X)
+		if err != nil {
+			return nil, err
+		}
+		return negate(dx), nil
+	case *BinaryExpr:
+		return d.binary(n)
+	case *CallExpr:
+		return d.call(n)
+	case *CondExpr:
+		then, err := d.derive(n.Then)
+		if err != nil {
+			return nil, err
+		}
+		els, err := d.derive(n.Else)
+		if err != nil {
+			return nil, err
+		}
+		if isZero(then) && isZero(els) {
+			return then, nil
+		}
+		return &CondExpr{If: NoPos, Cond: n.Cond, Question: NoPos, Then: then, Colon: NoPos, Else: els, Rparen: NoPos}, nil
+	case *ListExpr:
+		list := &ListExpr{Lbrack: NoPos, Elems: make([]Node, len(n.Elems)), Rbrack: NoPos}
+		for i, elem := range n.Elems {
+			var err error
+			if list.Elems[i], err = d.derive(elem); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unsupported expression node %T", node)
+	}
+}
+
+// binary applies the rules for sums, products, quotients and powers.
+func (d *deriver) binary(n *BinaryExpr) (Node, error) {
+	switch n.Op {
+	case "&", "|", "xor", "<<", ">>":
+		if d.dependsOn(n) {
+			return nil, fmt.Errorf("%s bitwise operator '%s' at position %d", constants.ErrNotDifferentiable, n.Op, n.OpPos)
+		}
+		return zeros(n), nil
+	case "//":
+		// floor(a/b) is piecewise constant.
+		return zeros(n), nil
+	}
+	if binaryPrec[n.Op] < precSum {
+		return zeros(n), nil
+	}
+
+	dl, err := d.derive(n.Left)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := d.derive(n.Right)
+	if err != nil {
+		return nil, err
+	}
+	l, r := n.Left, n.Right
+
+	switch n.Op {
+	case "+":
+		return sum(dl, dr), nil
+	case "-":
+		return difference(dl, dr), nil
+	case "*":
+		return sum(product(dl, r), product(l, dr)), nil
+	case "/":
+		if !d.dependsOn(r) {
+			return quotient(dl, r), nil
+		}
+		return quotient(difference(product(dl, r), product(l, dr)), power(r, literal(2))), nil
+	case "%":
+		// a % b = a - b*trunc(a/b), and trunc is piecewise constant.
+		if d.dependsOn(r) {
+			return nil, fmt.Errorf("%s '%%' with a divisor depending on %s at position %d", constants.ErrNotDifferentiable, d.variable, n.OpPos)
+		}
+		if !shapeOf(dl).equal(shapeOf(n)) {
+			return &BinaryExpr{Left: dl, OpPos: NoPos, Op: "+", Right: zeros(n)}, nil
+		}
+		return dl, nil
+	default: // "^"
+		switch {
+		case !d.dependsOn(r):
+			return product(product(r, power(l, difference(r, literal(1)))), dl), nil
+		case !d.dependsOn(l):
+			return product(product(n, call("log", l)), dr), nil
+		default:
+			return product(n, sum(product(dr, call("log", l)), quotient(product(r, dl), l))), nil
+		}
+	}
+}
+
+// call applies the chain rule to a call of a built-in function.
+func (d *deriver) call(n *CallExpr) (Node, error) {
+	args := make([]Node, len(n.Args))
+	for i, arg := range n.Args {
+		var err error
+		if args[i], err = d.derive(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	switch n.Name {
+	case "sqrt":
+		return quotient(args[0], product(literal(2), n)), nil
+	case "sin":
+		return product(call("cos", n.Args[0]), args[0]), nil
+	case "cos":
+		return negate(product(call("sin", n.Args[0]), args[0])), nil
+	case "log":
+		return quotient(args[0], n.Args[0]), nil
+	case "exp":
+		return product(n, args[0]), nil
+	case "abs":
+		return product(quotient(n.Args[0], n), args[0]), nil
+	case "round":
+		return zeros(n), nil
+	case "sum", "mean", "transpose":
+		return call(n.Name, args...), nil
+	case "dot", "matmul":
+		u, v := n.Args[0], n.Args[1]
+		switch {
+		case !d.dependsOn(u):
+			return call(n.Name, u, args[1]), nil
+		case !d.dependsOn(v):
+			return call(n.Name, args[0], v), nil
+		default:
+			return sum(call(n.Name, args[0], v), call(n.Name, u, args[1])), nil
+		}
+	case "min", "max":
+		if len(shapeOf(n)) == 0 {
+			return d.extremum(n, args)
+		}
+	}
+	return nil, fmt.Errorf("%s function %s at position %d", constants.ErrNotDifferentiable, n.Name, n.NamePos)
+}
+
+// extremum differentiates min or max of numbers: the derivative is that
+// of the argument the function selects, min(a, b, c) selecting a when
+// a <= min(b, c).
+func (d *deriver) extremum(n *CallExpr, args []Node) (Node, error) {
+	op := "<="
+	if n.Name == "max" {
+		op = ">="
+	}
+
+	rest, drest := n.Args[1], args[1]
+	if len(n.Args) > 2 {
+		rest = call(n.Name, n.Args[1:]...)
+		var err error
+		if drest, err = d.extremum(rest.(*CallExpr), args[1:]); err != nil {
+			return nil, err
+		}
+	}
+	if isZero(args[0]) && isZero(drest) {
+		return literal(0), nil
+	}
+	cond := &BinaryExpr{Left: n.Args[0], OpPos: NoPos, Op: op, Right: rest}
+	return &CondExpr{If: NoPos, Cond: cond, Question: NoPos, Then: args[0], Colon: NoPos, Else: drest, Rparen: NoPos}, nil
+}
+
+// The constructors below build operations with the simplifications that
+// keep derivatives readable. Where an operand is dropped, the result keeps
+// the shape of the operation: x + [0, 0] is [x, x].
+
+func sum(a, b Node) Node {
+	if x, y, ok := constants2(a, b); ok {
+		return folded(x+y, a, b, "+")
+	}
+	switch {
+	case isZero(a):
+		return broadcast(b, a)
+	case isZero(b):
+		return broadcast(a, b)
+	}
+	if neg, ok := b.(*UnaryExpr); ok && neg.Op == "-" {
+		return difference(a, neg.X)
+	}
+	return &BinaryExpr{Left: a, OpPos: NoPos, Op: "+", Right: b}
+}
+
+func difference(a, b Node) Node {
+	if x, y, ok := constants2(a, b); ok {
+		return folded(x-y, a, b, "-")
+	}
+	switch {
+	case isZero(b):
+		return broadcast(a, b)
+	case isZero(a):
+		return broadcast(negate(b), a)
+	}
+	if neg, ok := b.(*UnaryExpr); ok && neg.Op == "-" {
+		return sum(a, neg.X)
+	}
+	return &BinaryExpr{Left: a, OpPos: NoPos, Op: "-", Right: b}
+}
+
+func product(a, b Node) Node {
+	if x, y, ok := constants2(a, b); ok {
+		return folded(x*y, a, b, "*")
+	}
+	switch {
+	case isZero(a):
+		return broadcast(a, b)
+	case isZero(b):
+		return broadcast(b, a)
+	case isConstant(a, 1):
+		return broadcast(b, a)
+	case isConstant(b, 1):
+		return broadcast(a, b)
+	}
+	if neg, ok := a.(*UnaryExpr); ok && neg.Op == "-" {
+		return negate(product(neg.X, b))
+	}
+	if neg, ok := b.(*UnaryExpr); ok && neg.Op == "-" {
+		return negate(product(a, neg.X))
+	}
+	// Constant factors go first: 2 * x rather than x * 2.
+	if _, ok := constant(b); ok {
+		a, b = b, a
+	}
+	return &BinaryExpr{Left: a, OpPos: NoPos, Op: "*", Right: b}
+}
+
+func quotient(a, b Node) Node {
+	if x, y, ok := constants2(a, b); ok && y != 0 {
+		return folded(x/y, a, b, "/")
+	}
+	switch {
+	case isZero(a):
+		return broadcast(a, b)
+	case isConstant(b, 1):
+		return broadcast(a, b)
+	}
+	if neg, ok := a.(*UnaryExpr); ok && neg.Op == "-" {
+		return negate(quotient(neg.X, b))
+	}
+	return &BinaryExpr{Left: a, OpPos: NoPos, Op: "/", Right: b}
+}
+
+func power(a, b Node) Node {
+	if x, y, ok := constants2(a, b); ok {
+		return folded(math.Pow(x, y), a, b, "^")
+	}
+	if isConstant(b, 1) {
+		return a
+	}
+	return &BinaryExpr{Left: a, OpPos: NoPos, Op: "^", Right: b}
+}
+
+func negate(a Node) Node {
+	if x, ok := constant(a); ok {
+		return literal(-x)
+	}
+	if isZero(a) {
+		return a
+	}
+	if neg, ok := a.(*UnaryExpr); ok && neg.Op == "-" {
+		return neg.X
+	}
+	// -2 * x rather than -(2 * x).
+	if op, ok := a.(*BinaryExpr); ok && (op.Op == "*" || op.Op == "/") {
+		if x, ok := constant(op.Left); ok {
+			return &BinaryExpr{Left: literal(-x), OpPos: NoPos, Op: op.Op, Right: op.Right}
+		}
+	}
+	return &UnaryExpr{OpPos: NoPos, Op: "-", X: a}
+}
+
+func call(name string, args ...Node) Node {
+	return &CallExpr{NamePos: NoPos, Name: name, Lparen: NoPos, Args: args, Rparen: NoPos}
+}
+
+// folded returns the number value, or the operation a op b if the value is
+// not finite, so that 1/0 stays in the expression.
+func folded(value float64, a, b Node, op string) Node {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return &BinaryExpr{Left: a, OpPos: NoPos, Op: op, Right: b}
+	}
+	return literal(value)
+}
+
+// literal returns a literal for value; negative values are negated literals.
+func literal(value float64) Node {
+	if value < 0 {
+		return &UnaryExpr{OpPos: NoPos, Op: "-", X: literal(-value)}
+	}
+	return &NumberLit{ValuePos: NoPos, Literal: strconv.FormatFloat(value, 'f', -1, 64), Value: value}
+}
+
+// constant returns the value of a number, possibly negated or in parentheses.
+func constant(node Node) (float64, bool) {
+	switch n := node.(type) {
+	case *NumberLit:
+		return n.Value, true
+	case *ParenExpr:
+		return constant(n.X)
+	case *UnaryExpr:
+		if x, ok := constant(n.X); ok && n.Op == "-" {
+			return -x, true
+		}
+	}
+	return 0, false
+}
+
+func constants2(a, b Node) (float64, float64, bool) {
+	x, okA := constant(a)
+	y, okB := constant(b)
+	return x, y, okA && okB
+}
+
+func isConstant(node Node, value float64) bool {
+	x, ok := constant(node)
+	return ok && x == value
+}
+
+// isZero reports whether node is zero or a vector or matrix of zeros.
+func isZero(node Node) bool {
+	if list, ok := node.(*ListExpr); ok {
+		for _, elem := range list.Elems {
+			if !isZero(elem) {
+				return false
+			}
+		}
+		return true
+	}
+	return isConstant(node, 0)
+}
+
+// zeros returns zero of the shape of node.
+func zeros(node Node) Node {
+	return fill(shapeOf(node), literal(0))
+}
+
+// broadcast returns a as the result of an element-wise operation on a
+// and other that equals a: a itself, or if a is a number and other a
+// vector or matrix, a repeated in the shape of other.
+func broadcast(a, other Node) Node {
+	shape := shapeOf(other)
+	if len(shape) == 0 || len(shapeOf(a)) > 0 {
+		return a
+	}
+	return fill(shape, a)
+}
+
+// fill returns a vector or matrix of the given shape with all elements x.
+func fill(shape Shape, x Node) Node {
+	if len(shape) == 0 {
+		return x
+	}
+	list := &ListExpr{Lbrack: NoPos, Elems: make([]Node, shape[0]), Rbrack: NoPos}
+	for i := range list.Elems {
+		list.Elems[i] = fill(shape[1:], x)
+	}
+	return list
+}
+
+func shapeOf(node Node) Shape {
+	shape, _ := ShapeOf(node)
+	return shape
+}
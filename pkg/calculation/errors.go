@@ -0,0 +1,92 @@
+package calculation
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// Codes of syntax errors, stable identifiers of SyntaxError.Code.
+const (
+	CodeUnexpectedCharacter = "unexpected_character"
+	CodeInvalidNumber       = "invalid_number"
+	CodeUnexpectedToken     = "unexpected_token"
+	CodeUnexpectedEnd       = "unexpected_end"
+	CodeUnclosedParen       = "unclosed_paren"
+	CodeUnclosedBracket     = "unclosed_bracket"
+	CodeMissingColon        = "missing_colon"
+	CodeMissingResult       = "missing_result"
+	CodeUnknownFunction     = "unknown_function"
+	CodeWrongArity          = "wrong_arity"
+	CodeInvalidUnit         = "invalid_unit"
+	CodeInvalidDefinition   = "invalid_definition"
+	CodeInvalidCall         = "invalid_call"
+	CodeMissingOperand      = "missing_operand"
+	CodeExtraOperand        = "extra_operand"
+	CodeAmbiguousComma      = "ambiguous_comma"
+)
+
+// operandTokens are the tokens an operand can start with.
+var operandTokens = []string{"number", "name", "'('", "'['", "'-'", "'!'", "'~'"}
+
+// SyntaxError is an error in the text of an expression. Offset and Length
+// locate the offending text, so that it can be pointed at.
+type SyntaxError struct {
+	Code     string   // Kind of the error, one of the Code constants.
+	Message  string   // Description of the error without its position.
+	Offset   Pos      // Position of the offending text.
+	Length   int      // Length of the offending text; zero at the end of the expression.
+	Expected []string // What could have come at Offset, if that is known.
+}
+
+func (e *SyntaxError) Error() string {
+	text := fmt.Sprintf("%s at position %d", e.Message, e.Offset)
+	if len(e.Expected) > 0 {
+		text += ", expected " + joinAlternatives(e.Expected)
+	}
+	return text
+}
+
+// Snippet returns source with a line of carets under the offending text,
+// or a single caret after the end for an error at the end of source:
+//
+//	2 + * 3
+//	    ^
+func (e *SyntaxError) Snippet(source string) string {
+	offset := min(max(int(e.Offset), 0), len(source))
+	var b strings.Builder
+	b.WriteString(source)
+	b.WriteByte('\n')
+	// Tabs are kept so that the caret lines up whatever the tab width.
+	for _, r := range source[:offset] {
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	end := min(offset+max(e.Length, 1), len(source))
+	b.WriteString(strings.Repeat("^", max(utf8.RuneCountInString(source[offset:end]), 1)))
+	return b.String()
+}
+
+// unexpected returns the error for a token that cannot come where it is.
+func unexpected(tok token, expected ...string) *SyntaxError {
+	return &SyntaxError{
+		Code:     CodeUnexpectedToken,
+		Message:  fmt.Sprintf("%s '%s'", constants.ErrUnexpectedToken, tok.text),
+		Offset:   tok.pos,
+		Length:   len(tok.text),
+		Expected: expected,
+	}
+}
+
+// joinAlternatives joins a, b and c as "a, b or c".
+func joinAlternatives(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " or " + items[len(items)-1]
+}
@@ -0,0 +1,204 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// Variables binds variable names to their values.
+type Variables map[string]float64
+
+// Evaluate computes the value of a syntax tree without variables.
+func Evaluate(node Node) (float64, error) {
+	return EvaluateWith(node, nil)
+}
+
+// EvaluateWith computes the value of a syntax tree, taking variable values from vars.
+// Quantities with units are taken in SI base units, so "4 km/h" evaluates to 1.11.
+func EvaluateWith(node Node, vars Variables) (float64, error) {
+	value, err := EvaluateArray(node, vars)
+	if err != nil {
+		return 0, err
+	}
+	if !value.IsScalar() {
+		return 0, fmt.Errorf("expression evaluates to an array of shape %s, not a number", value.Shape)
+	}
+	return value.Elems[0], nil
+}
+
+// EvaluateArray computes the value of a syntax tree that may be a vector or
+// a matrix, taking variable values from vars.
+func EvaluateArray(node Node, vars Variables) (Array[float64], error) {
+	return Fold[float64](node, floatAlgebra{vars: vars})
+}
+
+// floatAlgebra evaluates expressions in float64.
+type floatAlgebra struct {
+	vars Variables
+}
+
+func (a floatAlgebra) Leaf(node Node) (float64, error) {
+	switch n := node.(type) {
+	case *NumberLit:
+		return n.Value, nil
+	case *ImagLit:
+		return 0, fmt.Errorf("imaginary number %s at position %d %s", n.Literal, n.ValuePos, constants.ErrImaginaryRequiresComplex)
+	case *QuantityExpr:
+		return n.Value(), nil
+	case *Ident:
+		return a.vars.Lookup(n)
+	default:
+		return 0, fmt.Errorf("unsupported node %T", node)
+	}
+}
+
+func (floatAlgebra) Negate(x float64) (float64, error) { return -x, nil }
+
+func (floatAlgebra) Binary(op string, x, y float64) (float64, error) {
+	return ApplyBinary(op, x, y)
+}
+
+func (floatAlgebra) Call(fn *Function, args []float64) (float64, error) {
+	return fn.Call(args...)
+}
+
+func (floatAlgebra) Truth(x float64) (bool, bool) { return x != 0, true }
+
+func (floatAlgebra) Branch(_ float64, _ bool, fold func() (Array[float64], error)) (Array[float64], error) {
+	return fold()
+}
+
+func (floatAlgebra) Select(cond, x, y float64) (float64, error) { return Select(cond, x, y), nil }
+
+// Lookup returns the value bound to the variable referenced by ident.
+func (vars Variables) Lookup(ident *Ident) (float64, error) {
+	value, ok := vars[ident.Name]
+	if !ok {
+		return 0, fmt.Errorf("%s %s at position %d", constants.ErrUnboundVariable, ident.Name, ident.NamePos)
+	}
+	return value, nil
+}
+
+// ApplyBinary applies a binary operator to two operands. It is shared by the
+// local evaluator and the agents so both paths follow the same rules.
+func ApplyBinary(op string, left, right float64) (float64, error) {
+	return finite(applyBinary(op, left, right))
+}
+
+// finite rejects a result that overflowed to an infinity or is NaN, such as
+// 0 * 10^400: neither can be stored nor written as JSON.
+func finite(result float64, err error) (float64, error) {
+	if err == nil && (math.IsInf(result, 0) || math.IsNaN(result)) {
+		return 0, errors.New(constants.ErrNonFiniteResult)
+	}
+	return result, err
+}
+
+func applyBinary(op string, left, right float64) (float64, error) {
+	switch op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/":
+		if right == 0 {
+			return 0, errors.New(constants.ErrDivisionByZero)
+		}
+		return left / right, nil
+	case "%":
+		if right == 0 {
+			return 0, errors.New(constants.ErrModuloByZero)
+		}
+		// Trunc rather than a conversion to int, which is undefined for
+		// values out of its range.
+		if left != math.Trunc(left) || right != math.Trunc(right) {
+			return 0, errors.New(constants.ErrInvalidModulo)
+		}
+		return math.Mod(left, right), nil
+	case "//":
+		if right == 0 {
+			return 0, errors.New(constants.ErrDivisionByZero)
+		}
+		return math.Floor(left / right), nil
+	case "&", "|", "xor", "<<", ">>":
+		x, okX := floatInt(left)
+		y, okY := floatInt(right)
+		if !okX || !okY {
+			return 0, errors.New(constants.ErrInvalidBitwise)
+		}
+		r, err := intBinary(op, x, y)
+		return float64(r), err
+	case "^":
+		// A negative base with a fractional exponent has no real power.
+		result := math.Pow(left, right)
+		if math.IsNaN(result) && !math.IsNaN(left) && !math.IsNaN(right) {
+			return 0, errors.New(constants.ErrComplexResult)
+		}
+		return result, nil
+	case "<":
+		return truth(left < right), nil
+	case "<=":
+		return truth(left <= right), nil
+	case ">":
+		return truth(left > right), nil
+	case ">=":
+		return truth(left >= right), nil
+	case "==":
+		return truth(left == right), nil
+	case "!=":
+		return truth(left != right), nil
+	case "&&":
+		return truth(left != 0 && right != 0), nil
+	case "||":
+		return truth(left != 0 || right != 0), nil
+	default:
+		return 0, fmt.Errorf("%s '%s'", constants.ErrUnexpectedToken, op)
+	}
+}
+
+// SelectOp is the operation of the task that joins the branches of a
+// conditional. Its operands are the condition and the values of both
+// branches, of which only the taken one is computed.
+const SelectOp = "if"
+
+// Select returns a if cond is true, that is non-zero, and b otherwise.
+func Select(cond, a, b float64) float64 {
+	if cond != 0 {
+		return a
+	}
+	return b
+}
+
+// OperandCount returns the number of operands of a task performing the
+// operator or function op.
+func OperandCount(op string) int {
+	if fn, ok := LookupFunction(op); ok {
+		return fn.TaskArity()
+	}
+	if op == SelectOp {
+		return 3
+	}
+	return 2
+}
+
+// floatInt converts a float64 holding an integer that fits in int64.
+func floatInt(f float64) (int64, bool) {
+	// -2^63 is exact in float64, 2^63 is the first value out of range.
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= -math.MinInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// truth converts the outcome of a comparison or a logical operator to 1 or 0.
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
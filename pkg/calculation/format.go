@@ -0,0 +1,162 @@
+package calculation
+
+import "strings"
+
+// Precedence levels of the operators, from the loosest binding to the
+// tightest, as the parser applies them.
+const (
+	precCond = iota
+	precOr
+	precAnd
+	precEquality
+	precComparison
+	precBitOr
+	precXor
+	precBitAnd
+	precShift
+	precSum
+	precTerm
+	precPower
+	precUnary
+	precPrimary
+)
+
+// binaryPrec maps binary operators to their precedence levels.
+var binaryPrec = map[string]int{
+	"||": precOr,
+	"&&": precAnd,
+	"==": precEquality, "!=": precEquality,
+	"<": precComparison, "<=": precComparison, ">": precComparison, ">=": precComparison,
+	"|":   precBitOr,
+	"xor": precXor,
+	"&":   precBitAnd,
+	"<<":  precShift, ">>": precShift,
+	"+": precSum, "-": precSum,
+	"*": precTerm, "/": precTerm, "//": precTerm, "%": precTerm,
+	"^": precPower,
+}
+
+// Format returns the source text of the tree rooted at node, with spaces
+// around binary operators other than ^ and only the parentheses the tree
+// contains or the precedence of operators requires. Parsing the text gives
+// back an equal tree. A script is written with its bindings and a call of
+// a user-defined function as the call, not its expansion.
+func Format(node Node) string {
+	var b strings.Builder
+	format(&b, node)
+	return b.String()
+}
+
+func format(b *strings.Builder, node Node) {
+	switch n := node.(type) {
+	case *NumberLit:
+		b.WriteString(n.Literal)
+	case *ImagLit:
+		b.WriteString(n.Literal)
+	case *QuantityExpr:
+		b.WriteString(n.X.Literal + " " + n.UnitText)
+	case *Ident:
+		b.WriteString(n.Name)
+	case *Ref:
+		b.WriteString(n.Name)
+	case *ParenExpr:
+		b.WriteByte('(')
+		format(b, n.X)
+		b.WriteByte(')')
+	case *UnaryExpr:
+		b.WriteString(n.Op)
+		formatOperand(b, n.X, precUnary+1)
+	case *BinaryExpr:
+		prec := binaryPrec[n.Op]
+		left, right := prec, prec+1
+		switch n.Op {
+		case "^":
+			// ^ groups to the right, and -x^2 is (-x)^2, which is
+			// clearer written with the parentheses.
+			formatOperand(b, n.Left, precPrimary)
+			b.WriteString("^")
+			formatOperand(b, n.Right, prec)
+			return
+		case "*", "/":
+			// In 2 m * s the unit would take in s.
+			if _, ok := n.Left.(*QuantityExpr); ok {
+				left = precPrimary
+			}
+		}
+		formatOperand(b, n.Left, left)
+		b.WriteString(" " + n.Op + " ")
+		formatOperand(b, n.Right, right)
+	case *CondExpr:
+		if n.If != NoPos {
+			formatCall(b, "if", []Node{n.Cond, n.Then, n.Else})
+			return
+		}
+		formatOperand(b, n.Cond, precOr)
+		b.WriteString(" ? ")
+		format(b, n.Then)
+		b.WriteString(" : ")
+		format(b, n.Else)
+	case *CallExpr:
+		formatCall(b, n.Name, n.Args)
+	case *UserCall:
+		formatCall(b, n.Name, n.Args)
+	case *ListExpr:
+		b.WriteByte('[')
+		formatList(b, n.Elems)
+		b.WriteByte(']')
+	case *Script:
+		for _, binding := range n.Bindings {
+			b.WriteString(binding.Name + " = ")
+			format(b, binding.Value)
+			b.WriteString("; ")
+		}
+		format(b, n.Result)
+	}
+}
+
+// formatOperand writes node, in parentheses if it binds looser than prec.
+func formatOperand(b *strings.Builder, node Node, prec int) {
+	if precedence(node) >= prec {
+		format(b, node)
+		return
+	}
+	b.WriteByte('(')
+	format(b, node)
+	b.WriteByte(')')
+}
+
+func formatCall(b *strings.Builder, name string, args []Node) {
+	b.WriteString(name + "(")
+	formatList(b, args)
+	b.WriteByte(')')
+}
+
+func formatList(b *strings.Builder, nodes []Node) {
+	for i, node := range nodes {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		format(b, node)
+	}
+}
+
+// precedence returns the precedence level of the operator at the root of node.
+func precedence(node Node) int {
+	switch n := node.(type) {
+	case *BinaryExpr:
+		return binaryPrec[n.Op]
+	case *UnaryExpr:
+		return precUnary
+	case *CondExpr:
+		if n.If != NoPos {
+			return precPrimary
+		}
+		return precCond
+	case *QuantityExpr:
+		// 2 m^2 is a quantity, but 2 m ^ 2 written after a quantity would
+		// not be, so a quantity is kept apart from ^ like an operation.
+		return precPower
+	default:
+		return precPrimary
+	}
+}
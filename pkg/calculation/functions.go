@@ -0,0 +1,140 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// Variadic marks a function that accepts any number of arguments above MinArgs.
+const Variadic = -1
+
+// Function describes a built-in function available in expressions.
+//
+// Variadic functions must be associative: f(a, b, c) == f(f(a, b), c).
+// This lets the orchestrator split a long call into a chain of binary tasks.
+type Function struct {
+	Name    string                                // Name used in expressions.
+	MinArgs int                                   // Minimum number of arguments.
+	MaxArgs int                                   // Maximum number of arguments, or Variadic.
+	apply   func(args []float64) (float64, error) // Implementation; nil for functions of arrays.
+}
+
+// TakesArrays reports whether the function operates on whole vectors or
+// matrices, like dot or det, rather than on numbers. Such functions are
+// computed by Fold; other functions are applied to arrays element-wise.
+func (f *Function) TakesArrays() bool {
+	return f.apply == nil
+}
+
+// TaskArity returns how many operands one task of this function takes:
+// the argument count for fixed-arity functions and two for variadic ones.
+func (f *Function) TaskArity() int {
+	if f.MaxArgs == Variadic {
+		return 2
+	}
+	return f.MaxArgs
+}
+
+// Call checks the number of arguments and applies the function.
+func (f *Function) Call(args ...float64) (float64, error) {
+	if err := f.checkArity(len(args)); err != nil {
+		return 0, err
+	}
+	if f.TakesArrays() {
+		return 0, fmt.Errorf("function %s expects vector or matrix arguments", f.Name)
+	}
+	return finite(f.apply(args))
+}
+
+// checkArity reports whether the function accepts n arguments.
+func (f *Function) checkArity(n int) error {
+	if n < f.MinArgs || (f.MaxArgs != Variadic && n > f.MaxArgs) {
+		switch {
+		case f.MaxArgs == Variadic:
+			return fmt.Errorf("function %s expects at least %d argument(s), got %d", f.Name, f.MinArgs, n)
+		case f.MinArgs == f.MaxArgs:
+			return fmt.Errorf("function %s expects %d argument(s), got %d", f.Name, f.MinArgs, n)
+		default:
+			return fmt.Errorf("function %s expects %d to %d arguments, got %d", f.Name, f.MinArgs, f.MaxArgs, n)
+		}
+	}
+	return nil
+}
+
+// LookupFunction returns the built-in function with the given name.
+func LookupFunction(name string) (*Function, bool) {
+	fn, ok := builtins[name]
+	return fn, ok
+}
+
+// builtins is the registry of functions available in expressions.
+var builtins = map[string]*Function{
+	"sqrt": unary("sqrt", func(x float64) (float64, error) {
+		if x < 0 {
+			return 0, errors.New(constants.ErrSqrtOfNegative)
+		}
+		return math.Sqrt(x), nil
+	}),
+	"sin": unary("sin", pure(math.Sin)),
+	"cos": unary("cos", pure(math.Cos)),
+	"log": unary("log", func(x float64) (float64, error) {
+		if x <= 0 {
+			return 0, errors.New(constants.ErrLogOfNonPositive)
+		}
+		return math.Log(x), nil
+	}),
+	"exp":   unary("exp", pure(math.Exp)),
+	"abs":   unary("abs", pure(math.Abs)),
+	"round": unary("round", pure(math.Round)),
+	"min":   fold("min", math.Min),
+	"max":   fold("max", math.Max),
+
+	"dot":       {Name: "dot", MinArgs: 2, MaxArgs: 2},
+	"matmul":    {Name: "matmul", MinArgs: 2, MaxArgs: 2},
+	"transpose": {Name: "transpose", MinArgs: 1, MaxArgs: 1},
+	"det":       {Name: "det", MinArgs: 1, MaxArgs: 1},
+
+	"sum":        {Name: "sum", MinArgs: 1, MaxArgs: Variadic},
+	"mean":       {Name: "mean", MinArgs: 1, MaxArgs: Variadic},
+	"median":     {Name: "median", MinArgs: 1, MaxArgs: Variadic},
+	"stddev":     {Name: "stddev", MinArgs: 1, MaxArgs: Variadic},
+	"percentile": {Name: "percentile", MinArgs: 2, MaxArgs: Variadic},
+}
+
+// unary builds a function of exactly one argument.
+func unary(name string, f func(float64) (float64, error)) *Function {
+	return &Function{
+		Name:    name,
+		MinArgs: 1,
+		MaxArgs: 1,
+		apply: func(args []float64) (float64, error) {
+			return f(args[0])
+		},
+	}
+}
+
+// pure adapts a function that cannot fail.
+func pure(f func(float64) float64) func(float64) (float64, error) {
+	return func(x float64) (float64, error) {
+		return f(x), nil
+	}
+}
+
+// fold builds a variadic function by folding an associative binary one over its arguments.
+func fold(name string, f func(a, b float64) float64) *Function {
+	return &Function{
+		Name:    name,
+		MinArgs: 1,
+		MaxArgs: Variadic,
+		apply: func(args []float64) (float64, error) {
+			result := args[0]
+			for _, arg := range args[1:] {
+				result = f(result, arg)
+			}
+			return result, nil
+		},
+	}
+}
@@ -0,0 +1,225 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// integerSystem is arithmetic on 64-bit signed integers. An operation whose
+// result does not fit fails with an overflow error instead of wrapping
+// around, and / fails unless the division is exact; // rounds down.
+type integerSystem struct{}
+
+func (integerSystem) parse(text string) (any, error) {
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return n, nil
+	}
+	// 1e3 and 2.0 are integers too.
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, fmt.Errorf("invalid integer %q", text)
+	}
+	if !r.IsInt() {
+		return nil, fmt.Errorf("%s: %s", constants.ErrNonIntegerResult, text)
+	}
+	if !r.Num().IsInt64() {
+		return nil, fmt.Errorf("%s: %s", constants.ErrIntegerOverflow, text)
+	}
+	return r.Num().Int64(), nil
+}
+
+func (integerSystem) format(v any) string {
+	return strconv.FormatInt(v.(int64), 10)
+}
+
+func (integerSystem) float(v any) float64 {
+	return float64(v.(int64))
+}
+
+func (integerSystem) negate(v any) (any, error) {
+	return intBinary("-", 0, v.(int64))
+}
+
+func (integerSystem) binary(op string, x, y any) (any, error) {
+	return intBinary(op, x.(int64), y.(int64))
+}
+
+func (integerSystem) supports(name string) bool {
+	switch name {
+	case "abs", "round", "min", "max":
+		return true
+	}
+	return false
+}
+
+func (integerSystem) isZero(v any) bool { return v.(int64) == 0 }
+
+func (integerSystem) sign(v any) (int, error) {
+	x := v.(int64)
+	switch {
+	case x < 0:
+		return -1, nil
+	case x > 0:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// compare orders the values directly: their difference may overflow.
+func (integerSystem) compare(x, y any) int {
+	a, b := x.(int64), y.(int64)
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (integerSystem) call(name string, args []any) (any, error) {
+	x := args[0].(int64)
+
+	switch name {
+	case "abs":
+		if x < 0 {
+			return intBinary("-", 0, x)
+		}
+		return x, nil
+	case "round":
+		return x, nil
+	default: // "min", "max"
+		for _, arg := range args[1:] {
+			if y := arg.(int64); (name == "min") == (y < x) {
+				x = y
+			}
+		}
+		return x, nil
+	}
+}
+
+// intBinary applies an arithmetic or bitwise operator to integers. It is
+// used by integer mode and by float mode for the bitwise operators.
+func intBinary(op string, x, y int64) (int64, error) {
+	overflow := func() error {
+		return fmt.Errorf("%s: %d %s %d", constants.ErrIntegerOverflow, x, op, y)
+	}
+
+	switch op {
+	case "+":
+		r := x + y
+		// The sum overflows if both operands have the sign the result lacks.
+		if (x >= 0) == (y >= 0) && (r >= 0) != (x >= 0) {
+			return 0, overflow()
+		}
+		return r, nil
+	case "-":
+		r := x - y
+		if (x >= 0) != (y >= 0) && (r >= 0) != (x >= 0) {
+			return 0, overflow()
+		}
+		return r, nil
+	case "*":
+		hi, lo := bits.Mul64(uint64(abs64(x)), uint64(abs64(y)))
+		negative := (x < 0) != (y < 0)
+		limit := uint64(math.MaxInt64)
+		if negative {
+			limit++
+		}
+		if hi != 0 || lo > limit {
+			return 0, overflow()
+		}
+		if negative {
+			return -int64(lo), nil
+		}
+		return int64(lo), nil
+	case "/":
+		if y == 0 {
+			return 0, errors.New(constants.ErrDivisionByZero)
+		}
+		if x == math.MinInt64 && y == -1 {
+			return 0, overflow()
+		}
+		if x%y != 0 {
+			return 0, fmt.Errorf("%s: %d / %d", constants.ErrNonIntegerResult, x, y)
+		}
+		return x / y, nil
+	case "//":
+		if y == 0 {
+			return 0, errors.New(constants.ErrDivisionByZero)
+		}
+		if x == math.MinInt64 && y == -1 {
+			return 0, overflow()
+		}
+		q := x / y
+		if x%y != 0 && (x < 0) != (y < 0) {
+			q--
+		}
+		return q, nil
+	case "%":
+		if y == 0 {
+			return 0, errors.New(constants.ErrModuloByZero)
+		}
+		return x % y, nil
+	case "^":
+		switch {
+		case x == 1, y == 0:
+			return 1, nil
+		case x == -1:
+			return 1 - 2*(y&1), nil
+		case x == 0 && y > 0:
+			return 0, nil
+		case x == 0:
+			return 0, errors.New(constants.ErrDivisionByZero)
+		case y < 0:
+			return 0, fmt.Errorf("%s: %d ^ %d", constants.ErrNonIntegerResult, x, y)
+		}
+		// |x| >= 2, so the loop overflows after at most 63 steps.
+		r := int64(1)
+		for i := y; i > 0; i-- {
+			var err error
+			if r, err = intBinary("*", r, x); err != nil {
+				return 0, overflow()
+			}
+		}
+		return r, nil
+	case "&":
+		return x & y, nil
+	case "|":
+		return x | y, nil
+	case "xor":
+		return x ^ y, nil
+	case "<<":
+		if y < 0 {
+			return 0, errors.New(constants.ErrNegativeShift)
+		}
+		if x == 0 {
+			return 0, nil
+		}
+		if y >= 64 || x<<y>>y != x {
+			return 0, overflow()
+		}
+		return x << y, nil
+	case ">>":
+		if y < 0 {
+			return 0, errors.New(constants.ErrNegativeShift)
+		}
+		return x >> min(y, 63), nil
+	default:
+		return 0, fmt.Errorf("%s '%s'", constants.ErrUnexpectedToken, op)
+	}
+}
+
+// abs64 returns |x| as a bit pattern: |math.MinInt64| only fits in uint64.
+func abs64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,196 @@
+package calculation
+
+import "strings"
+
+// latexOps are the LaTeX symbols of binary operators written inline.
+var latexOps = map[string]string{
+	"*":   `\cdot`,
+	"%":   `\bmod`,
+	"<=":  `\le`,
+	">=":  `\ge`,
+	"==":  "=",
+	"!=":  `\ne`,
+	"&&":  `\land`,
+	"||":  `\lor`,
+	"&":   `\mathbin{\&}`,
+	"|":   `\mathbin{|}`,
+	"xor": `\oplus`,
+	"<<":  `\ll`,
+	">>":  `\gg`,
+	"//":  `\mathbin{//}`,
+}
+
+// latexFuncs are the LaTeX commands of built-in functions typeset as
+// operators; other functions are written with \operatorname.
+var latexFuncs = map[string]string{
+	"sin": `\sin`,
+	"cos": `\cos`,
+	"log": `\ln`,
+	"exp": `\exp`,
+	"min": `\min`,
+	"max": `\max`,
+	"det": `\det`,
+}
+
+// FormatLaTeX returns the tree rooted at node as a LaTeX math formula:
+// division as \frac, powers with superscripts, sqrt and abs with their
+// usual notation, vectors and matrices as bmatrix and conditionals as cases.
+func FormatLaTeX(node Node) string {
+	var b strings.Builder
+	latex(&b, node)
+	return b.String()
+}
+
+func latex(b *strings.Builder, node Node) {
+	switch n := node.(type) {
+	case *NumberLit:
+		b.WriteString(n.Literal)
+	case *ImagLit:
+		b.WriteString(strings.TrimSuffix(n.Literal, "i") + `\mathrm{i}`)
+	case *QuantityExpr:
+		b.WriteString(n.X.Literal + `\,\mathrm{` + n.UnitText + "}")
+	case *Ident:
+		b.WriteString(latexName(n.Name))
+	case *Ref:
+		b.WriteString(latexName(n.Name))
+	case *ParenExpr:
+		b.WriteString(`\left(`)
+		latex(b, n.X)
+		b.WriteString(`\right)`)
+	case *UnaryExpr:
+		switch n.Op {
+		case "!":
+			b.WriteString(`\lnot `)
+		case "~":
+			b.WriteString(`\mathord{\sim}`)
+		default:
+			b.WriteString(n.Op)
+		}
+		latexOperand(b, n.X, precUnary+1)
+	case *BinaryExpr:
+		switch n.Op {
+		case "/":
+			b.WriteString(`\frac{`)
+			latex(b, n.Left)
+			b.WriteString("}{")
+			latex(b, n.Right)
+			b.WriteString("}")
+			return
+		case "^":
+			if op, ok := n.Left.(*BinaryExpr); ok && op.Op == "/" {
+				latexParens(b, n.Left)
+			} else {
+				latexOperand(b, n.Left, precPrimary)
+			}
+			b.WriteString("^{")
+			latex(b, n.Right)
+			b.WriteString("}")
+			return
+		}
+		prec := binaryPrec[n.Op]
+		op, ok := latexOps[n.Op]
+		if !ok {
+			op = n.Op
+		}
+		latexOperand(b, n.Left, prec)
+		b.WriteString(" " + op + " ")
+		latexOperand(b, n.Right, prec+1)
+	case *CondExpr:
+		b.WriteString(`\begin{cases} `)
+		latex(b, n.Then)
+		b.WriteString(` & \text{if } `)
+		latex(b, n.Cond)
+		b.WriteString(` \\ `)
+		latex(b, n.Else)
+		b.WriteString(` & \text{otherwise} \end{cases}`)
+	case *CallExpr:
+		switch n.Name {
+		case "sqrt":
+			b.WriteString(`\sqrt{`)
+			latex(b, n.Args[0])
+			b.WriteString("}")
+		case "abs":
+			b.WriteString(`\left|`)
+			latex(b, n.Args[0])
+			b.WriteString(`\right|`)
+		default:
+			name, ok := latexFuncs[n.Name]
+			if !ok {
+				name = `\operatorname{` + n.Name + "}"
+			}
+			latexCall(b, name, n.Args)
+		}
+	case *UserCall:
+		latexCall(b, latexName(n.Name), n.Args)
+	case *ListExpr:
+		b.WriteString(`\begin{bmatrix} `)
+		for i, elem := range n.Elems {
+			if i > 0 {
+				if _, ok := elem.(*ListExpr); ok {
+					b.WriteString(` \\ `)
+				} else {
+					b.WriteString(" & ")
+				}
+			}
+			// The rows of a matrix are written in place.
+			if row, ok := elem.(*ListExpr); ok {
+				latexList(b, row.Elems, " & ")
+			} else {
+				latex(b, elem)
+			}
+		}
+		b.WriteString(` \end{bmatrix}`)
+	case *Script:
+		for _, binding := range n.Bindings {
+			b.WriteString(latexName(binding.Name) + " = ")
+			latex(b, binding.Value)
+			b.WriteString(`;\; `)
+		}
+		latex(b, n.Result)
+	}
+}
+
+// latexOperand writes node, in parentheses if it binds looser than prec.
+// A fraction binds like a primary expression: its bar groups it.
+func latexOperand(b *strings.Builder, node Node, prec int) {
+	p := precedence(node)
+	if op, ok := node.(*BinaryExpr); ok && op.Op == "/" {
+		p = precPrimary
+	}
+	if p >= prec {
+		latex(b, node)
+		return
+	}
+	latexParens(b, node)
+}
+
+func latexParens(b *strings.Builder, node Node) {
+	b.WriteString(`\left(`)
+	latex(b, node)
+	b.WriteString(`\right)`)
+}
+
+func latexCall(b *strings.Builder, name string, args []Node) {
+	b.WriteString(name + `\left(`)
+	latexList(b, args, ", ")
+	b.WriteString(`\right)`)
+}
+
+func latexList(b *strings.Builder, nodes []Node, sep string) {
+	for i, node := range nodes {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		latex(b, node)
+	}
+}
+
+// latexName writes a one-letter name in italics as usual and a longer one
+// upright, so that rate is not read as r·a·t·e.
+func latexName(name string) string {
+	name = strings.ReplaceAll(name, "_", `\_`)
+	if len(name) == 1 {
+		return name
+	}
+	return `\mathrm{` + name + "}"
+}
@@ -0,0 +1,182 @@
+package calculation
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// Locale is the way numbers are written in a language: the decimal
+// separator and the separator between groups of three digits. The zero
+// Locale is no locale: expressions are read as is and results are not
+// localized.
+type Locale struct {
+	Tag     string // BCP 47 tag: ru-RU.
+	Decimal string // Decimal separator: "," in ru-RU.
+	Group   string // Digit group separator: a no-break space in ru-RU.
+}
+
+// numberFormats lists the separators by language, and by language and
+// region where the region differs from the language.
+var numberFormats = map[string][2]string{
+	"en":    {".", ","},
+	"zh":    {".", ","},
+	"ja":    {".", ","},
+	"ko":    {".", ","},
+	"he":    {".", ","},
+	"ru":    {",", "\u00a0"},
+	"uk":    {",", "\u00a0"},
+	"be":    {",", "\u00a0"},
+	"kk":    {",", "\u00a0"},
+	"pl":    {",", "\u00a0"},
+	"cs":    {",", "\u00a0"},
+	"sk":    {",", "\u00a0"},
+	"sv":    {",", "\u00a0"},
+	"fi":    {",", "\u00a0"},
+	"nb":    {",", "\u00a0"},
+	"fr":    {",", "\u202f"},
+	"de":    {",", "."},
+	"es":    {",", "."},
+	"it":    {",", "."},
+	"pt":    {",", "."},
+	"nl":    {",", "."},
+	"da":    {",", "."},
+	"tr":    {",", "."},
+	"de-CH": {".", "\u2019"},
+	"es-MX": {".", ","},
+	"pt-BR": {",", "."},
+}
+
+// ParseLocale returns the locale with the BCP 47 tag, written in any case
+// and with - or _ between the parts (ru-RU, ru_ru, ru).
+func ParseLocale(tag string) (Locale, error) {
+	parts := strings.FieldsFunc(tag, func(r rune) bool { return r == '-' || r == '_' })
+	if len(parts) == 0 {
+		return Locale{}, fmt.Errorf("%s %q", constants.ErrUnknownLocale, tag)
+	}
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		// Two letters are a region, longer parts are scripts or variants.
+		if len(parts[i]) == 2 {
+			parts[i] = strings.ToUpper(parts[i])
+		}
+	}
+	canonical := strings.Join(parts, "-")
+
+	format, ok := numberFormats[canonical]
+	if !ok && len(parts) > 1 {
+		format, ok = numberFormats[parts[0]+"-"+parts[len(parts)-1]]
+	}
+	if !ok {
+		format, ok = numberFormats[parts[0]]
+	}
+	if !ok {
+		return Locale{}, fmt.Errorf("%s %q", constants.ErrUnknownLocale, tag)
+	}
+	return Locale{Tag: canonical, Decimal: format[0], Group: format[1]}, nil
+}
+
+// Delocalize rewrites an expression written with the decimal comma of the
+// locale into the form the parser reads. There a comma is only the decimal
+// separator and stands between the digits of a number, 3,5; arguments of
+// functions and elements of vectors are separated with semicolons instead,
+// max(1,5; 2) and [1; 2,5]. A semicolon outside brackets still separates
+// the definitions of a script. Any other comma, as in max(1,2,3), is
+// ambiguous and is an error. Every character keeps its offset, so
+// positions in errors point into the original text.
+func (l Locale) Delocalize(expression string) (string, error) {
+	if l.Decimal != "," {
+		return expression, nil
+	}
+
+	b := []byte(expression)
+	depth := 0 // Nesting of parentheses and brackets.
+	for i := 0; i < len(b); {
+		c := rune(b[i])
+		switch {
+		case isIdentStart(c):
+			// Digits inside names are not numbers: x1,5 is not 1,5.
+			for i < len(b) && (isIdentStart(rune(b[i])) || isDigit(rune(b[i]))) {
+				i++
+			}
+		case isDigit(c):
+			j := scanNumber(string(b), i)
+			if _, prefixed := numberBase(string(b[i:])); !prefixed && isIntegerPart(b[i:j]) &&
+				j+1 < len(b) && b[j] == ',' && isDigit(rune(b[j+1])) {
+				b[j] = '.'
+				j = scanNumber(string(b), i)
+			}
+			i = j
+		case c == ',':
+			return "", &SyntaxError{
+				Code:    CodeAmbiguousComma,
+				Message: fmt.Sprintf("%s %s, separate arguments with ';'", constants.ErrAmbiguousComma, l.Tag),
+				Offset:  Pos(i),
+				Length:  1,
+			}
+		case c == ';' && depth > 0:
+			b[i] = ','
+			i++
+		default:
+			switch c {
+			case '(', '[':
+				depth++
+			case ')', ']':
+				depth = max(depth-1, 0)
+			}
+			i++
+		}
+	}
+	return string(b), nil
+}
+
+// isIntegerPart checks that a literal has neither a fraction nor an
+// exponent yet, so that a comma after it can start the fraction.
+func isIntegerPart(literal []byte) bool {
+	for _, c := range literal {
+		if !isDigit(rune(c)) && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatNumber writes x in positional notation with the separators of the
+// locale: 1234567.5 is 1 234 567,5 in ru-RU, with no-break spaces, and
+// 1,234,567.5 in en-US.
+func (l Locale) FormatNumber(x float64) string {
+	s := strconv.FormatFloat(x, 'f', -1, 64)
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return s
+	}
+	return l.FormatDecimal(s)
+}
+
+// FormatDecimal writes s, a number in positional notation with a point such
+// as an exact result, with the separators of the locale.
+func (l Locale) FormatDecimal(s string) string {
+	if l.Tag == "" {
+		return s
+	}
+
+	sign := ""
+	if s[0] == '-' {
+		sign, s = "-", s[1:]
+	}
+	integer, fraction, hasFraction := strings.Cut(s, ".")
+
+	var grouped strings.Builder
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			grouped.WriteString(l.Group)
+		}
+		grouped.WriteRune(digit)
+	}
+	if hasFraction {
+		grouped.WriteString(l.Decimal + fraction)
+	}
+	return sign + grouped.String()
+}
@@ -0,0 +1,185 @@
+package calculation
+
+import (
+	"html"
+	"strings"
+)
+
+// mathmlOps are the MathML operator characters of binary operators
+// written inline.
+var mathmlOps = map[string]string{
+	"-":   "&#x2212;",
+	"*":   "&#x22C5;",
+	"%":   "mod",
+	"<":   "&lt;",
+	">":   "&gt;",
+	"<=":  "&#x2264;",
+	">=":  "&#x2265;",
+	"==":  "=",
+	"!=":  "&#x2260;",
+	"&&":  "&#x2227;",
+	"||":  "&#x2228;",
+	"&":   "&amp;",
+	"xor": "&#x2295;",
+	"<<":  "&#x226A;",
+	">>":  "&#x226B;",
+}
+
+// FormatMathML returns the tree rooted at node as a MathML math element
+// with the same layout as FormatLaTeX.
+func FormatMathML(node Node) string {
+	var b strings.Builder
+	b.WriteString(`<math xmlns="http://www.w3.org/1998/Math/MathML">`)
+	mathml(&b, node)
+	b.WriteString("</math>")
+	return b.String()
+}
+
+func mathml(b *strings.Builder, node Node) {
+	switch n := node.(type) {
+	case *NumberLit:
+		b.WriteString("<mn>" + n.Literal + "</mn>")
+	case *ImagLit:
+		b.WriteString("<mrow><mn>" + strings.TrimSuffix(n.Literal, "i") + `</mn><mi mathvariant="normal">i</mi></mrow>`)
+	case *QuantityExpr:
+		b.WriteString("<mrow><mn>" + n.X.Literal + `</mn><mspace width="0.167em"/><mi mathvariant="normal">` + html.EscapeString(n.UnitText) + "</mi></mrow>")
+	case *Ident:
+		b.WriteString("<mi>" + n.Name + "</mi>")
+	case *Ref:
+		b.WriteString("<mi>" + n.Name + "</mi>")
+	case *ParenExpr:
+		mathmlParens(b, n.X)
+	case *UnaryExpr:
+		op := "&#x2212;"
+		switch n.Op {
+		case "!":
+			op = "&#x00AC;"
+		case "~":
+			op = "~"
+		}
+		b.WriteString("<mrow><mo>" + op + "</mo>")
+		mathmlOperand(b, n.X, precUnary+1)
+		b.WriteString("</mrow>")
+	case *BinaryExpr:
+		switch n.Op {
+		case "/":
+			b.WriteString("<mfrac>")
+			mathml(b, n.Left)
+			mathml(b, n.Right)
+			b.WriteString("</mfrac>")
+			return
+		case "^":
+			b.WriteString("<msup>")
+			if op, ok := n.Left.(*BinaryExpr); ok && op.Op == "/" {
+				mathmlParens(b, n.Left)
+			} else {
+				mathmlOperand(b, n.Left, precPrimary)
+			}
+			mathml(b, n.Right)
+			b.WriteString("</msup>")
+			return
+		}
+		prec := binaryPrec[n.Op]
+		op, ok := mathmlOps[n.Op]
+		if !ok {
+			op = n.Op
+		}
+		b.WriteString("<mrow>")
+		mathmlOperand(b, n.Left, prec)
+		b.WriteString("<mo>" + op + "</mo>")
+		mathmlOperand(b, n.Right, prec+1)
+		b.WriteString("</mrow>")
+	case *CondExpr:
+		b.WriteString(`<mrow><mo>{</mo><mtable columnalign="left"><mtr><mtd>`)
+		mathml(b, n.Then)
+		b.WriteString(`</mtd><mtd><mtext>if&#xA0;</mtext>`)
+		mathml(b, n.Cond)
+		b.WriteString("</mtd></mtr><mtr><mtd>")
+		mathml(b, n.Else)
+		b.WriteString("</mtd><mtd><mtext>otherwise</mtext></mtd></mtr></mtable></mrow>")
+	case *CallExpr:
+		switch n.Name {
+		case "sqrt":
+			b.WriteString("<msqrt>")
+			mathml(b, n.Args[0])
+			b.WriteString("</msqrt>")
+		case "abs":
+			b.WriteString("<mrow><mo>|</mo>")
+			mathml(b, n.Args[0])
+			b.WriteString("<mo>|</mo></mrow>")
+		case "log":
+			mathmlCall(b, "ln", n.Args)
+		default:
+			mathmlCall(b, n.Name, n.Args)
+		}
+	case *UserCall:
+		mathmlCall(b, n.Name, n.Args)
+	case *ListExpr:
+		b.WriteString("<mrow><mo>[</mo><mtable>")
+		if len(n.Elems) > 0 && isList(n.Elems[0]) {
+			for _, row := range n.Elems {
+				mathmlRow(b, row.(*ListExpr).Elems)
+			}
+		} else {
+			mathmlRow(b, n.Elems)
+		}
+		b.WriteString("</mtable><mo>]</mo></mrow>")
+	case *Script:
+		b.WriteString("<mrow>")
+		for _, binding := range n.Bindings {
+			b.WriteString("<mi>" + binding.Name + "</mi><mo>=</mo>")
+			mathml(b, binding.Value)
+			b.WriteString(`<mo separator="true">;</mo>`)
+		}
+		mathml(b, n.Result)
+		b.WriteString("</mrow>")
+	}
+}
+
+// mathmlOperand writes node, in parentheses if it binds looser than prec.
+// A fraction binds like a primary expression: its bar groups it.
+func mathmlOperand(b *strings.Builder, node Node, prec int) {
+	p := precedence(node)
+	if op, ok := node.(*BinaryExpr); ok && op.Op == "/" {
+		p = precPrimary
+	}
+	if p >= prec {
+		mathml(b, node)
+		return
+	}
+	mathmlParens(b, node)
+}
+
+func mathmlParens(b *strings.Builder, node Node) {
+	b.WriteString("<mrow><mo>(</mo>")
+	mathml(b, node)
+	b.WriteString("<mo>)</mo></mrow>")
+}
+
+// mathmlCall writes a function application; &#x2061; is the invisible
+// function application operator.
+func mathmlCall(b *strings.Builder, name string, args []Node) {
+	b.WriteString("<mrow><mi>" + name + "</mi><mo>&#x2061;</mo><mrow><mo>(</mo>")
+	for i, arg := range args {
+		if i > 0 {
+			b.WriteString(`<mo separator="true">,</mo>`)
+		}
+		mathml(b, arg)
+	}
+	b.WriteString("<mo>)</mo></mrow></mrow>")
+}
+
+func mathmlRow(b *strings.Builder, elems []Node) {
+	b.WriteString("<mtr>")
+	for _, elem := range elems {
+		b.WriteString("<mtd>")
+		mathml(b, elem)
+		b.WriteString("</mtd>")
+	}
+	b.WriteString("</mtr>")
+}
+
+func isList(node Node) bool {
+	_, ok := node.(*ListExpr)
+	return ok
+}
@@ -0,0 +1,127 @@
+package calculation
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// Notation selects how a result is written out.
+type Notation string
+
+const (
+	NotationDecimal     Notation = "decimal"     // Positional notation: 1234.5.
+	NotationHex         Notation = "hex"         // Base 16: 0xff; fractions as hexadecimal floats, 0x1.8p+01.
+	NotationScientific  Notation = "scientific"  // One digit before the point: 1.2345e+03.
+	NotationEngineering Notation = "engineering" // Exponent a multiple of three: 1.2345e+03, 12.5e-06.
+)
+
+// Validate checks that n is one of the notations. The empty notation is
+// valid and means that no formatted result is needed.
+func (n Notation) Validate() error {
+	switch n {
+	case "", NotationDecimal, NotationHex, NotationScientific, NotationEngineering:
+		return nil
+	}
+	return fmt.Errorf("%s %q", constants.ErrUnknownOutputFormat, n)
+}
+
+// FormatNumber writes x in notation n with the fewest digits that still
+// read back as x.
+func FormatNumber(x float64, n Notation) (string, error) {
+	if err := n.Validate(); err != nil {
+		return "", err
+	}
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return strconv.FormatFloat(x, 'g', -1, 64), nil
+	}
+
+	switch n {
+	case NotationHex:
+		if x != math.Trunc(x) {
+			return strconv.FormatFloat(x, 'x', -1, 64), nil
+		}
+		i, _ := big.NewFloat(x).Int(nil)
+		return hexInteger(i), nil
+	case NotationScientific:
+		return strconv.FormatFloat(x, 'e', -1, 64), nil
+	case NotationEngineering:
+		if x == 0 {
+			return "0e+00", nil
+		}
+		return engineering(strconv.FormatFloat(x, 'e', -1, 64)), nil
+	}
+	return strconv.FormatFloat(x, 'f', -1, 64), nil
+}
+
+// FormatInteger writes the integer x in notation n with all of its digits,
+// such as the result of integer mode, which a float64 holds exactly only up
+// to 2^53.
+func FormatInteger(x *big.Int, n Notation) (string, error) {
+	if err := n.Validate(); err != nil {
+		return "", err
+	}
+
+	switch n {
+	case NotationHex:
+		return hexInteger(x), nil
+	case NotationScientific:
+		return scientific(x), nil
+	case NotationEngineering:
+		return engineering(scientific(x)), nil
+	}
+	return x.String(), nil
+}
+
+// hexInteger writes x in base 16 with the 0x prefix after the sign.
+func hexInteger(x *big.Int) string {
+	if x.Sign() < 0 {
+		return "-0x" + x.Text(16)[1:]
+	}
+	return "0x" + x.Text(16)
+}
+
+// scientific writes x with one digit before the point and without trailing
+// zeros, as strconv.FormatFloat does with 'e': 1.2345e+03.
+func scientific(x *big.Int) string {
+	digits := new(big.Int).Abs(x).String()
+	sign := ""
+	if x.Sign() < 0 {
+		sign = "-"
+	}
+	mantissa := digits[:1]
+	if rest := strings.TrimRight(digits[1:], "0"); rest != "" {
+		mantissa += "." + rest
+	}
+	return fmt.Sprintf("%s%se%+03d", sign, mantissa, len(digits)-1)
+}
+
+// engineering rewrites s, a number in scientific notation, with the
+// exponent rounded down to a multiple of three, so that it matches an SI
+// prefix.
+func engineering(s string) string {
+	sign := ""
+	if s[0] == '-' {
+		sign, s = "-", s[1:]
+	}
+	mantissa, exp, _ := strings.Cut(s, "e")
+	e, _ := strconv.Atoi(exp)
+	digits := strings.Replace(mantissa, ".", "", 1)
+
+	shift := e % 3
+	if shift < 0 {
+		shift += 3
+	}
+	if len(digits) <= shift {
+		digits += strings.Repeat("0", shift+1-len(digits))
+	}
+	mantissa = digits[:shift+1]
+	if rest := digits[shift+1:]; rest != "" {
+		mantissa += "." + rest
+	}
+	return fmt.Sprintf("%s%se%+03d", sign, mantissa, e-shift)
+}
@@ -3,188 +3,595 @@ package calculation
 import (
 	"errors"
 	"fmt"
-	"math"
-	"strconv"
+	"slices"
+	"strings"
 
 	"github.com/structxz/calc_v3/internal/constants"
 	"go.uber.org/zap"
 )
 
-// Parser represents a mathematical expression parser.
+// Parser builds a syntax tree from the tokens of an expression.
 type Parser struct {
-	tokens []string // Tokens of the expression to be parsed.
-	pos    int      // Current position in the tokens slice.
+	tokens   []token             // Tokens of the expression to be parsed.
+	pos      int                 // Current position in the tokens slice.
+	end      Pos                 // Position just after the last character of the expression.
+	bindings map[string]*Binding // Bindings of the script parsed so far.
+	funcs    Definitions         // User-defined functions that can be called.
+	calling  []string            // User-defined functions being expanded, outermost first.
+	calls    *int                // Number of calls of user-defined functions expanded so far.
 }
 
-// parse evaluates the entire expression and returns the result.
+// Parse parses an expression into a syntax tree.
+func Parse(expression string) (Node, error) {
+	return ParseWith(expression, nil)
+}
+
+// ParseWith parses an expression that may call the user-defined functions funcs.
+func ParseWith(expression string, funcs Definitions) (Node, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, errors.New("expression is empty")
+	}
+
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	if logger != nil {
+		logger.Debug("Tokens generated", zap.Strings(constants.FieldTokens, tokenTexts(tokens)))
+	}
+
+	parser := &Parser{tokens: tokens, end: Pos(len(expression)), funcs: funcs, calls: new(int)}
+	return parser.parse()
+}
+
+// parse parses the entire expression, which may be a script of bindings
+// followed by the result, such as "x = 3*4; x + 1". A final ";" is allowed.
 // It ensures that all tokens are consumed and returns an error if unexpected tokens remain.
-func (p *Parser) parse() (float64, error) {
-	result, err := p.parseExpression()
+func (p *Parser) parse() (Node, error) {
+	var script Script
+	for p.atBinding() {
+		binding, err := p.parseBinding()
+		if err != nil {
+			return nil, err
+		}
+		script.Bindings = append(script.Bindings, binding)
+	}
+	if len(script.Bindings) > 0 && p.pos >= len(p.tokens) {
+		return nil, &SyntaxError{Code: CodeMissingResult, Message: constants.ErrMissingResult, Offset: p.end}
+	}
+
+	node, err := p.parseExpression()
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenSemicolon {
+		p.pos++
 	}
 	if p.pos < len(p.tokens) {
-		return 0, errors.New(constants.ErrUnexpectedToken)
+		return nil, unexpected(p.tokens[p.pos])
+	}
+
+	if len(script.Bindings) == 0 {
+		return node, nil
+	}
+	script.Result = node
+	return &script, nil
+}
+
+// atBinding reports whether a statement name = value starts at the current token.
+func (p *Parser) atBinding() bool {
+	return p.pos+1 < len(p.tokens) && p.tokens[p.pos].kind == tokenIdent && p.tokens[p.pos+1].kind == tokenAssign
+}
+
+// parseBinding parses a statement name = value; of a script. The name can be
+// used from the next statement on, where it hides a variable of the same name.
+func (p *Parser) parseBinding() (*Binding, error) {
+	name := p.tokens[p.pos]
+	p.pos += 2
+
+	value, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.tokens) {
+		return nil, &SyntaxError{Code: CodeMissingResult, Message: constants.ErrMissingResult, Offset: p.end}
+	}
+	semi := p.tokens[p.pos]
+	if semi.kind != tokenSemicolon {
+		return nil, unexpected(semi, "';'")
+	}
+	p.pos++
+
+	binding := &Binding{NamePos: name.pos, Name: name.text, Value: value, Semi: semi.pos}
+	if p.bindings == nil {
+		p.bindings = make(map[string]*Binding)
 	}
-	return result, nil
+	p.bindings[name.text] = binding
+	return binding, nil
 }
 
-// parseExpression parses addition and subtraction operations.
-func (p *Parser) parseExpression() (float64, error) {
+// parseExpression parses a conditional expression cond ? a : b. The
+// conditional operator has the lowest precedence and groups to the right,
+// so a ? b : c ? d : e is a ? b : (c ? d : e).
+func (p *Parser) parseExpression() (Node, error) {
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenQuestion {
+		return cond, nil
+	}
+	question := p.tokens[p.pos]
+	p.pos++
+
+	then, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenColon {
+		return nil, &SyntaxError{Code: CodeMissingColon, Message: constants.ErrMissingColon + " for '?'", Offset: question.pos, Length: 1}
+	}
+	colon := p.tokens[p.pos]
+	p.pos++
+
+	els, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	return &CondExpr{If: NoPos, Cond: cond, Question: question.pos, Then: then, Colon: colon.pos, Else: els, Rparen: NoPos}, nil
+}
+
+// parseOr parses logical or operations.
+func (p *Parser) parseOr() (Node, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+// parseAnd parses logical and operations.
+func (p *Parser) parseAnd() (Node, error) {
+	return p.parseBinary(p.parseEquality, "&&")
+}
+
+// parseEquality parses equality tests.
+func (p *Parser) parseEquality() (Node, error) {
+	return p.parseBinary(p.parseComparison, "==", "!=")
+}
+
+// parseComparison parses ordering comparisons.
+func (p *Parser) parseComparison() (Node, error) {
+	return p.parseBinary(p.parseBitOr, "<", "<=", ">", ">=")
+}
+
+// parseBitOr parses bitwise or operations. The bitwise operators bind
+// tighter than comparisons, so x & 1 == 0 is (x & 1) == 0.
+func (p *Parser) parseBitOr() (Node, error) {
+	return p.parseBinary(p.parseXor, "|")
+}
+
+// parseXor parses bitwise exclusive or operations.
+func (p *Parser) parseXor() (Node, error) {
+	return p.parseBinary(p.parseBitAnd, "xor")
+}
+
+// parseBitAnd parses bitwise and operations.
+func (p *Parser) parseBitAnd() (Node, error) {
+	return p.parseBinary(p.parseShift, "&")
+}
+
+// parseShift parses bit shifts.
+func (p *Parser) parseShift() (Node, error) {
+	return p.parseBinary(p.parseSum, "<<", ">>")
+}
+
+// parseBinary parses a left-associative chain of the operators ops between
+// operands parsed by operand.
+func (p *Parser) parseBinary(operand func() (Node, error), ops ...string) (Node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.pos < len(p.tokens) {
+		op := p.tokens[p.pos]
+		if op.kind != tokenOperator || !slices.Contains(ops, op.text) {
+			break
+		}
+		p.pos++
+
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &BinaryExpr{Left: left, OpPos: op.pos, Op: op.text, Right: right}
+	}
+
+	return left, nil
+}
+
+// parseSum parses addition and subtraction operations.
+func (p *Parser) parseSum() (Node, error) {
 	left, err := p.parseTerm()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	for p.pos < len(p.tokens) {
 		op := p.tokens[p.pos]
-		if op != "+" && op != "-" {
+		if op.text != "+" && op.text != "-" {
 			break
 		}
 		p.pos++
 
 		right, err := p.parseTerm()
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 
-		if op == "+" {
-			left += right
-		} else {
-			left -= right
-		}
+		left = &BinaryExpr{Left: left, OpPos: op.pos, Op: op.text, Right: right}
 	}
 
 	return left, nil
 }
 
-// parseTerm parses multiplication, division, and modulo operations.
-func (p *Parser) parseTerm() (float64, error) {
+// parseTerm parses multiplication, division, integer division and modulo operations.
+func (p *Parser) parseTerm() (Node, error) {
 	left, err := p.parsePower()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	for p.pos < len(p.tokens) {
 		op := p.tokens[p.pos]
-		if op != "*" && op != "/" && op != "%" {
+		if op.text != "*" && op.text != "/" && op.text != "//" && op.text != "%" {
 			break
 		}
 		p.pos++
 
 		right, err := p.parsePower()
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 
-		switch op {
-		case "*":
-			left *= right
-		case "/":
-			if right == 0 {
-				return 0, errors.New(constants.ErrDivisionByZero)
-			}
-			left /= right
-		case "%":
-			if right == 0 {
-				return 0, errors.New(constants.ErrModuloByZero)
-			}
-			if left != float64(int(left)) || right != float64(int(right)) {
-				return 0, errors.New(constants.ErrInvalidModulo)
-			}
-			left = math.Mod(left, right)
-		}
+		left = &BinaryExpr{Left: left, OpPos: op.pos, Op: op.text, Right: right}
 	}
 
 	return left, nil
 }
 
-// parsePower parses exponentiation operations.
-func (p *Parser) parsePower() (float64, error) {
-	result, err := p.parseFactor()
+// parsePower parses right-associative exponentiation operations.
+func (p *Parser) parsePower() (Node, error) {
+	base, err := p.parseFactor()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	if p.pos < len(p.tokens) && p.tokens[p.pos] == "^" {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].text == "^" {
+		op := p.tokens[p.pos]
 		p.pos++
 
 		exponent, err := p.parsePower()
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		result = math.Pow(result, exponent)
+		return &BinaryExpr{Left: base, OpPos: op.pos, Op: op.text, Right: exponent}, nil
 	}
 
-	return result, nil
+	return base, nil
 }
 
-// parseFactor parses individual factors, including numbers, parentheses, and negative signs.
-func (p *Parser) parseFactor() (float64, error) {
+// parseFactor parses individual factors, including numbers, variables, function calls, parentheses, and negative signs.
+func (p *Parser) parseFactor() (Node, error) {
 	if p.pos >= len(p.tokens) {
 		if logger != nil {
 			logger.Error(constants.LogUnexpectedEndExpr,
-				zap.Strings(constants.FieldTokens, p.tokens),
+				zap.Strings(constants.FieldTokens, tokenTexts(p.tokens)),
 				zap.Int(constants.FieldPosition, p.pos))
 		}
-		return 0, errors.New(constants.ErrUnexpectedEndExpr)
+		return nil, &SyntaxError{Code: CodeUnexpectedEnd, Message: constants.ErrUnexpectedEndExpr, Offset: p.end, Expected: operandTokens}
 	}
 
-	token := p.tokens[p.pos]
+	tok := p.tokens[p.pos]
 	p.pos++
 
 	switch {
-	case token == "(":
-		result, err := p.parseExpression()
+	case tok.kind == tokenLParen:
+		inner, err := p.parseExpression()
 		if err != nil {
 			if logger != nil {
 				logger.Error(constants.LogFailedParseParentheses,
 					zap.Error(err),
-					zap.Strings(constants.FieldTokens, p.tokens),
+					zap.Strings(constants.FieldTokens, tokenTexts(p.tokens)),
 					zap.Int(constants.FieldPosition, p.pos))
 			}
-			return 0, err
+			return nil, err
 		}
-		if p.pos >= len(p.tokens) || p.tokens[p.pos] != ")" {
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenRParen {
 			if logger != nil {
 				logger.Error(constants.LogMissingCloseParen,
-					zap.Strings(constants.FieldTokens, p.tokens),
+					zap.Strings(constants.FieldTokens, tokenTexts(p.tokens)),
 					zap.Int(constants.FieldPosition, p.pos))
 			}
-			return 0, errors.New(constants.ErrMissingCloseParen)
+			return nil, unclosedParen(tok.pos)
 		}
+		rparen := p.tokens[p.pos]
 		p.pos++
-		return result, nil
-	case token == "-":
-		factor, err := p.parseFactor()
+		return &ParenExpr{Lparen: tok.pos, X: inner, Rparen: rparen.pos}, nil
+	case tok.kind == tokenLBrack:
+		return p.parseList(tok)
+	case tok.text == "-":
+		operand, err := p.parseFactor()
 		if err != nil {
 			if logger != nil {
 				logger.Error(constants.LogFailedParseNegative,
 					zap.Error(err),
-					zap.Strings(constants.FieldTokens, p.tokens),
+					zap.Strings(constants.FieldTokens, tokenTexts(p.tokens)),
 					zap.Int(constants.FieldPosition, p.pos))
 			}
-			return 0, err
+			return nil, err
 		}
-		return -factor, nil
-	case isNumber(token):
-		num, err := strconv.ParseFloat(token, 64)
+		return &UnaryExpr{OpPos: tok.pos, Op: tok.text, X: operand}, nil
+	case tok.text == "!" || tok.text == "~":
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{OpPos: tok.pos, Op: tok.text, X: operand}, nil
+	case tok.kind == tokenIdent:
+		if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenLParen {
+			if tok.text == "if" {
+				return p.parseIf(tok)
+			}
+			return p.parseCall(tok)
+		}
+		if binding, ok := p.bindings[tok.text]; ok {
+			return &Ref{NamePos: tok.pos, Name: tok.text, Binding: binding}, nil
+		}
+		return &Ident{NamePos: tok.pos, Name: tok.text}, nil
+	case tok.kind == tokenNumber:
+		num, _, err := parseNumber(tok.text)
 		if err != nil {
 			if logger != nil {
 				logger.Error(constants.LogInvalidNumberFormat,
-					zap.String(constants.FieldToken, token),
+					zap.String(constants.FieldToken, tok.text),
 					zap.Error(err))
 			}
-			return 0, fmt.Errorf("invalid number: %s", token)
+			return nil, &SyntaxError{Code: CodeInvalidNumber, Message: fmt.Sprintf("invalid number %q", tok.text), Offset: tok.pos, Length: len(tok.text)}
+		}
+		lit := &NumberLit{ValuePos: tok.pos, Literal: tok.text, Value: num}
+		if p.atUnit(p.pos) {
+			return p.parseQuantity(lit)
 		}
-		return num, nil
+		return lit, nil
+	case tok.kind == tokenImag:
+		num, _, err := parseNumber(strings.TrimSuffix(tok.text, "i"))
+		if err != nil {
+			return nil, &SyntaxError{Code: CodeInvalidNumber, Message: fmt.Sprintf("invalid number %q", tok.text), Offset: tok.pos, Length: len(tok.text)}
+		}
+		return &ImagLit{ValuePos: tok.pos, Literal: tok.text, Value: num}, nil
 	default:
 		if logger != nil {
 			logger.Error(constants.LogUnexpectedToken,
-				zap.String(constants.FieldToken, token),
-				zap.Strings(constants.FieldTokens, p.tokens),
+				zap.String(constants.FieldToken, tok.text),
+				zap.Strings(constants.FieldTokens, tokenTexts(p.tokens)),
 				zap.Int(constants.FieldPosition, p.pos))
 		}
-		return 0, fmt.Errorf("unexpected token: %s", token)
+		return nil, unexpected(tok, operandTokens...)
+	}
+}
+
+// parseCall parses the argument list of a call of the function named by
+// name, starting at "(", and checks the function exists and accepts that many arguments.
+func (p *Parser) parseCall(name token) (Node, error) {
+	fn, ok := LookupFunction(name.text)
+	if !ok {
+		if def, ok := p.funcs[name.text]; ok {
+			return p.parseUserCall(name, def)
+		}
+		return nil, &SyntaxError{
+			Code:    CodeUnknownFunction,
+			Message: fmt.Sprintf("%s '%s'", constants.ErrUnknownFunction, name.text),
+			Offset:  name.pos,
+			Length:  len(name.text),
+		}
+	}
+	call := &CallExpr{NamePos: name.pos, Name: name.text, Lparen: p.tokens[p.pos].pos}
+
+	var err error
+	if call.Args, call.Rparen, err = p.parseArgs(); err != nil {
+		return nil, err
+	}
+	if err := fn.checkArity(len(call.Args)); err != nil {
+		return nil, &SyntaxError{Code: CodeWrongArity, Message: err.Error(), Offset: name.pos, Length: len(name.text)}
+	}
+	return call, nil
+}
+
+// parseArgs parses a parenthesised argument list starting at "(" and
+// returns the arguments and the position of ")".
+func (p *Parser) parseArgs() ([]Node, Pos, error) {
+	lparen := p.tokens[p.pos].pos
+	p.pos++
+
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenRParen {
+		p.pos++
+		return nil, p.tokens[p.pos-1].pos, nil
+	}
+
+	var args []Node
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, NoPos, err
+		}
+		args = append(args, arg)
+
+		if p.pos >= len(p.tokens) {
+			return nil, NoPos, unclosedParen(lparen)
+		}
+		next := p.tokens[p.pos]
+		p.pos++
+		if next.kind == tokenRParen {
+			return args, next.pos, nil
+		}
+		if next.kind != tokenComma {
+			return nil, NoPos, unexpected(next, "','", "')'")
+		}
+	}
+}
+
+// parseIf parses the function form of a conditional, if(cond, a, b),
+// starting at "(".
+func (p *Parser) parseIf(name token) (Node, error) {
+	cond := &CondExpr{If: name.pos, Question: p.tokens[p.pos].pos}
+	p.pos++
+
+	var args []Node
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.pos >= len(p.tokens) {
+			return nil, unclosedParen(cond.Question)
+		}
+		next := p.tokens[p.pos]
+		p.pos++
+		if next.kind == tokenRParen {
+			cond.Rparen = next.pos
+			break
+		}
+		if next.kind != tokenComma {
+			return nil, unexpected(next, "','", "')'")
+		}
+		if len(args) == 2 {
+			cond.Colon = next.pos
+		}
+	}
+
+	if len(args) != 3 {
+		return nil, &SyntaxError{
+			Code:    CodeWrongArity,
+			Message: fmt.Sprintf("function if expects 3 argument(s), got %d", len(args)),
+			Offset:  name.pos,
+			Length:  len(name.text),
+		}
+	}
+	cond.Cond, cond.Then, cond.Else = args[0], args[1], args[2]
+	return cond, nil
+}
+
+// parseList parses the elements of a vector or matrix literal after "[".
+func (p *Parser) parseList(lbrack token) (Node, error) {
+	list := &ListExpr{Lbrack: lbrack.pos}
+
+	for {
+		elem, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		list.Elems = append(list.Elems, elem)
+
+		if p.pos >= len(p.tokens) {
+			return nil, &SyntaxError{Code: CodeUnclosedBracket, Message: constants.ErrMissingCloseBracket + " for '['", Offset: lbrack.pos, Length: 1}
+		}
+		next := p.tokens[p.pos]
+		p.pos++
+		if next.kind == tokenRBrack {
+			list.Rbrack = next.pos
+			return list, nil
+		}
+		if next.kind != tokenComma {
+			return nil, unexpected(next, "','", "']'")
+		}
 	}
 }
+
+// atUnit reports whether the token at index i is a unit symbol rather than a
+// function call. Unit symbols only have this meaning right after a number.
+func (p *Parser) atUnit(i int) bool {
+	if i >= len(p.tokens) || p.tokens[i].kind != tokenIdent {
+		return false
+	}
+	if i+1 < len(p.tokens) && p.tokens[i+1].kind == tokenLParen {
+		return false
+	}
+	_, ok := LookupUnit(p.tokens[i].text)
+	return ok
+}
+
+// parseQuantity parses the unit that follows the number x. A unit is a
+// sequence of unit symbols with optional integer exponents, joined by "*"
+// or "/", e.g. "km/h" or "s^-1". Operators followed by anything other
+// than a unit symbol end the unit and belong to the enclosing expression.
+func (p *Parser) parseQuantity(x *NumberLit) (Node, error) {
+	q := &QuantityExpr{X: x, UnitPos: p.tokens[p.pos].pos}
+	var text strings.Builder
+
+	for {
+		symbol := p.tokens[p.pos]
+		p.pos++
+		text.WriteString(symbol.text)
+		q.UnitEnd = symbol.pos + Pos(len(symbol.text))
+
+		if exponent, end, n := p.unitExponent(); n > 0 {
+			text.WriteString("^" + exponent)
+			q.UnitEnd = end
+			p.pos += n
+		}
+
+		if p.pos < len(p.tokens) && (p.tokens[p.pos].text == "*" || p.tokens[p.pos].text == "/") && p.atUnit(p.pos+1) {
+			text.WriteString(p.tokens[p.pos].text)
+			p.pos++
+			continue
+		}
+		break
+	}
+
+	q.UnitText = text.String()
+	unit, err := ParseUnit(q.UnitText)
+	if err != nil {
+		return nil, &SyntaxError{Code: CodeInvalidUnit, Message: err.Error(), Offset: q.UnitPos, Length: int(q.UnitEnd - q.UnitPos)}
+	}
+	q.Unit = unit
+	return q, nil
+}
+
+// unitExponent looks for an integer exponent such as "^2" or "^-1" at the
+// current position. It returns the exponent text, the position after it and
+// the number of tokens it spans, or zero tokens if there is no exponent.
+func (p *Parser) unitExponent() (string, Pos, int) {
+	i := p.pos
+	if i >= len(p.tokens) || p.tokens[i].text != "^" {
+		return "", 0, 0
+	}
+	i++
+
+	sign := ""
+	if i < len(p.tokens) && p.tokens[i].text == "-" {
+		sign = "-"
+		i++
+	}
+	if i >= len(p.tokens) || p.tokens[i].kind != tokenNumber || strings.Contains(p.tokens[i].text, ".") {
+		return "", 0, 0
+	}
+
+	tok := p.tokens[i]
+	return sign + tok.text, tok.pos + Pos(len(tok.text)), i + 1 - p.pos
+}
+
+// tokenTexts returns the text of each token, for logging.
+func tokenTexts(tokens []token) []string {
+	texts := make([]string, len(tokens))
+	for i, tok := range tokens {
+		texts[i] = tok.text
+	}
+	return texts
+}
+
+// unclosedParen returns the error for "(" at lparen without a matching ")".
+func unclosedParen(lparen Pos) *SyntaxError {
+	return &SyntaxError{Code: CodeUnclosedParen, Message: constants.ErrMissingCloseParen + " for '('", Offset: lparen, Length: 1}
+}
@@ -0,0 +1,385 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// Mode selects the number system an expression is evaluated in.
+type Mode string
+
+const (
+	ModeFloat    Mode = "float"    // Binary float64 arithmetic, the default.
+	ModeDecimal  Mode = "decimal"  // Decimal arithmetic with a fixed number of fractional digits.
+	ModeRational Mode = "rational" // Exact fractions of arbitrary-size integers.
+	ModeComplex  Mode = "complex"  // Complex numbers with float64 parts.
+	ModeInteger  Mode = "integer"  // 64-bit integers with bitwise operators and overflow detection.
+)
+
+// ImaginaryUnit is the name of the imaginary unit in complex mode. It can
+// still be used as a variable name: a bound variable takes precedence.
+const ImaginaryUnit = "i"
+
+const (
+	// DefaultScale is the number of fractional digits kept in decimal mode
+	// when the request does not specify one.
+	DefaultScale = 28
+	// MaxScale limits the number of fractional digits in decimal mode.
+	MaxScale = 1000
+)
+
+// numberSystem implements values and operations of one exact mode.
+// Values are opaque to the rest of the package and are exchanged with
+// the outside world in their canonical text form.
+type numberSystem interface {
+	parse(text string) (any, error)
+	format(v any) string
+	float(v any) float64
+	negate(v any) (any, error)
+	binary(op string, x, y any) (any, error)
+	call(name string, args []any) (any, error)
+	supports(name string) bool
+	isZero(v any) bool
+	sign(v any) (int, error) // -1, 0 or 1; fails for values that are not ordered.
+}
+
+// orderedSystem is a number system that compares values directly rather
+// than by the sign of their difference, which may not be representable.
+type orderedSystem interface {
+	compare(x, y any) int // -1, 0 or 1 as x is less than, equal to or greater than y.
+}
+
+// Arithmetic evaluates operations in one of the exact modes. Operands and
+// results are passed as canonical strings so they can travel through the
+// task DAG, gRPC messages and storage without losing precision.
+type Arithmetic struct {
+	mode  Mode
+	scale int
+	sys   numberSystem
+}
+
+// NewArithmetic returns the arithmetic of an exact mode. It returns nil for
+// ModeFloat and the empty mode, which use plain float64 operations.
+func NewArithmetic(mode Mode, scale int) (*Arithmetic, error) {
+	a := &Arithmetic{mode: mode, scale: scale}
+
+	switch mode {
+	case "", ModeFloat:
+		return nil, nil
+	case ModeDecimal:
+		if scale < 0 || scale > MaxScale {
+			return nil, fmt.Errorf("scale must be between 0 and %d", MaxScale)
+		}
+		a.sys = decimalSystem{scale: scale}
+	case ModeRational:
+		a.scale = 0
+		a.sys = rationalSystem{}
+	case ModeComplex:
+		a.scale = 0
+		a.sys = complexSystem{}
+	case ModeInteger:
+		a.scale = 0
+		a.sys = integerSystem{}
+	default:
+		return nil, fmt.Errorf("unknown precision mode %q", mode)
+	}
+
+	return a, nil
+}
+
+// Mode returns the mode of the arithmetic.
+func (a *Arithmetic) Mode() Mode { return a.mode }
+
+// Scale returns the number of fractional digits kept in decimal mode.
+func (a *Arithmetic) Scale() int { return a.scale }
+
+// Literal converts a numeric literal to its canonical text form.
+func (a *Arithmetic) Literal(literal string) (string, error) {
+	v, err := a.sys.parse(literal)
+	if err != nil {
+		return "", err
+	}
+	return a.sys.format(v), nil
+}
+
+// FromFloat converts a float64, e.g. a variable value from JSON, using its
+// shortest decimal representation so that 0.1 stays exactly 0.1.
+func (a *Arithmetic) FromFloat(f float64) (string, error) {
+	return a.Literal(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// Float returns the float64 approximation of a value.
+func (a *Arithmetic) Float(value string) (float64, error) {
+	v, err := a.sys.parse(value)
+	if err != nil {
+		return 0, err
+	}
+	return a.sys.float(v), nil
+}
+
+// Negate returns -value.
+func (a *Arithmetic) Negate(value string) (string, error) {
+	v, err := a.sys.parse(value)
+	if err != nil {
+		return "", err
+	}
+	r, err := a.sys.negate(v)
+	if err != nil {
+		return "", err
+	}
+	return a.sys.format(r), nil
+}
+
+// Apply applies a binary operator or a built-in function to operands given
+// in canonical text form. It is the exact counterpart of ApplyBinary and
+// Function.Call used by the agents.
+func (a *Arithmetic) Apply(op string, args ...string) (string, error) {
+	values := make([]any, len(args))
+	for i, arg := range args {
+		v, err := a.sys.parse(arg)
+		if err != nil {
+			return "", err
+		}
+		values[i] = v
+	}
+
+	var (
+		r   any
+		err error
+	)
+	if fn, ok := LookupFunction(op); ok {
+		if err := fn.checkArity(len(values)); err != nil {
+			return "", err
+		}
+		r, err = a.sys.call(fn.Name, values)
+	} else {
+		if n := OperandCount(op); len(values) != n {
+			return "", fmt.Errorf("operator %s expects %d operands, got %d", op, n, len(values))
+		}
+		if op == SelectOp {
+			r = values[2]
+			if !a.sys.isZero(values[0]) {
+				r = values[1]
+			}
+		} else {
+			r, err = a.binary(op, values[0], values[1])
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return a.sys.format(r), nil
+}
+
+// Variable returns the value of a variable in canonical text form. In
+// complex mode an unbound ImaginaryUnit denotes the imaginary unit.
+func (a *Arithmetic) Variable(ident *Ident, vars Variables) (string, error) {
+	f, err := vars.Lookup(ident)
+	if err != nil {
+		if a.mode == ModeComplex && ident.Name == ImaginaryUnit {
+			return "1i", nil
+		}
+		return "", err
+	}
+	return a.FromFloat(f)
+}
+
+// Check reports the first function or literal in the tree that this mode
+// cannot evaluate, such as 2.5 in integer mode.
+func (a *Arithmetic) Check(node Node) error {
+	var err error
+	Inspect(node, func(n Node) bool {
+		switch n := n.(type) {
+		case *NumberLit:
+			if _, parseErr := a.sys.parse(n.Decimal()); parseErr != nil {
+				err = fmt.Errorf("%w at position %d", parseErr, n.ValuePos)
+			}
+		case *CallExpr:
+			if !a.sys.supports(n.Name) {
+				err = fmt.Errorf("function %s is not supported in %s mode at position %d", n.Name, a.mode, n.NamePos)
+			}
+		case *ImagLit:
+			if a.mode != ModeComplex {
+				err = fmt.Errorf("imaginary number %s at position %d %s", n.Literal, n.ValuePos, constants.ErrImaginaryRequiresComplex)
+			}
+		case *QuantityExpr:
+			err = fmt.Errorf("unit %s at position %d %s", n.UnitText, n.UnitPos, constants.ErrRequiresFloatMode)
+		case *ListExpr:
+			err = fmt.Errorf("array at position %d %s", n.Lbrack, constants.ErrRequiresFloatMode)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// Evaluate computes the value of a syntax tree in this mode and returns it
+// in canonical text form.
+func (a *Arithmetic) Evaluate(node Node, vars Variables) (string, error) {
+	v, err := a.eval(node, vars, make(map[*Binding]any))
+	if err != nil {
+		return "", err
+	}
+	return a.sys.format(v), nil
+}
+
+// eval computes the value of node; bound holds the values of the bindings
+// of a script computed so far.
+func (a *Arithmetic) eval(node Node, vars Variables, bound map[*Binding]any) (any, error) {
+	switch n := node.(type) {
+	case *NumberLit:
+		return a.sys.parse(n.Decimal())
+	case *ImagLit:
+		if a.mode != ModeComplex {
+			return nil, fmt.Errorf("imaginary number %s at position %d %s", n.Literal, n.ValuePos, constants.ErrImaginaryRequiresComplex)
+		}
+		return a.sys.parse(n.Decimal())
+	case *QuantityExpr:
+		return nil, fmt.Errorf("unit %s at position %d %s", n.UnitText, n.UnitPos, constants.ErrRequiresFloatMode)
+	case *ListExpr:
+		return nil, fmt.Errorf("array at position %d %s", n.Lbrack, constants.ErrRequiresFloatMode)
+	case *Ident:
+		text, err := a.Variable(n, vars)
+		if err != nil {
+			return nil, err
+		}
+		return a.sys.parse(text)
+	case *ParenExpr:
+		return a.eval(n.X, vars, bound)
+	case *Script:
+		for _, binding := range n.Used() {
+			v, err := a.eval(binding.Value, vars, bound)
+			if err != nil {
+				return nil, err
+			}
+			bound[binding] = v
+		}
+		return a.eval(n.Result, vars, bound)
+	case *UserCall:
+		return a.eval(n.Body, vars, bound)
+	case *Ref:
+		if v, ok := bound[n.Binding]; ok {
+			return v, nil
+		}
+		return a.eval(n.Binding.Value, vars, bound)
+	case *UnaryExpr:
+		x, err := a.eval(n.X, vars, bound)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Op {
+		case "-":
+			return a.sys.negate(x)
+		case "!":
+			return a.truth(a.sys.isZero(x))
+		case "~":
+			minusOne, err := a.sys.parse("-1")
+			if err != nil {
+				return nil, err
+			}
+			return a.binary("xor", x, minusOne)
+		default:
+			return nil, fmt.Errorf("%s '%s' at position %d", constants.ErrUnexpectedToken, n.Op, n.OpPos)
+		}
+	case *BinaryExpr:
+		left, err := a.eval(n.Left, vars, bound)
+		if err != nil {
+			return nil, err
+		}
+		// The left operand of && and || may decide the result alone, and
+		// then the right one is not evaluated, as in a conditional.
+		if (n.Op == "&&" && a.sys.isZero(left)) || (n.Op == "||" && !a.sys.isZero(left)) {
+			return a.truth(n.Op == "||")
+		}
+		right, err := a.eval(n.Right, vars, bound)
+		if err != nil {
+			return nil, err
+		}
+		return a.binary(n.Op, left, right)
+	case *CondExpr:
+		cond, err := a.eval(n.Cond, vars, bound)
+		if err != nil {
+			return nil, err
+		}
+		if a.sys.isZero(cond) {
+			return a.eval(n.Else, vars, bound)
+		}
+		return a.eval(n.Then, vars, bound)
+	case *CallExpr:
+		if !a.sys.supports(n.Name) {
+			return nil, fmt.Errorf("function %s is not supported in %s mode at position %d", n.Name, a.mode, n.NamePos)
+		}
+		args := make([]any, len(n.Args))
+		for i, arg := range n.Args {
+			v, err := a.eval(arg, vars, bound)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		return a.sys.call(n.Name, args)
+	default:
+		return nil, errors.New("unsupported node")
+	}
+}
+
+// binary applies an arithmetic, comparison or logical operator. The number
+// systems implement arithmetic; comparisons only need the sign of the
+// difference of the operands, and logical operators whether they are zero.
+func (a *Arithmetic) binary(op string, x, y any) (any, error) {
+	switch op {
+	case "&&":
+		return a.truth(!a.sys.isZero(x) && !a.sys.isZero(y))
+	case "||":
+		return a.truth(!a.sys.isZero(x) || !a.sys.isZero(y))
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return a.sys.binary(op, x, y)
+	}
+
+	if o, ok := a.sys.(orderedSystem); ok {
+		return a.truth(holds(op, o.compare(x, y)))
+	}
+	diff, err := a.sys.binary("-", x, y)
+	if err != nil {
+		return nil, err
+	}
+	if op == "==" || op == "!=" {
+		return a.truth(a.sys.isZero(diff) == (op == "=="))
+	}
+
+	sign, err := a.sys.sign(diff)
+	if err != nil {
+		return nil, err
+	}
+	return a.truth(holds(op, sign))
+}
+
+// holds reports whether the comparison op is true of operands whose
+// difference has the given sign.
+func holds(op string, sign int) bool {
+	switch op {
+	case "==":
+		return sign == 0
+	case "!=":
+		return sign != 0
+	case "<":
+		return sign < 0
+	case "<=":
+		return sign <= 0
+	case ">":
+		return sign > 0
+	default:
+		return sign >= 0
+	}
+}
+
+// truth returns 1 or 0 as a value of the number system.
+func (a *Arithmetic) truth(b bool) (any, error) {
+	if b {
+		return a.sys.parse("1")
+	}
+	return a.sys.parse("0")
+}
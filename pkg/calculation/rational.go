@@ -0,0 +1,73 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// rationalSystem is exact arithmetic on fractions. Values are formatted as
+// "numerator/denominator" in lowest terms, or as an integer.
+type rationalSystem struct{}
+
+func (rationalSystem) parse(text string) (any, error) {
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, fmt.Errorf("invalid rational %q", text)
+	}
+	return r, nil
+}
+
+func (rationalSystem) format(v any) string {
+	return v.(*big.Rat).RatString()
+}
+
+func (rationalSystem) float(v any) float64 {
+	f, _ := v.(*big.Rat).Float64()
+	return f
+}
+
+func (rationalSystem) negate(v any) (any, error) {
+	return new(big.Rat).Neg(v.(*big.Rat)), nil
+}
+
+func (rationalSystem) binary(op string, x, y any) (any, error) {
+	return ratBinary(op, x.(*big.Rat), y.(*big.Rat))
+}
+
+func (rationalSystem) supports(name string) bool {
+	switch name {
+	case "sqrt", "abs", "round", "min", "max":
+		return true
+	}
+	return false
+}
+
+func (rationalSystem) isZero(v any) bool { return v.(*big.Rat).Sign() == 0 }
+
+func (rationalSystem) sign(v any) (int, error) { return v.(*big.Rat).Sign(), nil }
+
+func (rationalSystem) call(name string, args []any) (any, error) {
+	x := args[0].(*big.Rat)
+
+	switch name {
+	case "sqrt":
+		// Only perfect squares have a rational root.
+		if x.Sign() < 0 {
+			return nil, errors.New(constants.ErrSqrtOfNegative)
+		}
+		num := new(big.Int).Sqrt(x.Num())
+		den := new(big.Int).Sqrt(x.Denom())
+		root := new(big.Rat).SetFrac(num, den)
+		if new(big.Rat).Mul(root, root).Cmp(x) != 0 {
+			return nil, fmt.Errorf("%s: sqrt(%s)", constants.ErrIrrationalResult, x.RatString())
+		}
+		return root, nil
+	case "min", "max":
+		return ratFold(name, args), nil
+	default:
+		return ratCall(name, x)
+	}
+}
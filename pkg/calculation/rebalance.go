@@ -0,0 +1,118 @@
+package calculation
+
+// Rebalance returns a copy of the tree in which chains of the associative
+// operators + and *, such as a+b+c+d, are regrouped into balanced trees,
+// (a+b)+(c+d). A chain of n operands then needs n-1 operations as before,
+// but only about log2(n) of them depend on each other, so the rest can be
+// computed in parallel. Chains continue through parentheses, so (a+b)+c is
+// a chain of three operands. The result is mathematically equal to the
+// original expression, but floating-point rounding may differ. The bindings
+// of a script are rebalanced once and stay shared by their references.
+func Rebalance(node Node) Node {
+	return rebalance(node, make(map[*Binding]*Binding))
+}
+
+// rebalance is Rebalance with the copies of the bindings made so far.
+func rebalance(node Node, bindings map[*Binding]*Binding) Node {
+	switch n := node.(type) {
+	case *BinaryExpr:
+		if n.Op != "+" && n.Op != "*" {
+			return &BinaryExpr{Left: rebalance(n.Left, bindings), OpPos: n.OpPos, Op: n.Op, Right: rebalance(n.Right, bindings)}
+		}
+
+		var c chain
+		c.collect(n, n.Op)
+		for i, operand := range c.operands {
+			c.operands[i] = rebalance(operand, bindings)
+		}
+		return c.balance(n.Op, 0, len(c.operands))
+	case *UnaryExpr:
+		return &UnaryExpr{OpPos: n.OpPos, Op: n.Op, X: rebalance(n.X, bindings)}
+	case *ParenExpr:
+		return &ParenExpr{Lparen: n.Lparen, X: rebalance(n.X, bindings), Rparen: n.Rparen}
+	case *CondExpr:
+		cond := *n
+		cond.Cond, cond.Then, cond.Else = rebalance(n.Cond, bindings), rebalance(n.Then, bindings), rebalance(n.Else, bindings)
+		return &cond
+	case *CallExpr:
+		call := *n
+		call.Args = make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			call.Args[i] = rebalance(arg, bindings)
+		}
+		return &call
+	case *Script:
+		script := &Script{Bindings: make([]*Binding, len(n.Bindings))}
+		for i, binding := range n.Bindings {
+			copied := *binding
+			copied.Value = rebalance(binding.Value, bindings)
+			bindings[binding] = &copied
+			script.Bindings[i] = &copied
+		}
+		script.Result = rebalance(n.Result, bindings)
+		return script
+	case *UserCall:
+		call := *n
+		call.Body = rebalance(n.Body, bindings).(*Script)
+		call.Args = make([]Node, len(n.Args))
+		for i, binding := range call.Body.Bindings {
+			call.Args[i] = binding.Value
+		}
+		return &call
+	case *Ref:
+		ref := *n
+		if binding, ok := bindings[n.Binding]; ok {
+			ref.Binding = binding
+		}
+		return &ref
+	case *ListExpr:
+		list := *n
+		list.Elems = make([]Node, len(n.Elems))
+		for i, elem := range n.Elems {
+			list.Elems[i] = rebalance(elem, bindings)
+		}
+		return &list
+	default:
+		return node
+	}
+}
+
+// chain holds the operands of a chain of one operator in source order and
+// the positions of the operators between them.
+type chain struct {
+	operands []Node
+	ops      []Pos
+}
+
+// collect appends the operands of the chain of op rooted at node.
+func (c *chain) collect(node Node, op string) {
+	switch n := node.(type) {
+	case *BinaryExpr:
+		if n.Op == op {
+			c.collect(n.Left, op)
+			c.ops = append(c.ops, n.OpPos)
+			c.collect(n.Right, op)
+			return
+		}
+	case *ParenExpr:
+		if inner, ok := n.X.(*BinaryExpr); ok && inner.Op == op {
+			c.collect(inner, op)
+			return
+		}
+	}
+	c.operands = append(c.operands, node)
+}
+
+// balance builds a balanced tree of the operands in [lo, hi).
+func (c *chain) balance(op string, lo, hi int) Node {
+	if hi-lo == 1 {
+		return c.operands[lo]
+	}
+	mid := (lo + hi) / 2
+	return &BinaryExpr{
+		Left:  c.balance(op, lo, mid),
+		OpPos: c.ops[mid-1],
+		Op:    op,
+		Right: c.balance(op, mid, hi),
+	}
+}
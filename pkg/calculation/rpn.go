@@ -0,0 +1,123 @@
+package calculation
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// ParseRPN parses an expression in reverse Polish notation, such as
+// 3 4 + 2 *, that may call the user-defined functions funcs. Every
+// operator takes its operands from the values before it: - and the other
+// binary operators two, neg, ! and ~ one, ? three (cond a b ?). A function
+// takes as many arguments as it has parameters; one with a variable
+// number of them is given the count after a colon, max:3. A number written
+// with its sign, -3, is negative.
+func ParseRPN(expression string, funcs Definitions) (Node, error) {
+	tokens, err := tokenizePolish(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &Parser{tokens: tokens, end: Pos(len(expression)), funcs: funcs, calls: new(int)}
+
+	var stack []Node
+	pop := func(op token, n int) ([]Node, error) {
+		if len(stack) < n {
+			return nil, missingOperands(op, n, len(stack))
+		}
+		args := stack[len(stack)-n:]
+		stack = stack[:len(stack)-n]
+		return args, nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		var node Node
+
+		switch {
+		case negativeLiteral(tokens, i):
+			i++
+			x, err := atom(tokens[i])
+			if err != nil {
+				return nil, err
+			}
+			node = &UnaryExpr{OpPos: tok.pos, Op: "-", X: x}
+		case unaryOps[tok.text] != "":
+			args, err := pop(tok, 1)
+			if err != nil {
+				return nil, err
+			}
+			node = &UnaryExpr{OpPos: tok.pos, Op: unaryOps[tok.text], X: args[0]}
+		case tok.kind == tokenOperator:
+			args, err := pop(tok, 2)
+			if err != nil {
+				return nil, err
+			}
+			node = &BinaryExpr{Left: args[0], OpPos: tok.pos, Op: tok.text, Right: args[1]}
+		case tok.kind == tokenQuestion:
+			args, err := pop(tok, 3)
+			if err != nil {
+				return nil, err
+			}
+			node = &CondExpr{If: NoPos, Cond: args[0], Question: tok.pos, Then: args[1], Colon: NoPos, Else: args[2], Rparen: NoPos}
+		case tok.kind == tokenIdent && isFunction(tok.text, funcs):
+			n, next, err := p.rpnArity(i)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			args, err := pop(tok, n)
+			if err != nil {
+				return nil, err
+			}
+			// The arguments are kept in their own slice: the stack reuses its array.
+			if node, err = p.apply(tok, append([]Node(nil), args...), NoPos, NoPos); err != nil {
+				return nil, err
+			}
+		default:
+			if node, err = atom(tok); err != nil {
+				return nil, err
+			}
+		}
+		stack = append(stack, node)
+	}
+
+	if len(stack) > 1 {
+		extra := stack[len(stack)-1]
+		return nil, &SyntaxError{Code: CodeExtraOperand, Message: constants.ErrExtraOperand, Offset: extra.Pos(), Length: max(int(extra.End()-extra.Pos()), 0)}
+	}
+	return stack[0], nil
+}
+
+// rpnArity returns the number of arguments of the function named by
+// tokens[i]: the count after a colon if it is given, else the number of
+// its parameters. It also returns the index of the last token read.
+func (p *Parser) rpnArity(i int) (int, int, error) {
+	name := p.tokens[i]
+	if i+2 < len(p.tokens) && p.tokens[i+1].kind == tokenColon {
+		count := p.tokens[i+2]
+		n, err := strconv.Atoi(count.text)
+		if err != nil || n < 0 {
+			return 0, 0, unexpected(count, "argument count")
+		}
+		return n, i + 2, nil
+	}
+
+	if name.text == "if" {
+		return 3, i, nil
+	}
+	fn, ok := LookupFunction(name.text)
+	if !ok {
+		return len(p.funcs[name.text].Params), i, nil
+	}
+	if fn.MinArgs != fn.MaxArgs {
+		return 0, 0, &SyntaxError{
+			Code:    CodeWrongArity,
+			Message: fmt.Sprintf("function %s takes a variable number of arguments, give their count as %s:2", fn.Name, fn.Name),
+			Offset:  name.pos,
+			Length:  len(name.text),
+		}
+	}
+	return fn.MinArgs, i, nil
+}
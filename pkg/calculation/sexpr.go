@@ -0,0 +1,135 @@
+package calculation
+
+import (
+	"fmt"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// sexprOperands are the tokens an operand of an S-expression can start with.
+var sexprOperands = []string{"number", "name", "'('"}
+
+// chainOps are the operators that group to the left and so take any number
+// of operands in an S-expression: (+ 1 2 3) is 1 + 2 + 3.
+var chainOps = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true, "//": true, "%": true,
+	"&": true, "|": true, "xor": true, "&&": true, "||": true,
+}
+
+// ParseSExpr parses an expression written as S-expressions, such as
+// (* (+ 3 4) 2), that may call the user-defined functions funcs. A list
+// applies its first element, an operator or a function name, to the
+// rest: (max 1 2 3), (if c a b) or (? c a b). The operators that group to
+// the left take two or more operands, (- x) and (neg x) negate, and the
+// rest take exactly two, or one for ! and ~. A number written with its
+// sign, -3, is negative.
+func ParseSExpr(expression string, funcs Definitions) (Node, error) {
+	tokens, err := tokenizePolish(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &Parser{tokens: tokens, end: Pos(len(expression)), funcs: funcs, calls: new(int)}
+
+	node, err := p.parseSExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, unexpected(p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+// parseSExpr parses an atom or a list.
+func (p *Parser) parseSExpr() (Node, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, &SyntaxError{Code: CodeUnexpectedEnd, Message: constants.ErrUnexpectedEndExpr, Offset: p.end, Expected: sexprOperands}
+	}
+
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch {
+	case negativeLiteral(p.tokens, p.pos-1):
+		x, err := atom(p.tokens[p.pos])
+		p.pos++
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{OpPos: tok.pos, Op: "-", X: x}, nil
+	case tok.kind != tokenLParen:
+		if tok.kind == tokenIdent || tok.kind == tokenNumber || tok.kind == tokenImag {
+			return atom(tok)
+		}
+		return nil, unexpected(tok, sexprOperands...)
+	}
+
+	if p.pos >= len(p.tokens) {
+		return nil, unclosedParen(tok.pos)
+	}
+	head := p.tokens[p.pos]
+	p.pos++
+	if head.kind != tokenOperator && head.kind != tokenQuestion && head.kind != tokenIdent {
+		return nil, unexpected(head, "operator", "name")
+	}
+
+	var args []Node
+	for {
+		if p.pos >= len(p.tokens) {
+			return nil, unclosedParen(tok.pos)
+		}
+		if p.tokens[p.pos].kind == tokenRParen {
+			break
+		}
+		arg, err := p.parseSExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	rparen := p.tokens[p.pos].pos
+	p.pos++
+
+	return p.applySExpr(head, args, tok.pos, rparen)
+}
+
+// applySExpr builds the node of a list whose first element is head.
+func (p *Parser) applySExpr(head token, args []Node, lparen, rparen Pos) (Node, error) {
+	wrongCount := func(want string) error {
+		return &SyntaxError{
+			Code:    CodeWrongArity,
+			Message: fmt.Sprintf("operator '%s' expects %s operand(s), got %d", head.text, want, len(args)),
+			Offset:  head.pos,
+			Length:  len(head.text),
+		}
+	}
+
+	switch {
+	case head.kind == tokenQuestion:
+		if len(args) != 3 {
+			return nil, wrongCount("3")
+		}
+		return &CondExpr{If: NoPos, Cond: args[0], Question: head.pos, Then: args[1], Colon: NoPos, Else: args[2], Rparen: NoPos}, nil
+	case unaryOps[head.text] != "", head.text == "-" && len(args) == 1:
+		if len(args) != 1 {
+			return nil, wrongCount("1")
+		}
+		op := unaryOps[head.text]
+		if op == "" {
+			op = "-"
+		}
+		return &UnaryExpr{OpPos: head.pos, Op: op, X: args[0]}, nil
+	case head.kind == tokenOperator:
+		if len(args) < 2 {
+			return nil, missingOperands(head, 2, len(args))
+		}
+		if len(args) > 2 && !chainOps[head.text] {
+			return nil, wrongCount("2")
+		}
+		node := args[0]
+		for _, arg := range args[1:] {
+			node = &BinaryExpr{Left: node, OpPos: head.pos, Op: head.text, Right: arg}
+		}
+		return node, nil
+	}
+	return p.apply(head, args, lparen, rparen)
+}
@@ -0,0 +1,658 @@
+package calculation
+
+import (
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Simplify returns the expression rooted at node in canonical form.
+// Parentheses the operators do not need are dropped, operations on
+// constants are folded with exact rational arithmetic, and sums and
+// products are collected into terms and factors: like terms are combined
+// (x + 2*x is 3 * x), equal factors become powers (x * x is x^2), and
+// terms and factors are put in a fixed order, so that expressions that
+// differ only in these respects simplify to the same tree. Terms that
+// cancel are dropped, together with any error they would raise, so x/x
+// simplifies to 1. Products are not multiplied out, except by constants.
+//
+// Constants are folded only while their numerators and denominators fit in
+// maxFoldedBits; a larger result is left as it is written, so that the
+// canonical form stays about as long as the expression.
+//
+// A conditional with a constant condition is replaced by its branch; other
+// operations, calls, vectors and matrices keep their form with simplified
+// operands. Scripts keep their bindings and calls of user-defined functions
+// are not expanded.
+func Simplify(node Node) (Node, error) {
+	if _, err := ShapeOf(node); err != nil {
+		return nil, err
+	}
+	s := &simplifier{bindings: make(map[*Binding]*Binding)}
+	return s.simplify(node), nil
+}
+
+// SimplifyWith is Simplify for an expression evaluated by arith, or in float
+// mode if arith is nil. A constant is folded only if the mode holds its
+// value exactly and the operation does not fail in it: integer-mode sums
+// that overflow int64, decimal products that would be rounded to the scale
+// and float results out of the range of float64 are left as they are
+// written, so that the canonical form never shows a value the evaluation
+// cannot produce.
+func SimplifyWith(node Node, arith *Arithmetic) (Node, error) {
+	if _, err := ShapeOf(node); err != nil {
+		return nil, err
+	}
+	s := &simplifier{bindings: make(map[*Binding]*Binding), modal: true, arith: arith}
+	return s.simplify(node), nil
+}
+
+// maxFoldedBits limits the size of folded constants: 1024 bits cover the
+// range of float64.
+const maxFoldedBits = 1 << 10
+
+// term is a product coef * factors[0] * factors[1] * ... of a sum.
+type term struct {
+	coef    *big.Rat
+	factors []factor // Sorted by key, with non-zero exponents.
+}
+
+// factor is base^exp. Bases are never sums or products of a constant with
+// something else, unless they could not be expanded.
+type factor struct {
+	key  string // Canonical text of base.
+	base Node
+	exp  *big.Rat
+}
+
+// poly is a sum of terms, each with a different product of factors. The
+// empty sum is zero.
+type poly []term
+
+type simplifier struct {
+	script   *Script               // Script being simplified, if any.
+	bindings map[*Binding]*Binding // Simplified copies of its bindings.
+	modal    bool                  // Constants are folded in the mode of arith.
+	arith    *Arithmetic           // Mode of the expression; nil is float mode.
+}
+
+// simplify returns node simplified; a vector or matrix whose elements all
+// cancel to the same value is kept in its shape.
+func (s *simplifier) simplify(node Node) Node {
+	result := s.expand(node).node()
+	if shape := s.shapeOf(node); len(shape) > 0 && len(shapeOf(result)) == 0 {
+		return fill(shape, result)
+	}
+	return result
+}
+
+// shapeOf returns the shape of node, which may refer to the bindings of
+// the script being simplified.
+func (s *simplifier) shapeOf(node Node) Shape {
+	if s.script != nil {
+		return shapeOf(&Script{Bindings: s.script.Bindings, Result: node})
+	}
+	return shapeOf(node)
+}
+
+func (s *simplifier) expand(node Node) poly {
+	switch n := node.(type) {
+	case *NumberLit:
+		if r, ok := ratOf(n); ok && s.fits(r) {
+			return constPoly(r)
+		}
+		return atomPoly(n)
+	case *ParenExpr:
+		return s.expand(n.X)
+	case *UnaryExpr:
+		if n.Op == "-" {
+			x := s.expand(n.X)
+			if p := x.scale(big.NewRat(-1, 1)); s.fitsPoly(p) {
+				return p
+			}
+			return atomPoly(&UnaryExpr{OpPos: NoPos, Op: n.Op, X: x.node()})
+		}
+		x := s.simplify(n.X)
+		if r, ok := s.constant(x); ok {
+			if n.Op == "!" {
+				return boolPoly(r.Sign() == 0)
+			}
+			// ~x is -x - 1, or x xor -1.
+			if r.IsInt() && s.evaluates("xor", r, big.NewRat(-1, 1)) {
+				if r := new(big.Rat).Sub(new(big.Rat).Neg(r), big.NewRat(1, 1)); s.fits(r) {
+					return constPoly(r)
+				}
+			}
+		}
+		return atomPoly(&UnaryExpr{OpPos: NoPos, Op: n.Op, X: x})
+	case *BinaryExpr:
+		return s.binary(n)
+	case *CondExpr:
+		cond := s.simplify(n.Cond)
+		if r, ok := s.constant(cond); ok {
+			if r.Sign() != 0 {
+				return s.expand(n.Then)
+			}
+			return s.expand(n.Else)
+		}
+		return atomPoly(&CondExpr{If: n.If, Cond: cond, Question: NoPos, Then: s.simplify(n.Then), Colon: NoPos, Else: s.simplify(n.Else), Rparen: NoPos})
+	case *CallExpr:
+		return atomPoly(&CallExpr{NamePos: NoPos, Name: n.Name, Lparen: NoPos, Args: s.simplifyAll(n.Args), Rparen: NoPos})
+	case *UserCall:
+		// The expansion keeps the original arguments: they have the same
+		// values as the simplified ones.
+		return atomPoly(&UserCall{NamePos: NoPos, Name: n.Name, Lparen: NoPos, Args: s.simplifyAll(n.Args), Rparen: NoPos, Body: n.Body})
+	case *ListExpr:
+		return atomPoly(&ListExpr{Lbrack: NoPos, Elems: s.simplifyAll(n.Elems), Rbrack: NoPos})
+	case *Script:
+		s.script = n
+		script := &Script{Bindings: make([]*Binding, len(n.Bindings))}
+		for i, binding := range n.Bindings {
+			script.Bindings[i] = &Binding{NamePos: NoPos, Name: binding.Name, Value: s.simplify(binding.Value), Semi: NoPos}
+			s.bindings[binding] = script.Bindings[i]
+		}
+		script.Result = s.simplify(n.Result)
+		return atomPoly(script)
+	case *Ref:
+		if binding, ok := s.bindings[n.Binding]; ok {
+			return atomPoly(&Ref{NamePos: NoPos, Name: n.Name, Binding: binding})
+		}
+		return atomPoly(n)
+	default:
+		return atomPoly(node)
+	}
+}
+
+func (s *simplifier) binary(n *BinaryExpr) poly {
+	switch n.Op {
+	case "+", "-", "*", "/", "^":
+		left, right := s.expand(n.Left), s.expand(n.Right)
+		var p poly
+		switch n.Op {
+		case "+":
+			p = left.add(right)
+		case "-":
+			p = left.add(right.scale(big.NewRat(-1, 1)))
+		case "*":
+			p = left.mul(right)
+		case "/":
+			p = left.div(right)
+		case "^":
+			p = left.pow(right)
+		}
+		if s.fitsPoly(p) {
+			return p
+		}
+		return atomPoly(&BinaryExpr{Left: left.node(), OpPos: NoPos, Op: n.Op, Right: right.node()})
+	}
+
+	left, right := s.simplify(n.Left), s.simplify(n.Right)
+	x, okX := s.constant(left)
+	y, okY := s.constant(right)
+	if okX && okY && s.evaluates(n.Op, x, y) {
+		if r, ok := ratCompare(n.Op, x, y); ok {
+			return constPoly(r)
+		}
+		if r, err := ratBinary(n.Op, x, y); err == nil && s.fits(r) {
+			return constPoly(r)
+		}
+	}
+	return atomPoly(&BinaryExpr{Left: left, OpPos: NoPos, Op: n.Op, Right: right})
+}
+
+// fits reports whether the constant r may be folded: it is not too large
+// and, in SimplifyWith, the mode holds it exactly.
+func (s *simplifier) fits(r *big.Rat) bool {
+	if ratBits(r) > maxFoldedBits {
+		return false
+	}
+	if !s.modal {
+		return true
+	}
+	if s.arith == nil || s.arith.Mode() == ModeComplex {
+		// Underflow to zero loses the value as well as overflow.
+		f, _ := r.Float64()
+		return !math.IsInf(f, 0) && (f != 0 || r.Sign() == 0)
+	}
+	switch sys := s.arith.sys.(type) {
+	case decimalSystem:
+		return sys.round(r).Cmp(r) == 0
+	case integerSystem:
+		return r.IsInt() && r.Num().IsInt64()
+	}
+	return true
+}
+
+// evaluates reports whether op on the constants x and y succeeds in the
+// mode of SimplifyWith. Exact folding does not fail where the mode may:
+// float mode takes % only of integers and shifts only within 64 bits.
+func (s *simplifier) evaluates(op string, x, y *big.Rat) bool {
+	if !s.modal {
+		return true
+	}
+	if s.arith == nil {
+		fx, _ := x.Float64()
+		fy, _ := y.Float64()
+		_, err := ApplyBinary(op, fx, fy)
+		return err == nil
+	}
+	_, err := s.arith.Apply(op, s.literal(x), s.literal(y))
+	return err == nil
+}
+
+// literal returns the constant r, which the mode of SimplifyWith holds, in
+// a form its arithmetic parses.
+func (s *simplifier) literal(r *big.Rat) string {
+	if s.arith.Mode() == ModeComplex {
+		f, _ := r.Float64()
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return r.RatString()
+}
+
+// constant returns the value of node if it is a constant that may be folded.
+func (s *simplifier) constant(node Node) (*big.Rat, bool) {
+	r, ok := ratConst(node)
+	return r, ok && s.fits(r)
+}
+
+// fitsPoly reports whether the coefficients and exponents of p may be
+// folded. Operations on such polynomials are cheap, and a result that does
+// not fit is replaced by the operation as it is written.
+func (s *simplifier) fitsPoly(p poly) bool {
+	for _, t := range p {
+		if !s.fits(t.coef) {
+			return false
+		}
+		for _, f := range t.factors {
+			if ratBits(f.exp) > maxFoldedBits {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *simplifier) simplifyAll(nodes []Node) []Node {
+	out := make([]Node, len(nodes))
+	for i, node := range nodes {
+		out[i] = s.simplify(node)
+	}
+	return out
+}
+
+func constPoly(r *big.Rat) poly {
+	if r.Sign() == 0 {
+		return nil
+	}
+	return poly{{coef: r}}
+}
+
+func boolPoly(b bool) poly {
+	if b {
+		return constPoly(big.NewRat(1, 1))
+	}
+	return nil
+}
+
+func atomPoly(node Node) poly {
+	return poly{{coef: big.NewRat(1, 1), factors: []factor{{key: Format(node), base: node, exp: big.NewRat(1, 1)}}}}
+}
+
+// constant returns the value of p if it has no factors.
+func (p poly) constant() (*big.Rat, bool) {
+	switch {
+	case len(p) == 0:
+		return new(big.Rat), true
+	case len(p) == 1 && len(p[0].factors) == 0:
+		return p[0].coef, true
+	}
+	return nil, false
+}
+
+// factor returns p as a single factor with exponent exp.
+func (p poly) factor(exp *big.Rat) []factor {
+	base := p.node()
+	return []factor{{key: Format(base), base: base, exp: exp}}
+}
+
+func (p poly) scale(c *big.Rat) poly {
+	if c.Sign() == 0 {
+		return nil
+	}
+	out := make(poly, len(p))
+	for i, t := range p {
+		out[i] = term{coef: new(big.Rat).Mul(t.coef, c), factors: t.factors}
+	}
+	return out
+}
+
+// add returns p + q with like terms combined.
+func (p poly) add(q poly) poly {
+	var out poly
+	index := make(map[string]int)
+	for _, t := range slices.Concat(p, q) {
+		key := t.key()
+		if i, ok := index[key]; ok {
+			out[i].coef = new(big.Rat).Add(out[i].coef, t.coef)
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, term{coef: new(big.Rat).Set(t.coef), factors: t.factors})
+	}
+	return slices.DeleteFunc(out, func(t term) bool { return t.coef.Sign() == 0 })
+}
+
+// mul returns p * q. A sum is multiplied out only by a constant; otherwise
+// it is a factor of the product.
+func (p poly) mul(q poly) poly {
+	if c, ok := p.constant(); ok {
+		return q.scale(c)
+	}
+	if c, ok := q.constant(); ok {
+		return p.scale(c)
+	}
+	a, b := p.single(), q.single()
+	return poly{{coef: new(big.Rat).Mul(a.coef, b.coef), factors: mergeFactors(a.factors, b.factors)}}
+}
+
+// div returns p / q. Division by zero is kept as it is written.
+func (p poly) div(q poly) poly {
+	if c, ok := q.constant(); ok {
+		if c.Sign() == 0 {
+			return atomPoly(&BinaryExpr{Left: p.node(), OpPos: NoPos, Op: "/", Right: q.node()})
+		}
+		return p.scale(new(big.Rat).Inv(c))
+	}
+	return p.mul(q.inverse())
+}
+
+func (p poly) inverse() poly {
+	t := p.single()
+	factors := make([]factor, len(t.factors))
+	for i, f := range t.factors {
+		factors[i] = factor{key: f.key, base: f.base, exp: new(big.Rat).Neg(f.exp)}
+	}
+	return poly{{coef: new(big.Rat).Inv(t.coef), factors: factors}}
+}
+
+// pow returns p^q. Constant integer powers of products are distributed over
+// their factors; other powers with a constant exponent become exponents of
+// a factor, and the rest stay as they are written.
+func (p poly) pow(q poly) poly {
+	e, ok := q.constant()
+	if !ok {
+		return atomPoly(&BinaryExpr{Left: p.node(), OpPos: NoPos, Op: "^", Right: q.node()})
+	}
+	switch {
+	case e.Sign() == 0:
+		return constPoly(big.NewRat(1, 1))
+	case e.Cmp(big.NewRat(1, 1)) == 0:
+		return p
+	}
+
+	if c, ok := p.constant(); ok {
+		if r, err := ratPow(c, e); err == nil {
+			return constPoly(r)
+		}
+		return atomPoly(&BinaryExpr{Left: p.node(), OpPos: NoPos, Op: "^", Right: q.node()})
+	}
+	if len(p) == 1 && e.IsInt() {
+		if coef, err := ratPow(p[0].coef, e); err == nil {
+			factors := make([]factor, len(p[0].factors))
+			for i, f := range p[0].factors {
+				factors[i] = factor{key: f.key, base: f.base, exp: new(big.Rat).Mul(f.exp, e)}
+			}
+			return poly{{coef: coef, factors: factors}}
+		}
+	}
+	return poly{{coef: big.NewRat(1, 1), factors: p.factor(e)}}
+}
+
+// single returns p as one term, making a sum a factor.
+func (p poly) single() term {
+	if len(p) == 1 {
+		return p[0]
+	}
+	return term{coef: big.NewRat(1, 1), factors: p.factor(big.NewRat(1, 1))}
+}
+
+// mergeFactors returns the factors of a product of a and b, sorted by key.
+func mergeFactors(a, b []factor) []factor {
+	var out []factor
+	index := make(map[string]int)
+	for _, f := range slices.Concat(a, b) {
+		if i, ok := index[f.key]; ok {
+			out[i].exp = new(big.Rat).Add(out[i].exp, f.exp)
+			continue
+		}
+		index[f.key] = len(out)
+		out = append(out, factor{key: f.key, base: f.base, exp: new(big.Rat).Set(f.exp)})
+	}
+	out = slices.DeleteFunc(out, func(f factor) bool { return f.exp.Sign() == 0 })
+	slices.SortFunc(out, func(x, y factor) int { return strings.Compare(x.key, y.key) })
+	return out
+}
+
+// key identifies the product of factors of t, so that like terms have equal keys.
+func (t term) key() string {
+	var b strings.Builder
+	for _, f := range t.factors {
+		b.WriteString(f.key + "^" + f.exp.RatString() + ";")
+	}
+	return b.String()
+}
+
+// degree is the sum of exponents of t; terms of higher degree go first.
+func (t term) degree() float64 {
+	var degree float64
+	for _, f := range t.factors {
+		exp, _ := f.exp.Float64()
+		degree += exp
+	}
+	return degree
+}
+
+// node returns the tree of p: terms of higher degree first, the constant
+// term last, and terms with a negative coefficient subtracted.
+func (p poly) node() Node {
+	if len(p) == 0 {
+		return ratLiteral(new(big.Rat))
+	}
+
+	terms := slices.Clone(p)
+	slices.SortStableFunc(terms, func(a, b term) int {
+		if (len(a.factors) == 0) != (len(b.factors) == 0) {
+			if len(a.factors) == 0 {
+				return 1
+			}
+			return -1
+		}
+		if da, db := a.degree(), b.degree(); da != db {
+			if da > db {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.key(), b.key())
+	})
+
+	result := terms[0].node()
+	for _, t := range terms[1:] {
+		op := "+"
+		if t.coef.Sign() < 0 {
+			op = "-"
+			t = term{coef: new(big.Rat).Neg(t.coef), factors: t.factors}
+		}
+		result = &BinaryExpr{Left: result, OpPos: NoPos, Op: op, Right: t.node()}
+	}
+	return result
+}
+
+// node returns the tree of t: the coefficient and the factors with
+// positive exponents divided by those with negative exponents. A
+// coefficient that is not a finite decimal is written as a fraction.
+func (t term) node() Node {
+	coef := new(big.Rat).Abs(t.coef)
+	var num, den []Node
+	switch {
+	case len(t.factors) == 0 || terminates(coef):
+		if coef.Cmp(big.NewRat(1, 1)) != 0 || len(t.factors) == 0 {
+			num = append(num, ratLiteral(coef))
+		}
+	default:
+		if !coef.Num().IsInt64() || coef.Num().Int64() != 1 {
+			num = append(num, ratLiteral(new(big.Rat).SetInt(coef.Num())))
+		}
+		den = append(den, ratLiteral(new(big.Rat).SetInt(coef.Denom())))
+	}
+	for _, f := range t.factors {
+		if f.exp.Sign() > 0 {
+			num = append(num, powerNode(f.base, f.exp))
+		} else {
+			den = append(den, powerNode(f.base, new(big.Rat).Neg(f.exp)))
+		}
+	}
+
+	if len(num) == 0 {
+		num = append(num, ratLiteral(big.NewRat(1, 1)))
+	}
+	if t.coef.Sign() < 0 {
+		num[0] = &UnaryExpr{OpPos: NoPos, Op: "-", X: num[0]}
+	}
+	result := productNode(num)
+	if len(den) > 0 {
+		result = &BinaryExpr{Left: result, OpPos: NoPos, Op: "/", Right: productNode(den)}
+	}
+	return result
+}
+
+func powerNode(base Node, exp *big.Rat) Node {
+	if exp.Cmp(big.NewRat(1, 1)) == 0 {
+		return base
+	}
+	return &BinaryExpr{Left: base, OpPos: NoPos, Op: "^", Right: ratLiteral(exp)}
+}
+
+func productNode(nodes []Node) Node {
+	result := nodes[0]
+	for _, node := range nodes[1:] {
+		result = &BinaryExpr{Left: result, OpPos: NoPos, Op: "*", Right: node}
+	}
+	return result
+}
+
+// ratLiteral returns a literal for r: a decimal if r has a finite decimal
+// expansion, otherwise a fraction. Negative values are negated literals.
+func ratLiteral(r *big.Rat) Node {
+	if r.Sign() < 0 {
+		return &UnaryExpr{OpPos: NoPos, Op: "-", X: ratLiteral(new(big.Rat).Neg(r))}
+	}
+	if !terminates(r) {
+		return &BinaryExpr{
+			Left:  ratLiteral(new(big.Rat).SetInt(r.Num())),
+			OpPos: NoPos,
+			Op:    "/",
+			Right: ratLiteral(new(big.Rat).SetInt(r.Denom())),
+		}
+	}
+
+	text := r.FloatString(decimalDigits(r))
+	value, _ := r.Float64()
+	return &NumberLit{ValuePos: NoPos, Literal: text, Value: value}
+}
+
+// terminates reports whether r has a finite decimal expansion, that is its
+// denominator has no prime factors other than 2 and 5.
+func terminates(r *big.Rat) bool {
+	den := new(big.Int).Set(r.Denom())
+	for _, p := range []int64{2, 5} {
+		prime, rem := big.NewInt(p), new(big.Int)
+		for {
+			q, m := new(big.Int).QuoRem(den, prime, rem)
+			if m.Sign() != 0 {
+				break
+			}
+			den = q
+		}
+	}
+	return den.IsInt64() && den.Int64() == 1
+}
+
+// decimalDigits returns the number of digits after the point of a
+// terminating r.
+func decimalDigits(r *big.Rat) int {
+	digits := 0
+	for scaled := new(big.Rat).Set(r); !scaled.IsInt(); digits++ {
+		scaled.Mul(scaled, big.NewRat(10, 1))
+	}
+	return digits
+}
+
+// ratOf returns the exact value of a literal.
+func ratOf(n *NumberLit) (*big.Rat, bool) {
+	if r, ok := new(big.Rat).SetString(n.Decimal()); ok {
+		return r, true
+	}
+	r := new(big.Rat)
+	if r.SetFloat64(n.Value) == nil {
+		return nil, false
+	}
+	return r, true
+}
+
+// ratConst returns the value of a number or a fraction of numbers,
+// possibly negated, as ratLiteral writes them.
+func ratConst(node Node) (*big.Rat, bool) {
+	switch n := node.(type) {
+	case *NumberLit:
+		return ratOf(n)
+	case *UnaryExpr:
+		if x, ok := ratConst(n.X); ok && n.Op == "-" {
+			return x.Neg(x), true
+		}
+	case *BinaryExpr:
+		if x, y, ok := ratConsts(n.Left, n.Right); ok && n.Op == "/" && y.Sign() != 0 {
+			return x.Quo(x, y), true
+		}
+	}
+	return nil, false
+}
+
+func ratConsts(a, b Node) (*big.Rat, *big.Rat, bool) {
+	x, okA := ratConst(a)
+	y, okB := ratConst(b)
+	return x, y, okA && okB
+}
+
+// ratCompare returns the value of a comparison or logical operation on
+// constants: 1 if it holds and 0 otherwise.
+func ratCompare(op string, x, y *big.Rat) (*big.Rat, bool) {
+	var holds bool
+	switch c := x.Cmp(y); op {
+	case "<":
+		holds = c < 0
+	case "<=":
+		holds = c <= 0
+	case ">":
+		holds = c > 0
+	case ">=":
+		holds = c >= 0
+	case "==":
+		holds = c == 0
+	case "!=":
+		holds = c != 0
+	case "&&":
+		holds = x.Sign() != 0 && y.Sign() != 0
+	case "||":
+		holds = x.Sign() != 0 || y.Sign() != 0
+	default:
+		return nil, false
+	}
+	if holds {
+		return big.NewRat(1, 1), true
+	}
+	return new(big.Rat), true
+}
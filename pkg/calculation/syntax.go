@@ -0,0 +1,163 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// Syntax is the notation an expression is written in.
+type Syntax string
+
+const (
+	SyntaxInfix Syntax = "infix" // Operators between operands: (3 + 4) * 2.
+	SyntaxRPN   Syntax = "rpn"   // Reverse Polish notation, operators after operands: 3 4 + 2 *.
+	SyntaxSExpr Syntax = "sexpr" // S-expressions, operators before operands: (* (+ 3 4) 2).
+)
+
+// Validate checks that s is one of the syntaxes. The empty syntax is
+// valid and means infix.
+func (s Syntax) Validate() error {
+	switch s {
+	case "", SyntaxInfix, SyntaxRPN, SyntaxSExpr:
+		return nil
+	}
+	return fmt.Errorf("%s %q", constants.ErrUnknownSyntax, s)
+}
+
+// ParseSyntax parses an expression written in syntax s that may call the
+// user-defined functions funcs. Whatever the syntax, the tree is the one
+// the infix form of the expression gives.
+func ParseSyntax(expression string, s Syntax, funcs Definitions) (Node, error) {
+	switch s {
+	case SyntaxRPN:
+		return ParseRPN(expression, funcs)
+	case SyntaxSExpr:
+		return ParseSExpr(expression, funcs)
+	}
+	return ParseWith(expression, funcs)
+}
+
+// unaryOps maps the unary operators of RPN and S-expressions to those of
+// the tree. - is binary there, and neg negates.
+var unaryOps = map[string]string{"!": "!", "~": "~", "neg": "-"}
+
+// missingOperands returns the error for an operator or function that gets
+// fewer operands than it needs.
+func missingOperands(op token, want, got int) *SyntaxError {
+	return &SyntaxError{
+		Code:    CodeMissingOperand,
+		Message: fmt.Sprintf("%s for '%s': needs %d, got %d", constants.ErrMissingOperand, op.text, want, got),
+		Offset:  op.pos,
+		Length:  len(op.text),
+	}
+}
+
+// tokenizePolish splits an expression in RPN or S-expression syntax
+// into tokens. Scripts and vectors have no such form.
+func tokenizePolish(expression string) ([]token, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, errors.New("expression is empty")
+	}
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	for _, tok := range tokens {
+		switch tok.kind {
+		case tokenComma, tokenAssign, tokenSemicolon, tokenLBrack, tokenRBrack:
+			return nil, unexpected(tok)
+		}
+	}
+	return tokens, nil
+}
+
+// negativeLiteral reports whether tokens[i] is the sign of a negative
+// number written without a space, such as -3; a - on its own subtracts.
+func negativeLiteral(tokens []token, i int) bool {
+	if tokens[i].text != "-" || i+1 >= len(tokens) {
+		return false
+	}
+	next := tokens[i+1]
+	return (next.kind == tokenNumber || next.kind == tokenImag) && next.pos == tokens[i].pos+1
+}
+
+// atom returns the node of a number, an imaginary number or a variable.
+func atom(tok token) (Node, error) {
+	switch tok.kind {
+	case tokenNumber:
+		num, _, err := parseNumber(tok.text)
+		if err != nil {
+			return nil, &SyntaxError{Code: CodeInvalidNumber, Message: fmt.Sprintf("invalid number %q", tok.text), Offset: tok.pos, Length: len(tok.text)}
+		}
+		return &NumberLit{ValuePos: tok.pos, Literal: tok.text, Value: num}, nil
+	case tokenImag:
+		num, _, err := parseNumber(strings.TrimSuffix(tok.text, "i"))
+		if err != nil {
+			return nil, &SyntaxError{Code: CodeInvalidNumber, Message: fmt.Sprintf("invalid number %q", tok.text), Offset: tok.pos, Length: len(tok.text)}
+		}
+		return &ImagLit{ValuePos: tok.pos, Literal: tok.text, Value: num}, nil
+	case tokenIdent:
+		return &Ident{NamePos: tok.pos, Name: tok.text}, nil
+	}
+	return nil, unexpected(tok, "number", "name")
+}
+
+// isFunction reports whether name is a built-in or user-defined function.
+func isFunction(name string, funcs Definitions) bool {
+	if name == "if" {
+		return true
+	}
+	if _, ok := LookupFunction(name); ok {
+		return true
+	}
+	_, ok := funcs[name]
+	return ok
+}
+
+// apply builds the call of the function named by name with args, as the
+// infix parser does for name(args...). lparen and rparen are the positions
+// of the parentheses around the call, NoPos in RPN.
+func (p *Parser) apply(name token, args []Node, lparen, rparen Pos) (Node, error) {
+	wrongArity := func(err error) error {
+		return &SyntaxError{Code: CodeWrongArity, Message: err.Error(), Offset: name.pos, Length: len(name.text)}
+	}
+
+	if name.text == "if" {
+		if len(args) != 3 {
+			return nil, wrongArity(fmt.Errorf("function if expects 3 argument(s), got %d", len(args)))
+		}
+		return &CondExpr{If: name.pos, Cond: args[0], Question: lparen, Then: args[1], Colon: NoPos, Else: args[2], Rparen: rparen}, nil
+	}
+	if fn, ok := LookupFunction(name.text); ok {
+		if err := fn.checkArity(len(args)); err != nil {
+			return nil, wrongArity(err)
+		}
+		return &CallExpr{NamePos: name.pos, Name: name.text, Lparen: lparen, Args: args, Rparen: rparen}, nil
+	}
+
+	def, ok := p.funcs[name.text]
+	if !ok {
+		return nil, &SyntaxError{
+			Code:    CodeUnknownFunction,
+			Message: fmt.Sprintf("%s '%s'", constants.ErrUnknownFunction, name.text),
+			Offset:  name.pos,
+			Length:  len(name.text),
+		}
+	}
+	if len(args) != len(def.Params) {
+		return nil, wrongArity(fmt.Errorf("function %s expects %d argument(s), got %d", def.Name, len(def.Params), len(args)))
+	}
+	body, err := p.expand(def, args)
+	if err != nil {
+		return nil, &SyntaxError{
+			Code:    CodeInvalidCall,
+			Message: fmt.Sprintf("%v in call of %s", err, def.Name),
+			Offset:  name.pos,
+			Length:  len(name.text),
+		}
+	}
+	return &UserCall{NamePos: name.pos, Name: name.text, Lparen: lparen, Args: args, Rparen: rparen, Body: body}, nil
+}
@@ -1,84 +1,242 @@
-// Package calculation provides functions to tokenize mathematical expressions.
+// Package calculation provides functions to tokenize, parse and evaluate mathematical expressions.
 package calculation
 
 import (
+	"fmt"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
 )
 
+// tokenKind classifies tokens produced by tokenize.
+type tokenKind int
+
+const (
+	tokenNumber    tokenKind = iota // Numeric literal.
+	tokenImag                       // Imaginary literal: a number immediately followed by i.
+	tokenOperator                   // One of the operators accepted by isOperator, including the word xor.
+	tokenLParen                     // "(".
+	tokenRParen                     // ")".
+	tokenIdent                      // Identifier: a function or variable name.
+	tokenComma                      // ",".
+	tokenLBrack                     // "[".
+	tokenRBrack                     // "]".
+	tokenQuestion                   // "?" of a conditional expression.
+	tokenColon                      // ":" of a conditional expression.
+	tokenAssign                     // "=" of a binding.
+	tokenSemicolon                  // ";" ending a statement.
+)
+
+// token is a lexical unit of an expression.
+type token struct {
+	kind tokenKind // Kind of the token.
+	text string    // Token text as written in the source.
+	pos  Pos       // Position of the first character of the token.
+}
+
 // tokenize splits an expression string into tokens.
-func tokenize(expression string) []string {
-	var tokens []string
-	var number strings.Builder
-	var lastWasNumber bool
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
 
 	for i := 0; i < len(expression); i++ {
 		char := rune(expression[i])
-		switch char {
-		case ' ', '\t':
-			if number.Len() > 0 {
-				tokens = append(tokens, number.String())
-				number.Reset()
-				lastWasNumber = true
-			}
+		switch {
+		case char == ' ' || char == '\t':
 			continue
-		case '+', '-', '*', '/', '%', '^', '(', ')':
-			if number.Len() > 0 {
-				tokens = append(tokens, number.String())
-				number.Reset()
-				lastWasNumber = true
+		case char == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: Pos(i)})
+		case char == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: Pos(i)})
+		case char == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: Pos(i)})
+		case char == '[':
+			tokens = append(tokens, token{kind: tokenLBrack, text: "[", pos: Pos(i)})
+		case char == ']':
+			tokens = append(tokens, token{kind: tokenRBrack, text: "]", pos: Pos(i)})
+		case char == '?':
+			tokens = append(tokens, token{kind: tokenQuestion, text: "?", pos: Pos(i)})
+		case char == ':':
+			tokens = append(tokens, token{kind: tokenColon, text: ":", pos: Pos(i)})
+		case i+1 < len(expression) && isOperator(expression[i:i+2]):
+			tokens = append(tokens, token{kind: tokenOperator, text: expression[i : i+2], pos: Pos(i)})
+			i++
+		case char == '=':
+			tokens = append(tokens, token{kind: tokenAssign, text: "=", pos: Pos(i)})
+		case char == ';':
+			tokens = append(tokens, token{kind: tokenSemicolon, text: ";", pos: Pos(i)})
+		case isIdentStart(char):
+			j := i
+			for j < len(expression) && (isIdentStart(rune(expression[j])) || isDigit(rune(expression[j]))) {
+				j++
 			}
-			if char == '-' {
-				if i == 0 || expression[i-1] == '(' || isOperator(string(expression[i-1])) {
-					tokens = append(tokens, "-")
-					continue
-				}
+			kind := tokenIdent
+			if isOperator(expression[i:j]) {
+				kind = tokenOperator
 			}
-			if lastWasNumber && char == '(' {
-				return nil
+			tokens = append(tokens, token{kind: kind, text: expression[i:j], pos: Pos(i)})
+			i = j - 1
+		case isOperator(string(char)):
+			tokens = append(tokens, token{kind: tokenOperator, text: string(char), pos: Pos(i)})
+		case isDigit(char) || char == '.':
+			j := scanNumber(expression, i)
+			text := expression[i:j]
+			if !isNumber(text) {
+				return nil, &SyntaxError{Code: CodeInvalidNumber, Message: fmt.Sprintf("invalid number %q", text), Offset: Pos(i), Length: len(text)}
 			}
-			tokens = append(tokens, string(char))
-			lastWasNumber = false
-		default:
-			if lastWasNumber && number.Len() == 0 {
-				return nil
-			}
-			if char == '.' {
-				if strings.Contains(number.String(), ".") {
-					return nil
-				}
-			}
-			if !isDigit(char) && char != '.' {
-				return nil
+			kind := tokenNumber
+			if j < len(expression) && expression[j] == 'i' &&
+				(j+1 == len(expression) || !(isIdentStart(rune(expression[j+1])) || isDigit(rune(expression[j+1])))) {
+				kind = tokenImag
+				text = expression[i : j+1]
+				j++
 			}
-			number.WriteRune(char)
-			lastWasNumber = false
+			tokens = append(tokens, token{kind: kind, text: text, pos: Pos(i)})
+			i = j - 1
+		default:
+			return nil, &SyntaxError{Code: CodeUnexpectedCharacter, Message: fmt.Sprintf("unexpected character '%c'", char), Offset: Pos(i), Length: 1}
 		}
 	}
 
-	if number.Len() > 0 {
-		tokens = append(tokens, number.String())
-	}
-
-	return tokens
+	return tokens, nil
 }
 
 // isOperator checks if a token is a valid operator.
 func isOperator(token string) bool {
 	switch token {
-	case "+", "-", "*", "/", "%", "^":
+	case "+", "-", "*", "/", "%", "^",
+		"<", "<=", ">", ">=", "==", "!=", "&&", "||", "!",
+		"&", "|", "xor", "<<", ">>", "~", "//":
 		return true
 	}
 	return false
 }
 
+// scanNumber returns the end of the numeric literal starting at i: decimal
+// digits with an optional fraction and exponent (1.5e-9), or an integer
+// with a 0x, 0b or 0o prefix (0xFF). Digits may be grouped with
+// underscores (1_000_000); whether they are is checked by isNumber.
+func scanNumber(s string, i int) int {
+	j := i
+	if _, ok := numberBase(s[i:]); ok {
+		j += 2
+		for j < len(s) && (isHexDigit(s[j]) || s[j] == '_') {
+			j++
+		}
+		return j
+	}
+	for j < len(s) && (isDigit(rune(s[j])) || s[j] == '.' || s[j] == '_') {
+		j++
+	}
+	// e is taken as an exponent only if digits follow it: 2e is 2 and e.
+	if j < len(s) && (s[j] == 'e' || s[j] == 'E') {
+		k := j + 1
+		if k < len(s) && (s[k] == '+' || s[k] == '-') {
+			k++
+		}
+		if k < len(s) && isDigit(rune(s[k])) {
+			for k < len(s) && (isDigit(rune(s[k])) || s[k] == '_') {
+				k++
+			}
+			j = k
+		}
+	}
+	return j
+}
+
+// numberBase returns the base of an integer literal with a 0x, 0b or 0o
+// prefix.
+func numberBase(s string) (int, bool) {
+	if len(s) < 2 || s[0] != '0' {
+		return 0, false
+	}
+	switch s[1] {
+	case 'x', 'X':
+		return 16, true
+	case 'b', 'B':
+		return 2, true
+	case 'o', 'O':
+		return 8, true
+	}
+	return 0, false
+}
+
 // isNumber checks if a string represents a valid number.
 func isNumber(s string) bool {
-	_, err := strconv.ParseFloat(s, 64)
+	_, _, err := parseNumber(s)
 	return err == nil
 }
 
+// parseNumber returns the value of a numeric literal and the literal as a
+// plain decimal number: without a base prefix, digit separators and
+// leading zeros. The decimal form is exact, so the modes of precise
+// arithmetic read it instead of the literal.
+func parseNumber(s string) (float64, string, error) {
+	// strconv.ParseFloat would also take a sign, inf and nan.
+	if s == "" || !(isDigit(rune(s[0])) || s[0] == '.') {
+		return 0, "", fmt.Errorf("invalid number %q", s)
+	}
+	base, prefixed := numberBase(s)
+	digits := s
+	if prefixed {
+		digits = s[2:]
+	}
+	// An underscore may only stand between two digits.
+	for k := 0; k < len(digits); k++ {
+		if digits[k] == '_' && (k == 0 || k == len(digits)-1 || !isDigitIn(digits[k-1], base) || !isDigitIn(digits[k+1], base)) {
+			return 0, "", fmt.Errorf("misplaced digit separator in %q", s)
+		}
+	}
+	digits = strings.ReplaceAll(digits, "_", "")
+
+	if prefixed {
+		n, ok := new(big.Int).SetString(digits, base)
+		if !ok || n.Sign() < 0 {
+			return 0, "", fmt.Errorf("invalid number %q", s)
+		}
+		f, _ := new(big.Float).SetInt(n).Float64()
+		if math.IsInf(f, 0) {
+			return 0, "", fmt.Errorf("number %q out of range", s)
+		}
+		return f, n.String(), nil
+	}
+
+	f, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	// big.Rat.SetString would read 010 as octal.
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" || !isDigit(rune(digits[0])) {
+		digits = "0" + digits
+	}
+	return f, digits, nil
+}
+
+// isIdentStart checks if a rune can start an identifier.
+func isIdentStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
 // isDigit checks if a rune is a digit.
 func isDigit(c rune) bool {
 	return c >= '0' && c <= '9'
 }
+
+// isHexDigit checks if a byte is a hexadecimal digit.
+func isHexDigit(c byte) bool {
+	return isDigit(rune(c)) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// isDigitIn checks if a byte is a digit in base; base 0 means decimal.
+func isDigitIn(c byte, base int) bool {
+	switch base {
+	case 16:
+		return isHexDigit(c)
+	case 8:
+		return c >= '0' && c <= '7'
+	case 2:
+		return c == '0' || c == '1'
+	}
+	return isDigit(rune(c))
+}
@@ -0,0 +1,438 @@
+package calculation
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// Dimension holds the exponents of the SI base units kg, m, s, A, K, mol and cd.
+type Dimension [7]int
+
+// baseSymbols are the SI base units in the order of Dimension.
+var baseSymbols = [7]string{"kg", "m", "s", "A", "K", "mol", "cd"}
+
+// Unit is a unit of measurement: Factor base SI units of dimension Dim.
+type Unit struct {
+	Factor float64
+	Dim    Dimension
+}
+
+// IsZero reports whether the dimension is dimensionless.
+func (d Dimension) IsZero() bool { return d == Dimension{} }
+
+func (d Dimension) add(o Dimension) Dimension {
+	for i := range d {
+		d[i] += o[i]
+	}
+	return d
+}
+
+func (d Dimension) sub(o Dimension) Dimension {
+	for i := range d {
+		d[i] -= o[i]
+	}
+	return d
+}
+
+// pow multiplies every exponent by e. It fails if a result is not an integer.
+func (d Dimension) pow(e float64) (Dimension, bool) {
+	for i := range d {
+		x := float64(d[i]) * e
+		if x != math.Trunc(x) {
+			return d, false
+		}
+		d[i] = int(x)
+	}
+	return d, true
+}
+
+// String returns the canonical name of the dimension: a named SI unit such
+// as N or V when one matches, otherwise a product of base units such as
+// "m/s" or "kg*m^2*s^-3*A^-1". The result is accepted by ParseUnit.
+// Dimensionless values have an empty name.
+func (d Dimension) String() string {
+	for _, name := range namedUnits {
+		if units[name].Dim == d {
+			return name
+		}
+	}
+
+	var num []string
+	var den []int
+	for i, e := range d {
+		switch {
+		case e > 0:
+			num = append(num, unitPower(baseSymbols[i], e))
+		case e < 0:
+			den = append(den, i)
+		}
+	}
+
+	// A single unit in the denominator reads best after "/", as in m/s;
+	// otherwise negative exponents keep the name unambiguous.
+	if len(num) > 0 && len(den) == 1 {
+		return strings.Join(num, "*") + "/" + unitPower(baseSymbols[den[0]], -d[den[0]])
+	}
+	for _, i := range den {
+		num = append(num, unitPower(baseSymbols[i], d[i]))
+	}
+	return strings.Join(num, "*")
+}
+
+// describe names a dimension in error messages.
+func (d Dimension) describe() string {
+	if d.IsZero() {
+		return "dimensionless"
+	}
+	return d.String()
+}
+
+// unitPower formats a unit raised to the power e.
+func unitPower(symbol string, e int) string {
+	if e == 1 {
+		return symbol
+	}
+	return symbol + "^" + strconv.Itoa(e)
+}
+
+// dims builds a Dimension from exponents of kg, m, s and A.
+func dims(kg, m, s, a int) Dimension {
+	return Dimension{kg, m, s, a}
+}
+
+// unitDef is an entry of the unit registry.
+type unitDef struct {
+	Unit
+	prefixable bool // Whether SI prefixes such as k or m may be applied.
+}
+
+// units is the registry of units available in expressions.
+var units = map[string]unitDef{
+	// SI base units. The kilogram is registered as the gram so that
+	// prefixes apply to it like to any other unit.
+	"g":   {Unit{1e-3, dims(1, 0, 0, 0)}, true},
+	"m":   {Unit{1, dims(0, 1, 0, 0)}, true},
+	"s":   {Unit{1, dims(0, 0, 1, 0)}, true},
+	"A":   {Unit{1, dims(0, 0, 0, 1)}, true},
+	"K":   {Unit{1, Dimension{0, 0, 0, 0, 1}}, true},
+	"mol": {Unit{1, Dimension{0, 0, 0, 0, 0, 1}}, true},
+	"cd":  {Unit{1, Dimension{0, 0, 0, 0, 0, 0, 1}}, true},
+
+	// Derived SI units.
+	"N":   {Unit{1, dims(1, 1, -2, 0)}, true},
+	"J":   {Unit{1, dims(1, 2, -2, 0)}, true},
+	"W":   {Unit{1, dims(1, 2, -3, 0)}, true},
+	"Pa":  {Unit{1, dims(1, -1, -2, 0)}, true},
+	"Hz":  {Unit{1, dims(0, 0, -1, 0)}, true},
+	"C":   {Unit{1, dims(0, 0, 1, 1)}, true},
+	"V":   {Unit{1, dims(1, 2, -3, -1)}, true},
+	"ohm": {Unit{1, dims(1, 2, -3, -2)}, true},
+	"F":   {Unit{1, dims(-1, -2, 4, 2)}, true},
+	"H":   {Unit{1, dims(1, 2, -2, -2)}, true},
+
+	// Other units in common use.
+	"L":   {Unit{1e-3, dims(0, 3, 0, 0)}, true},
+	"Wh":  {Unit{3600, dims(1, 2, -2, 0)}, true},
+	"bar": {Unit{1e5, dims(1, -1, -2, 0)}, false},
+	"min": {Unit{60, dims(0, 0, 1, 0)}, false},
+	"h":   {Unit{3600, dims(0, 0, 1, 0)}, false},
+	"rad": {Unit{1, Dimension{}}, false},
+	"deg": {Unit{math.Pi / 180, Dimension{}}, false},
+}
+
+// namedUnits are the derived units String prefers over a product of base units.
+var namedUnits = []string{"N", "J", "W", "Pa", "Hz", "C", "V", "ohm", "F", "H"}
+
+// prefixes are the SI prefixes that can be applied to prefixable units.
+var prefixes = map[string]float64{
+	"G": 1e9,
+	"M": 1e6,
+	"k": 1e3,
+	"c": 1e-2,
+	"m": 1e-3,
+	"u": 1e-6,
+	"n": 1e-9,
+	"p": 1e-12,
+}
+
+// LookupUnit returns a unit by its symbol, such as "m", "km" or "kWh".
+// Exact symbols take precedence over prefixed ones, so "min" is a minute
+// and "mm" is a millimetre.
+func LookupUnit(symbol string) (Unit, bool) {
+	if def, ok := units[symbol]; ok {
+		return def.Unit, true
+	}
+
+	for prefix, factor := range prefixes {
+		name, ok := strings.CutPrefix(symbol, prefix)
+		if !ok {
+			continue
+		}
+		if def, ok := units[name]; ok && def.prefixable {
+			return Unit{Factor: factor * def.Factor, Dim: def.Dim}, true
+		}
+	}
+	return Unit{}, false
+}
+
+// ParseUnit parses a compound unit such as "km/h", "m*s^-2" or "kg*m^2/s^2".
+// Units are combined left to right, so "J/kg*K" is (J/kg)*K.
+func ParseUnit(text string) (Unit, error) {
+	result := Unit{Factor: 1}
+	if strings.TrimSpace(text) == "" {
+		return result, nil
+	}
+
+	op := byte('*')
+	for i := 0; i < len(text); {
+		j := i
+		for j < len(text) && (isIdentStart(rune(text[j])) || isDigit(rune(text[j]))) {
+			j++
+		}
+		symbol := text[i:j]
+		unit, ok := LookupUnit(symbol)
+		if !ok {
+			return Unit{}, fmt.Errorf("%s %q", constants.ErrUnknownUnit, symbol)
+		}
+
+		exponent := 1
+		if j < len(text) && text[j] == '^' {
+			k := j + 1
+			if k < len(text) && text[k] == '-' {
+				k++
+			}
+			for k < len(text) && isDigit(rune(text[k])) {
+				k++
+			}
+			e, err := strconv.Atoi(text[j+1 : k])
+			if err != nil {
+				return Unit{}, fmt.Errorf("invalid exponent in unit %q", text)
+			}
+			exponent, j = e, k
+		}
+
+		if op == '/' {
+			exponent = -exponent
+		}
+		dim, _ := unit.Dim.pow(float64(exponent))
+		result.Dim = result.Dim.add(dim)
+		result.Factor *= math.Pow(unit.Factor, float64(exponent))
+
+		if j == len(text) {
+			break
+		}
+		if text[j] != '*' && text[j] != '/' {
+			return Unit{}, fmt.Errorf("invalid unit %q", text)
+		}
+		op = text[j]
+		i = j + 1
+		if i == len(text) {
+			return Unit{}, fmt.Errorf("invalid unit %q", text)
+		}
+	}
+
+	return result, nil
+}
+
+// ConvertUnit parses the unit target and checks that a value of dimension
+// dim can be expressed in it.
+func ConvertUnit(dim Dimension, target string) (Unit, error) {
+	unit, err := ParseUnit(target)
+	if err != nil {
+		return Unit{}, err
+	}
+	if unit.Dim != dim {
+		return Unit{}, fmt.Errorf("%s: cannot convert %s to %s", constants.ErrIncompatibleUnits, dim.describe(), target)
+	}
+	return unit, nil
+}
+
+// Dimensions checks that the tree rooted at node is dimensionally
+// consistent and returns the dimension of its value. Variables and plain
+// numbers are dimensionless. Exponents applied to quantities are evaluated
+// with vars, because the dimension of x^n depends on the value of n.
+func Dimensions(node Node, vars Variables) (Dimension, error) {
+	return dimensions(node, vars, make(map[*Binding]Dimension))
+}
+
+// dimensions is Dimensions with the dimensions of the bindings of a script
+// checked so far.
+func dimensions(node Node, vars Variables, bound map[*Binding]Dimension) (Dimension, error) {
+	switch n := node.(type) {
+	case *NumberLit, *ImagLit, *Ident:
+		return Dimension{}, nil
+	case *QuantityExpr:
+		return n.Unit.Dim, nil
+	case *ParenExpr:
+		return dimensions(n.X, vars, bound)
+	case *Script:
+		for _, binding := range n.Bindings {
+			d, err := dimensions(binding.Value, vars, bound)
+			if err != nil {
+				return Dimension{}, err
+			}
+			bound[binding] = d
+		}
+		return dimensions(n.Result, vars, bound)
+	case *UserCall:
+		return dimensions(n.Body, vars, bound)
+	case *Ref:
+		if d, ok := bound[n.Binding]; ok {
+			return d, nil
+		}
+		return dimensions(n.Binding.Value, vars, bound)
+	case *ListExpr:
+		var dim Dimension
+		for i, elem := range n.Elems {
+			d, err := dimensions(elem, vars, bound)
+			if err != nil {
+				return Dimension{}, err
+			}
+			if i > 0 && d != dim {
+				return Dimension{}, fmt.Errorf("%s %s and %s in the list at position %d",
+					constants.ErrIncompatibleUnits, dim.describe(), d.describe(), n.Lbrack)
+			}
+			dim = d
+		}
+		return dim, nil
+	case *UnaryExpr:
+		d, err := dimensions(n.X, vars, bound)
+		switch {
+		case err != nil:
+			return Dimension{}, err
+		case n.Op == "!":
+			// !x is a plain 1 or 0 whatever the unit of x.
+			return Dimension{}, nil
+		case n.Op == "~" && !d.IsZero():
+			return Dimension{}, fmt.Errorf("bitwise operator '~' at position %d needs a dimensionless operand, got %s", n.OpPos, d.describe())
+		}
+		return d, nil
+	case *CondExpr:
+		if _, err := dimensions(n.Cond, vars, bound); err != nil {
+			return Dimension{}, err
+		}
+		then, err := dimensions(n.Then, vars, bound)
+		if err != nil {
+			return Dimension{}, err
+		}
+		els, err := dimensions(n.Else, vars, bound)
+		if err != nil {
+			return Dimension{}, err
+		}
+		if then != els {
+			return Dimension{}, fmt.Errorf("%s %s and %s in the branches of the conditional at position %d",
+				constants.ErrIncompatibleUnits, then.describe(), els.describe(), n.Pos())
+		}
+		return then, nil
+	case *BinaryExpr:
+		left, err := dimensions(n.Left, vars, bound)
+		if err != nil {
+			return Dimension{}, err
+		}
+		right, err := dimensions(n.Right, vars, bound)
+		if err != nil {
+			return Dimension{}, err
+		}
+
+		switch n.Op {
+		case "+", "-", "%":
+			if left != right {
+				return Dimension{}, fmt.Errorf("%s %s and %s for '%s' at position %d",
+					constants.ErrIncompatibleUnits, left.describe(), right.describe(), n.Op, n.OpPos)
+			}
+			return left, nil
+		case "<", "<=", ">", ">=", "==", "!=":
+			if left != right {
+				return Dimension{}, fmt.Errorf("%s %s and %s for '%s' at position %d",
+					constants.ErrIncompatibleUnits, left.describe(), right.describe(), n.Op, n.OpPos)
+			}
+			return Dimension{}, nil
+		case "&&", "||":
+			// Any quantity can be tested for zero; the outcome is a plain 1 or 0.
+			return Dimension{}, nil
+		case "&", "|", "xor", "<<", ">>":
+			if !left.IsZero() || !right.IsZero() {
+				return Dimension{}, fmt.Errorf("bitwise operator '%s' at position %d needs dimensionless operands, got %s and %s",
+					n.Op, n.OpPos, left.describe(), right.describe())
+			}
+			return Dimension{}, nil
+		case "*":
+			return left.add(right), nil
+		case "/", "//":
+			return left.sub(right), nil
+		case "^":
+			if !right.IsZero() {
+				return Dimension{}, fmt.Errorf("exponent at position %d must be dimensionless, got %s", n.Right.Pos(), right.describe())
+			}
+			if left.IsZero() {
+				return left, nil
+			}
+			e, err := EvaluateWith(n.Right, vars)
+			if err != nil {
+				return Dimension{}, err
+			}
+			d, ok := left.pow(e)
+			if !ok {
+				return Dimension{}, fmt.Errorf("cannot raise %s to the power %v at position %d", left.describe(), e, n.OpPos)
+			}
+			return d, nil
+		default:
+			return left, nil
+		}
+	case *CallExpr:
+		args := make([]Dimension, len(n.Args))
+		for i, arg := range n.Args {
+			d, err := dimensions(arg, vars, bound)
+			if err != nil {
+				return Dimension{}, err
+			}
+			args[i] = d
+		}
+		return callDimension(n, args)
+	default:
+		return Dimension{}, fmt.Errorf("unsupported node %T", node)
+	}
+}
+
+// callDimension returns the dimension of a function call result.
+func callDimension(call *CallExpr, args []Dimension) (Dimension, error) {
+	switch call.Name {
+	case "sqrt":
+		d, ok := args[0].pow(0.5)
+		if !ok {
+			return Dimension{}, fmt.Errorf("cannot take the square root of %s at position %d", args[0].describe(), call.NamePos)
+		}
+		return d, nil
+	case "abs", "round", "transpose":
+		return args[0], nil
+	case "dot", "matmul":
+		return args[0].add(args[1]), nil
+	case "percentile":
+		if rank := args[len(args)-1]; !rank.IsZero() {
+			return Dimension{}, fmt.Errorf("function percentile expects a dimensionless rank, got %s at position %d",
+				rank.describe(), call.NamePos)
+		}
+		args = args[:len(args)-1]
+		fallthrough
+	case "min", "max", "sum", "mean", "median", "stddev":
+		for _, d := range args[1:] {
+			if d != args[0] {
+				return Dimension{}, fmt.Errorf("%s %s and %s in %s at position %d",
+					constants.ErrIncompatibleUnits, args[0].describe(), d.describe(), call.Name, call.NamePos)
+			}
+		}
+		return args[0], nil
+	default:
+		for _, d := range args {
+			if !d.IsZero() {
+				return Dimension{}, fmt.Errorf("function %s expects a dimensionless argument, got %s at position %d",
+					call.Name, d.describe(), call.NamePos)
+			}
+		}
+		return Dimension{}, nil
+	}
+}
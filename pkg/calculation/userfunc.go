@@ -0,0 +1,191 @@
+package calculation
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/structxz/calc_v3/internal/constants"
+)
+
+// MaxUserCalls limits the number of calls of user-defined functions one
+// expression may expand to. Every call is expanded in place, so functions
+// that call another function twice grow exponentially with nesting.
+const MaxUserCalls = 1000
+
+// Definition is a user-defined function, f(x, y) = x^2 + y.
+type Definition struct {
+	Name   string   // Function name.
+	Params []string // Parameter names in order.
+	Body   string   // Source text of the body.
+}
+
+// String returns the definition in the form it is written.
+func (d *Definition) String() string {
+	return fmt.Sprintf("%s(%s) = %s", d.Name, strings.Join(d.Params, ", "), d.Body)
+}
+
+// Definitions maps names to the user-defined functions expressions can call.
+type Definitions map[string]*Definition
+
+// ParseDefinition parses a definition such as "f(x, y) = x^2 + y". The
+// name must not be a built-in function, the parameters must be distinct
+// and the body may use only the parameters and call built-in functions and
+// funcs. A body that calls the function being defined, directly or
+// through other functions, is rejected as recursive.
+func ParseDefinition(text string, funcs Definitions) (*Definition, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &Parser{tokens: tokens, end: Pos(len(text))}
+
+	name, err := p.expect(tokenIdent, "function name")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := LookupFunction(name.text); ok || name.text == SelectOp {
+		return nil, &SyntaxError{
+			Code:    CodeInvalidDefinition,
+			Message: fmt.Sprintf("%s %s", constants.ErrBuiltinFunction, name.text),
+			Offset:  name.pos,
+			Length:  len(name.text),
+		}
+	}
+	if _, err := p.expect(tokenLParen, "'('"); err != nil {
+		return nil, err
+	}
+
+	def := &Definition{Name: name.text}
+	for p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenRParen {
+		if len(def.Params) > 0 {
+			if _, err := p.expect(tokenComma, "','", "')'"); err != nil {
+				return nil, err
+			}
+		}
+		param, err := p.expect(tokenIdent, "parameter name")
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(def.Params, param.text) {
+			return nil, &SyntaxError{
+				Code:    CodeInvalidDefinition,
+				Message: fmt.Sprintf("%s %s", constants.ErrDuplicateParameter, param.text),
+				Offset:  param.pos,
+				Length:  len(param.text),
+			}
+		}
+		def.Params = append(def.Params, param.text)
+	}
+	p.pos++
+	assign, err := p.expect(tokenAssign, "'='")
+	if err != nil {
+		return nil, err
+	}
+	def.Body = strings.TrimSpace(text[assign.pos+1:])
+
+	// The body is checked by expanding a call of the new function with the
+	// parameters as arguments, which also expands the functions it calls.
+	withDef := make(Definitions, len(funcs)+1)
+	for n, d := range funcs {
+		withDef[n] = d
+	}
+	withDef[def.Name] = def
+
+	args := make([]Node, len(def.Params))
+	for i, param := range def.Params {
+		args[i] = &Ident{NamePos: NoPos, Name: param}
+	}
+	body, err := (&Parser{funcs: withDef, calls: new(int)}).expand(def, args)
+	if err != nil {
+		return nil, fmt.Errorf("%w in the body of %s", err, def.Name)
+	}
+	for _, variable := range FreeVariables(body) {
+		if !slices.Contains(def.Params, variable) {
+			return nil, fmt.Errorf("%s %s in function %s", constants.ErrUndeclaredParameter, variable, def.Name)
+		}
+	}
+	return def, nil
+}
+
+// expect consumes the next token, which must be of the given kind;
+// expected describes it for the error.
+func (p *Parser) expect(kind tokenKind, expected ...string) (token, error) {
+	if p.pos >= len(p.tokens) {
+		return token{}, &SyntaxError{Code: CodeUnexpectedEnd, Message: constants.ErrUnexpectedEndExpr, Offset: p.end, Expected: expected}
+	}
+	tok := p.tokens[p.pos]
+	if tok.kind != kind {
+		return token{}, unexpected(tok, expected...)
+	}
+	p.pos++
+	return tok, nil
+}
+
+// parseUserCall parses the arguments of a call of a user-defined function,
+// starting at "(", and expands the call.
+func (p *Parser) parseUserCall(name token, def *Definition) (Node, error) {
+	call := &UserCall{NamePos: name.pos, Name: name.text, Lparen: p.tokens[p.pos].pos}
+
+	var err error
+	if call.Args, call.Rparen, err = p.parseArgs(); err != nil {
+		return nil, err
+	}
+	if len(call.Args) != len(def.Params) {
+		return nil, &SyntaxError{
+			Code:    CodeWrongArity,
+			Message: fmt.Sprintf("function %s expects %d argument(s), got %d", def.Name, len(def.Params), len(call.Args)),
+			Offset:  name.pos,
+			Length:  len(name.text),
+		}
+	}
+	if call.Body, err = p.expand(def, call.Args); err != nil {
+		if len(p.calling) > 0 {
+			return nil, err
+		}
+		// Positions in the error are those of the body, so the error points
+		// at the call instead.
+		return nil, &SyntaxError{
+			Code:    CodeInvalidCall,
+			Message: fmt.Sprintf("%v in call of %s", err, def.Name),
+			Offset:  name.pos,
+			Length:  len(name.text),
+		}
+	}
+	return call, nil
+}
+
+// expand parses the body of def with its parameters bound to args.
+func (p *Parser) expand(def *Definition, args []Node) (*Script, error) {
+	if slices.Contains(p.calling, def.Name) {
+		chain := append(slices.Clone(p.calling), def.Name)
+		return nil, fmt.Errorf("%s %s", constants.ErrRecursiveFunction, strings.Join(chain, " -> "))
+	}
+	if *p.calls++; *p.calls > MaxUserCalls {
+		return nil, fmt.Errorf("%s (%d)", constants.ErrTooManyUserCalls, MaxUserCalls)
+	}
+
+	tokens, err := tokenize(def.Body)
+	if err != nil {
+		return nil, err
+	}
+	body := &Parser{
+		tokens:   tokens,
+		end:      Pos(len(def.Body)),
+		bindings: make(map[string]*Binding, len(def.Params)),
+		funcs:    p.funcs,
+		calling:  append(slices.Clone(p.calling), def.Name),
+		calls:    p.calls,
+	}
+
+	script := &Script{}
+	for i, param := range def.Params {
+		binding := &Binding{NamePos: NoPos, Name: param, Value: args[i], Semi: NoPos}
+		body.bindings[param] = binding
+		script.Bindings = append(script.Bindings, binding)
+	}
+	if script.Result, err = body.parse(); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
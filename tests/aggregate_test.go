@@ -0,0 +1,111 @@
+package test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregates(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected float64
+		wantErr  string
+	}{
+		{name: "sum of numbers", expr: "sum(1, 2, 3, 4, 5)", expected: 15},
+		{name: "sum of arrays", expr: "sum([1, 2], [[3, 4], [5, 6]])", expected: 21},
+		{name: "mean", expr: "mean(1, 2, 3, 4)", expected: 2.5},
+		{name: "median of odd count", expr: "median(5, 1, x)", expected: 3},
+		{name: "median of even count", expr: "median([4, 1, 3, 2])", expected: 2.5},
+		{name: "population stddev", expr: "stddev(2, 4, 4, 4, 5, 5, 7, 9)", expected: 2},
+		{name: "interpolated percentile", expr: "percentile([1, 2, 3, 4, 5], 95)", expected: 4.8},
+		{name: "extreme percentiles", expr: "percentile([3, 1, 2], 0) + percentile([3, 1, 2], 100)", expected: 4},
+		{name: "variable rank", expr: "percentile([1, 2], x)", wantErr: "function percentile expects a constant rank between 0 and 100 at position 19"},
+		{name: "rank out of range", expr: "percentile([1, 2], 101)", wantErr: "function percentile expects a constant rank between 0 and 100 at position 19"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			got, err := calculation.EvaluateWith(tree, calculation.Variables{"x": 3})
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, got, 1e-12)
+		})
+	}
+}
+
+// depthAlgebra measures the longest chain of dependent operations, which
+// bounds how fast agents can compute an expression however many there are.
+type depthAlgebra struct {
+	ops int
+}
+
+func (a *depthAlgebra) Leaf(calculation.Node) (int, error) { return 0, nil }
+func (a *depthAlgebra) Negate(x int) (int, error)          { return x, nil }
+
+func (a *depthAlgebra) Binary(_ string, x, y int) (int, error) {
+	a.ops++
+	return max(x, y) + 1, nil
+}
+
+func (a *depthAlgebra) Call(_ *calculation.Function, args []int) (int, error) {
+	a.ops++
+	return slices.Max(args) + 1, nil
+}
+
+func (a *depthAlgebra) Truth(int) (bool, bool) { return false, false }
+
+func (a *depthAlgebra) Branch(_ int, _ bool, fold func() (calculation.Array[int], error)) (calculation.Array[int], error) {
+	return fold()
+}
+
+func (a *depthAlgebra) Select(cond, x, y int) (int, error) {
+	a.ops++
+	return max(cond, x, y) + 1, nil
+}
+
+func TestAggregateReductionTree(t *testing.T) {
+	t.Parallel()
+
+	values := make([]string, 1000)
+	for i := range values {
+		values[i] = fmt.Sprint(i)
+	}
+	tree, err := calculation.Parse("sum(" + strings.Join(values, ", ") + ")")
+	require.NoError(t, err)
+
+	alg := &depthAlgebra{}
+	depth, err := calculation.Fold[int](tree, alg)
+	require.NoError(t, err)
+	assert.Equal(t, 999, alg.ops, "n values need n-1 additions")
+	assert.Equal(t, 10, depth.Elems[0], "additions form a balanced tree")
+}
+
+func TestMedianSelectsOnlyNeededComparisons(t *testing.T) {
+	t.Parallel()
+
+	tree, err := calculation.Parse("median(a, b, c, d, e, f, g, h)")
+	require.NoError(t, err)
+
+	alg := &depthAlgebra{}
+	_, err = calculation.Fold[int](tree, alg)
+	require.NoError(t, err)
+	assert.Less(t, alg.ops, 2*19, "a full sorting network of 8 values has 19 comparators")
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateArray(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+		wantErr  string
+	}{
+		{name: "vector literal", expr: "[1, 2, 3]", expected: "[1,2,3]"},
+		{name: "matrix literal", expr: "[[1, 2], [3, 4]]", expected: "[[1,2],[3,4]]"},
+		{name: "element-wise sum", expr: "[1, 2] + [3, 4]", expected: "[4,6]"},
+		{name: "scalar broadcast", expr: "2 * [[1, 2], [3, 4]] - 1", expected: "[[1,3],[5,7]]"},
+		{name: "negation", expr: "-[1, x]", expected: "[-1,-2]"},
+		{name: "functions apply element-wise", expr: "sqrt([4, 9])", expected: "[2,3]"},
+		{name: "dot product", expr: "dot([1, 2, 3], [4, 5, 6])", expected: "32"},
+		{name: "matrix product", expr: "matmul([[1, 2], [3, 4]], [[5, 6], [7, 8]])", expected: "[[19,22],[43,50]]"},
+		{name: "matrix times vector", expr: "matmul([[1, 2], [3, 4]], [1, 1])", expected: "[3,7]"},
+		{name: "vector times matrix", expr: "matmul([1, 1], [[1, 2], [3, 4]])", expected: "[4,6]"},
+		{name: "transpose", expr: "transpose([[1, 2, 3], [4, 5, 6]])", expected: "[[1,4],[2,5],[3,6]]"},
+		{name: "determinant", expr: "det([[2, 0, 1], [1, 3, 2], [1, 1, 2]])", expected: "6"},
+		{name: "determinant of 4x4", expr: "det([[1, 2, 3, 4], [5, 6, 7, 8], [2, 6, 4, 8], [3, 1, 1, 2]])", expected: "72"},
+		{name: "determinant of 1x1", expr: "det([[7]])", expected: "7"},
+		{name: "shape mismatch", expr: "[1, 2] + [1, 2, 3]", wantErr: "shape mismatch: [2] and [3] for '+' at position 7"},
+		{name: "ragged matrix", expr: "[[1, 2], [3]]", wantErr: "shape mismatch: element at position 9 is a [1], expected [2]"},
+		{name: "too deep", expr: "[[[1]]]", wantErr: "list at position 0 nests deeper than a matrix"},
+		{name: "incompatible product", expr: "matmul([[1, 2]], [[1, 2]])", wantErr: "function matmul cannot multiply [1,2] by [1,2] at position 0"},
+		{name: "determinant of non-square matrix", expr: "det([[1, 2]])", wantErr: "function det expects a square matrix, got [1,2] at position 0"},
+		{name: "dot of numbers", expr: "dot(1, 2)", wantErr: "function dot expects two vectors of equal length, got number and number at position 0"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			got, err := calculation.EvaluateArray(tree, calculation.Variables{"x": 2})
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+			require.NoError(t, err)
+
+			encoded, err := json.Marshal(got.Nested())
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(encoded))
+		})
+	}
+}
+
+func TestShapeOf(t *testing.T) {
+	t.Parallel()
+
+	tree, err := calculation.Parse("matmul(transpose([[1, 2, 3], [4, 5, 6]]), [[1, 2], [3, 4]])")
+	require.NoError(t, err)
+
+	shape, err := calculation.ShapeOf(tree)
+	require.NoError(t, err)
+	assert.Equal(t, calculation.Shape{3, 2}, shape)
+
+	tree, err = calculation.Parse("1 + [1, 2]")
+	require.NoError(t, err)
+	_, err = calculation.EvaluateWith(tree, nil)
+	assert.EqualError(t, err, "expression evaluates to an array of shape [2], not a number")
+}
+
+func TestArraysOutsideFloatMode(t *testing.T) {
+	t.Parallel()
+
+	arith, err := calculation.NewArithmetic(calculation.ModeRational, 0)
+	require.NoError(t, err)
+
+	tree, err := calculation.Parse("1 + [1, 2]")
+	require.NoError(t, err)
+	assert.EqualError(t, arith.Check(tree), "array at position 4 is only supported in float mode")
+}
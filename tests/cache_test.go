@@ -0,0 +1,78 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/structxz/calc_v3/internal/app/models"
+	"github.com/structxz/calc_v3/internal/cache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheKey(t *testing.T) {
+	t.Parallel()
+
+	task := &models.Task{Operation: "*", Arg1: 12.5, Arg2: 8}
+	same := &models.Task{ID: "other", ExpressionID: "other", Operation: "*", Arg1: 12.5, Arg2: 8}
+	assert.Equal(t, cache.Key(task), cache.Key(same))
+
+	for _, other := range []*models.Task{
+		{Operation: "/", Arg1: 12.5, Arg2: 8},
+		{Operation: "*", Arg1: 8, Arg2: 12.5},
+		{Operation: "*", Arg1: 12.5, Arg2: 8, Precision: "decimal", Scale: 10, Arg1Exact: "12.5", Arg2Exact: "8"},
+		{Operation: "*", Arg1: 12.5, Arg2: 8, Precision: "decimal", Scale: 20, Arg1Exact: "12.5", Arg2Exact: "8"},
+	} {
+		assert.NotEqual(t, cache.Key(task), cache.Key(other), "%+v", other)
+	}
+}
+
+func TestCacheGetPut(t *testing.T) {
+	t.Parallel()
+
+	c := cache.New(2, time.Minute)
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+
+	c.Put("a", cache.Result{Value: 1})
+	c.Put("b", cache.Result{Value: 2, Exact: "2"})
+	got, ok := c.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, cache.Result{Value: 2, Exact: "2"}, got)
+
+	// "a" is the least recently used entry, so the third one evicts it.
+	c.Put("c", cache.Result{Value: 3})
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	_, ok = c.Get("c")
+	assert.True(t, ok)
+
+	assert.Equal(t, cache.Stats{Hits: 2, Misses: 2, Entries: 2, Capacity: 2, TTL: 60000}, c.Stats())
+}
+
+func TestCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	c := cache.New(10, 20*time.Millisecond)
+	c.Put("a", cache.Result{Value: 1})
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	time.Sleep(40 * time.Millisecond)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Zero(t, c.Stats().Entries)
+}
+
+func TestCacheDisabled(t *testing.T) {
+	t.Parallel()
+
+	c := cache.New(0, time.Minute)
+	for i := range 3 {
+		c.Put(fmt.Sprint(i), cache.Result{Value: float64(i)})
+	}
+	_, ok := c.Get("1")
+	assert.False(t, ok)
+	assert.Zero(t, c.Stats().Entries)
+}
@@ -238,6 +238,76 @@ func TestCalculator(t *testing.T) {
 			expr:     "((((1 + 2) * 3) - 4) / 5) * (-2)",
 			expected: -2,
 		},
+		{
+			name:     "square root",
+			expr:     "sqrt(16) + 1",
+			expected: 5,
+		},
+		{
+			name:     "nested functions",
+			expr:     "log(exp(2)) * abs(-3)",
+			expected: 6,
+		},
+		{
+			name:     "trigonometry",
+			expr:     "sin(0) + cos(0)",
+			expected: 1,
+		},
+		{
+			name:     "round half away from zero",
+			expr:     "round(2.5) + round(-2.5)",
+			expected: 0,
+		},
+		{
+			name:     "variadic max with expressions",
+			expr:     "max(1, 2 * 3, sqrt(16))",
+			expected: 6,
+		},
+		{
+			name:     "variadic min with one argument",
+			expr:     "min(7)",
+			expected: 7,
+		},
+		{
+			name:    "unknown function",
+			expr:    "foo(1)",
+			wantErr: true,
+		},
+		{
+			name:    "wrong number of arguments",
+			expr:    "sqrt(1, 2)",
+			wantErr: true,
+		},
+		{
+			name:    "no arguments",
+			expr:    "max()",
+			wantErr: true,
+		},
+		{
+			name:    "square root of negative number",
+			expr:    "sqrt(-4)",
+			wantErr: true,
+		},
+		{
+			name:    "logarithm of zero",
+			expr:    "log(0)",
+			wantErr: true,
+		},
+		{
+			name:    "function without parentheses",
+			expr:    "sqrt 4",
+			wantErr: true,
+		},
+		{
+			name:    "fractional power of negative number",
+			expr:    "(-8) ^ (1 / 3)",
+			wantErr: true,
+		},
+		{
+			name:    "imaginary literal in float mode",
+			expr:    "2 + 3i",
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -255,3 +325,53 @@ func TestCalculator(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculatorVariables(t *testing.T) {
+	t.Parallel()
+
+	vars := calculation.Variables{"rate": 12.5, "hours": 8, "fee": 3, "max": 2}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected float64
+		wantErr  string
+	}{
+		{
+			name:     "bound variables",
+			expr:     "rate * hours + fee",
+			expected: 103,
+		},
+		{
+			name:     "negated variable",
+			expr:     "-fee * 2",
+			expected: -6,
+		},
+		{
+			name:     "variable named like a function",
+			expr:     "max(max, 1)",
+			expected: 2,
+		},
+		{
+			name:    "unbound variable",
+			expr:    "rate * days",
+			wantErr: "unbound variable days at position 7",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := calculation.EvaluateExpressionWith(tt.expr, vars)
+
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, result, 1e-10)
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConditional(t *testing.T) {
+	t.Parallel()
+
+	tree, err := calculation.Parse("a < 1 || b >= 2 && !c ? 10 : d == 3 ? 20 : 30")
+	require.NoError(t, err)
+
+	cond, ok := tree.(*calculation.CondExpr)
+	require.True(t, ok, "the conditional operator has the lowest precedence")
+	assert.Equal(t, calculation.NoPos, cond.If)
+	assert.Equal(t, calculation.Pos(0), cond.Pos())
+	assert.Equal(t, calculation.Pos(45), cond.End())
+
+	or, ok := cond.Cond.(*calculation.BinaryExpr)
+	require.True(t, ok)
+	assert.Equal(t, "||", or.Op, "&& binds tighter than ||")
+	and, ok := or.Right.(*calculation.BinaryExpr)
+	require.True(t, ok)
+	assert.Equal(t, "&&", and.Op)
+	not, ok := and.Right.(*calculation.UnaryExpr)
+	require.True(t, ok)
+	assert.Equal(t, "!", not.Op)
+
+	_, ok = cond.Else.(*calculation.CondExpr)
+	assert.True(t, ok, "the conditional operator groups to the right")
+
+	tree, err = calculation.Parse("if(x > 0, x, -x)")
+	require.NoError(t, err)
+	cond, ok = tree.(*calculation.CondExpr)
+	require.True(t, ok)
+	assert.Equal(t, calculation.Pos(0), cond.Pos())
+	assert.Equal(t, calculation.Pos(16), cond.End())
+}
+
+func TestEvaluateConditional(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr     string
+		vars     calculation.Variables
+		expected float64
+	}{
+		{expr: "1 < 2", expected: 1},
+		{expr: "2 <= 1", expected: 0},
+		{expr: "1 + 1 == 2", expected: 1},
+		{expr: "3 != 3", expected: 0},
+		{expr: "!0 + !5", expected: 1},
+		{expr: "2 && 0 || 7", expected: 1},
+		{expr: "x > 100 ? x * 0.9 : x", vars: calculation.Variables{"x": 150}, expected: 135},
+		{expr: "x > 100 ? x * 0.9 : x", vars: calculation.Variables{"x": 50}, expected: 50},
+		{expr: "if(q < 10, 5, q < 100 ? 4 : 3) * q", vars: calculation.Variables{"q": 40}, expected: 160},
+		{expr: "max(if(1 > 2, 1, 2), 0)", expected: 2},
+		{expr: "x != 0 ? 10 / x : 0", vars: calculation.Variables{"x": 0}, expected: 0},
+		{expr: "sum(x > 0 ? [1, 2] : [3, 4])", vars: calculation.Variables{"x": -1}, expected: 7},
+		{expr: "x != 0 && y / x > 2", vars: calculation.Variables{"x": 0, "y": 5}, expected: 0},
+		{expr: "x == 0 || y / x > 2", vars: calculation.Variables{"x": 0, "y": 5}, expected: 1},
+		{expr: "x && y % x", vars: calculation.Variables{"x": 2, "y": 5}, expected: 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.expr, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			got, err := calculation.EvaluateWith(tree, tt.vars)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestConditionalErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "x ? [1, 2] : 3", wantErr: "shape mismatch: branches of the conditional at position 0 are [2] and number"},
+		{expr: "[1, 2] ? 1 : 2", wantErr: "shape mismatch: condition at position 0 is a [2], expected a number"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.expr, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			_, err = calculation.ShapeOf(tree)
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
+
+func TestExactConditional(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		mode     calculation.Mode
+		expr     string
+		expected string
+		wantErr  string
+	}{
+		{mode: calculation.ModeRational, expr: "1/3 + 1/3 + 1/3 == 1", expected: "1"},
+		{mode: calculation.ModeRational, expr: "1/3 < 0.3333 ? 1 : 2/3", expected: "2/3"},
+		{mode: calculation.ModeDecimal, expr: "0.1 + 0.2 == 0.3 && !(1 > 2)", expected: "1"},
+		{mode: calculation.ModeDecimal, expr: "if(0, 1/0, 2.5)", expected: "2.5"},
+		{mode: calculation.ModeRational, expr: "1/3 > 1 && 1/0 > 2", expected: "0"},
+		{mode: calculation.ModeInteger, expr: "1 || 1 // 0", expected: "1"},
+		{mode: calculation.ModeDecimal, expr: "0.5 && 2.5", expected: "1"},
+		{mode: calculation.ModeComplex, expr: "i * i == -1", expected: "1"},
+		{mode: calculation.ModeComplex, expr: "2i ? 1 : 2", expected: "1"},
+		{mode: calculation.ModeComplex, expr: "i < 1", wantErr: "complex numbers cannot be compared"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.expr, func(t *testing.T) {
+			arith, err := calculation.NewArithmetic(tt.mode, 10)
+			require.NoError(t, err)
+
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			got, err := arith.Evaluate(tree, nil)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestSelectTask(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 3, calculation.OperandCount(calculation.SelectOp))
+	assert.Equal(t, 2.0, calculation.Select(1, 2, 3))
+	assert.Equal(t, 3.0, calculation.Select(0, 2, 3))
+
+	arith, err := calculation.NewArithmetic(calculation.ModeRational, 0)
+	require.NoError(t, err)
+	got, err := arith.Apply(calculation.SelectOp, "0", "1/2", "1/3")
+	require.NoError(t, err)
+	assert.Equal(t, "1/3", got)
+	got, err = arith.Apply("<=", "1/3", "1/2")
+	require.NoError(t, err)
+	assert.Equal(t, "1", got)
+}
+
+func TestConditionalDimensions(t *testing.T) {
+	t.Parallel()
+
+	tree, err := calculation.Parse("d > 5 km ? 2 m/s : 3 km/h")
+	require.NoError(t, err)
+	_, err = calculation.Dimensions(tree, calculation.Variables{"d": 1})
+	require.EqualError(t, err, "incompatible units dimensionless and m for '>' at position 2")
+
+	tree, err = calculation.Parse("2 km > 500 m ? 2 m/s : 3 km/h")
+	require.NoError(t, err)
+	dim, err := calculation.Dimensions(tree, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "m/s", dim.String())
+
+	tree, err = calculation.Parse("1 ? 2 m : 3 s")
+	require.NoError(t, err)
+	_, err = calculation.Dimensions(tree, nil)
+	require.EqualError(t, err, "incompatible units m and s in the branches of the conditional at position 0")
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+
+	for _, expr := range []string{
+		"1 + 2 * 3",
+		"(1 + 2) * 3",
+		"1 - (2 - 3)",
+		"2^3^2",
+		"(2^3)^2",
+		"(-x)^2",
+		"-(x^2)",
+		"x > 0 && y < 1 ? x : -y",
+		"if(x > 0, x, 0)",
+		"max([1, 2], 3)",
+		"(2 m) * 3",
+		"t = x * x; t + 1",
+		"~x & 0xFF | y << (n - 1) xor z // 2",
+		"(a | b) & c",
+	} {
+		tree, err := calculation.Parse(expr)
+		require.NoError(t, err, expr)
+		assert.Equal(t, expr, calculation.Format(tree))
+	}
+
+	tree, err := calculation.Parse("1+2*(3)")
+	require.NoError(t, err)
+	assert.Equal(t, "1 + 2 * (3)", calculation.Format(tree))
+}
+
+func TestDerive(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "x^3", want: "3 * x^2"},
+		{expr: "x^2 + 3*x + 1", want: "2 * x + 3"},
+		{expr: "y * x", want: "y"},
+		{expr: "y", want: "0"},
+		{expr: "cos(2*x)", want: "-2 * sin(2 * x)"},
+		{expr: "1/x", want: "-1 / x^2"},
+		{expr: "exp(-x)", want: "-exp(-x)"},
+		{expr: "x - (x - 1)", want: "0"},
+		{expr: "[1, 2] + x", want: "[1, 1]"},
+		{expr: "x > 0 ? x^2 : -x", want: "x > 0 ? 2 * x : -1"},
+		{expr: "2 m * x", want: "2 m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+			d, err := calculation.Derive(tree, "x")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, calculation.Format(d))
+		})
+	}
+}
+
+// TestDeriveNumerically compares derivatives with central differences.
+func TestDeriveNumerically(t *testing.T) {
+	t.Parallel()
+
+	const h = 1e-6
+	for _, expr := range []string{
+		"sin(x) * x",
+		"x / (x + 1)",
+		"2^x",
+		"x^x",
+		"sqrt(x^2 + 1)",
+		"log(x) * exp(x)",
+		"abs(x - 2)",
+		"max(x, 2 * x, 3)",
+		"s = x * x; s + s / x",
+		"sum([x, x^2, 3])",
+		"f(x) + g(x, 3)",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			funcs := definitions(t, "f(x) = x^2", "g(a, b) = a * b + f(a)")
+			tree, err := calculation.ParseWith(expr, funcs)
+			require.NoError(t, err)
+			d, err := calculation.Derive(tree, "x")
+			require.NoError(t, err)
+			derivative, err := calculation.Parse(calculation.Format(d))
+			require.NoError(t, err)
+
+			for _, x := range []float64{0.7, 1.3, 3} {
+				got, err := calculation.EvaluateWith(derivative, calculation.Variables{"x": x})
+				require.NoError(t, err)
+				above, err := calculation.EvaluateWith(tree, calculation.Variables{"x": x + h})
+				require.NoError(t, err)
+				below, err := calculation.EvaluateWith(tree, calculation.Variables{"x": x - h})
+				require.NoError(t, err)
+				want := (above - below) / (2 * h)
+				assert.InDelta(t, want, got, 1e-4*math.Max(1, math.Abs(want)), "x = %v", x)
+			}
+		})
+	}
+}
+
+func TestDeriveErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "3 % x", wantErr: "cannot differentiate '%' with a divisor depending on x at position 2"},
+		{expr: "median(x, 1)", wantErr: "cannot differentiate function median at position 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+			_, err = calculation.Derive(tree, "x")
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
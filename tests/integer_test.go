@@ -0,0 +1,163 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBitwise(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+	}{
+		{name: "and binds tighter than or", expr: "a | b & c", expected: "a | (b & c)"},
+		{name: "xor between and and or", expr: "a | b xor c & d", expected: "a | (b xor (c & d))"},
+		{name: "shifts bind looser than sums", expr: "1 << n - 1", expected: "1 << (n - 1)"},
+		{name: "comparisons bind looser than bitwise", expr: "x & 1 == 0", expected: "(x & 1) == 0"},
+		{name: "integer division is a term", expr: "a + b // c", expected: "a + (b // c)"},
+		{name: "complement is unary", expr: "~x & y", expected: "(~x) & y"},
+		{name: "logical and stays apart", expr: "a && b & c", expected: "a && (b & c)"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expected, grouped(tree, true))
+		})
+	}
+
+	_, err := calculation.Parse("xor = 1; xor")
+	assert.Error(t, err, "xor is an operator, not a name")
+}
+
+// grouped writes the tree with every operation but the outermost in parentheses.
+func grouped(node calculation.Node, outer bool) string {
+	var text string
+	switch n := node.(type) {
+	case *calculation.BinaryExpr:
+		text = grouped(n.Left, false) + " " + n.Op + " " + grouped(n.Right, false)
+	case *calculation.UnaryExpr:
+		text = n.Op + grouped(n.X, false)
+	default:
+		return calculation.Format(node)
+	}
+	if outer {
+		return text
+	}
+	return "(" + text + ")"
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+		wantErr  string
+	}{
+		{name: "bitwise operators", expr: "0xF0 | 0x0F & 0b0110 xor 1", expected: "247"},
+		{name: "shifts", expr: "(1 << 10) + (-16 >> 2)", expected: "1020"},
+		{name: "complement", expr: "~0 + ~5", expected: "-7"},
+		{name: "integer division rounds down", expr: "7 // 2 + -7 // 2", expected: "-1"},
+		{name: "modulo has the sign of the dividend", expr: "-7 % 3", expected: "-1"},
+		{name: "exact division", expr: "6 / 3", expected: "2"},
+		{name: "large values stay exact", expr: "9007199254740993 + 0", expected: "9007199254740993"},
+		{name: "largest value", expr: "2 ^ 62 - 1 + 2 ^ 62", expected: "9223372036854775807"},
+		{name: "comparison of extremes", expr: "(2 ^ 62 - 1 + 2 ^ 62) > -2", expected: "1"},
+		{name: "functions", expr: "max(abs(-3), 2) + min(4, 5) + round(1)", expected: "8"},
+		{name: "exponent literal", expr: "1e3 + 1", expected: "1001"},
+		{name: "addition overflow", expr: "2 ^ 62 + 2 ^ 62", wantErr: "integer overflow: 4611686018427387904 + 4611686018427387904"},
+		{name: "multiplication overflow", expr: "3037000500 * 3037000500", wantErr: "integer overflow: 3037000500 * 3037000500"},
+		{name: "power overflow", expr: "10 ^ 19", wantErr: "integer overflow: 10 ^ 19"},
+		{name: "shift overflow", expr: "1 << 63", wantErr: "integer overflow: 1 << 63"},
+		{name: "literal overflow", expr: "9223372036854775808", wantErr: "integer overflow: 9223372036854775808"},
+		{name: "inexact division", expr: "7 / 2", wantErr: "result is not an integer: 7 / 2"},
+		{name: "fractional literal", expr: "2.5 * 2", wantErr: "result is not an integer: 2.5"},
+		{name: "negative shift", expr: "1 << -1", wantErr: "shift count must be non-negative"},
+		{name: "division by zero", expr: "1 // 0", wantErr: "division by zero"},
+		{name: "unsupported function", expr: "sqrt(4)", wantErr: "function sqrt is not supported in integer mode at position 0"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			arith, err := calculation.NewArithmetic(calculation.ModeInteger, 0)
+			require.NoError(t, err)
+
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			got, err := arith.Evaluate(tree, nil)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestIntegerApply(t *testing.T) {
+	t.Parallel()
+
+	arith, err := calculation.NewArithmetic(calculation.ModeInteger, 0)
+	require.NoError(t, err)
+
+	got, err := arith.Apply("xor", "12", "10")
+	require.NoError(t, err)
+	assert.Equal(t, "6", got)
+
+	got, err = arith.Apply("//", "-9223372036854775807", "2")
+	require.NoError(t, err)
+	assert.Equal(t, "-4611686018427387904", got)
+
+	_, err = arith.Apply("*", "-9223372036854775807", "2")
+	assert.EqualError(t, err, "integer overflow: -9223372036854775807 * 2")
+
+	_, err = arith.Negate("-9223372036854775807")
+	assert.NoError(t, err)
+}
+
+func TestBitwiseOutsideIntegerMode(t *testing.T) {
+	t.Parallel()
+
+	got, err := calculation.EvaluateExpression("(6 & 3) + (1 << 4) + ~0 + 7 // 2")
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, got)
+
+	got, err = calculation.EvaluateExpression("7.5 // 2")
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, got)
+
+	_, err = calculation.EvaluateExpression("1.5 & 1")
+	assert.EqualError(t, err, "bitwise operators require integer operands")
+
+	_, err = calculation.EvaluateExpression("1e19 | 1")
+	assert.EqualError(t, err, "bitwise operators require integer operands", "1e19 does not fit in 64 bits")
+
+	// Operands beyond the range of int used to be rejected as fractions.
+	got, err = calculation.EvaluateExpression("1e19 % 3")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, got)
+
+	arith, err := calculation.NewArithmetic(calculation.ModeRational, 0)
+	require.NoError(t, err)
+	tree, err := calculation.Parse("(2 ^ 70 | 1) >> 69 xor 7 // 2")
+	require.NoError(t, err)
+	exact, err := arith.Evaluate(tree, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "1", exact)
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLocale(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		tag     string
+		want    calculation.Locale
+		wantErr bool
+	}{
+		{tag: "ru-RU", want: calculation.Locale{Tag: "ru-RU", Decimal: ",", Group: "\u00a0"}},
+		{tag: "ru_ru", want: calculation.Locale{Tag: "ru-RU", Decimal: ",", Group: "\u00a0"}},
+		{tag: "en", want: calculation.Locale{Tag: "en", Decimal: ".", Group: ","}},
+		{tag: "de-CH", want: calculation.Locale{Tag: "de-CH", Decimal: ".", Group: "\u2019"}},
+		{tag: "de-AT", want: calculation.Locale{Tag: "de-AT", Decimal: ",", Group: "."}},
+		{tag: "xx-YY", wantErr: true},
+		{tag: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.tag, func(t *testing.T) {
+			got, err := calculation.ParseLocale(tt.tag)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDelocalize(t *testing.T) {
+	t.Parallel()
+
+	ru, err := calculation.ParseLocale("ru-RU")
+	require.NoError(t, err)
+
+	tests := []struct {
+		expr    string
+		want    string
+		wantErr string
+	}{
+		{expr: "3,5 * 2", want: "3.5 * 2"},
+		{expr: "max(1,5; 2)", want: "max(1.5, 2)"},
+		{expr: "max(1; 2)", want: "max(1, 2)"},
+		{expr: "[1,5; 2]", want: "[1.5, 2]"},
+		{expr: "percentile([10; 20; 30]; 50)", want: "percentile([10, 20, 30], 50)"},
+		{expr: "[[1; 2]; [3; 4,5]]", want: "[[1, 2], [3, 4.5]]"},
+		{expr: "t = 1,5; t * 2", want: "t = 1.5; t * 2"},
+		{expr: "f(x; 2); f(y; 3)", want: "f(x, 2); f(y, 3)"},
+		{expr: "1_000,25e3", want: "1_000.25e3"},
+		{expr: "1.5 + 2", want: "1.5 + 2"},
+		// A comma that is not between the digits of a number is ambiguous.
+		{expr: "max(1,2,3)", wantErr: "comma is the decimal separator in locale ru-RU, separate arguments with ';' at position 7"},
+		{expr: "max(1, 2)", wantErr: "comma is the decimal separator in locale ru-RU, separate arguments with ';' at position 5"},
+		{expr: "[1,5,2]", wantErr: "comma is the decimal separator in locale ru-RU, separate arguments with ';' at position 4"},
+		{expr: "0x1,2", wantErr: "comma is the decimal separator in locale ru-RU, separate arguments with ';' at position 3"},
+		{expr: "f(x2,3)", wantErr: "comma is the decimal separator in locale ru-RU, separate arguments with ';' at position 4"},
+	}
+
+	for _, tt := range tests {
+		got, err := ru.Delocalize(tt.expr)
+		if tt.wantErr != "" {
+			require.Error(t, err, tt.expr)
+			assert.Equal(t, tt.wantErr, err.Error())
+
+			var syntaxErr *calculation.SyntaxError
+			require.True(t, errors.As(err, &syntaxErr))
+			assert.Equal(t, calculation.CodeAmbiguousComma, syntaxErr.Code)
+			continue
+		}
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, got, tt.expr)
+	}
+
+	// Without a decimal comma the expression is read as is.
+	en, err := calculation.ParseLocale("en-US")
+	require.NoError(t, err)
+	for _, loc := range []calculation.Locale{en, {}} {
+		got, err := loc.Delocalize("max(1,5)")
+		require.NoError(t, err)
+		assert.Equal(t, "max(1,5)", got)
+	}
+
+	values := []struct {
+		expr     string
+		expected float64
+	}{
+		{expr: "3,5 * 2", expected: 7},
+		{expr: "max(1; 2)", expected: 2},
+		{expr: "1,5 + max(1; 2)", expected: 3.5},
+		{expr: "percentile([10; 20; 30]; 50)", expected: 20},
+		{expr: "sum([1; 2; 3])", expected: 6},
+	}
+	for _, tt := range values {
+		expr, err := ru.Delocalize(tt.expr)
+		require.NoError(t, err, tt.expr)
+		got, err := calculation.EvaluateExpression(expr)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.expected, got, tt.expr)
+	}
+}
+
+func TestLocaleFormatNumber(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		tag  string
+		x    float64
+		want string
+	}{
+		{tag: "ru-RU", x: 1234567.5, want: "1\u00a0234\u00a0567,5"},
+		{tag: "en-US", x: 1234567.5, want: "1,234,567.5"},
+		{tag: "de", x: -1234.25, want: "-1.234,25"},
+		{tag: "ru", x: 999, want: "999"},
+		{tag: "ru", x: 0.001, want: "0,001"},
+		{tag: "fr", x: 100000, want: "100\u202f000"},
+	}
+
+	for _, tt := range tests {
+		loc, err := calculation.ParseLocale(tt.tag)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, loc.FormatNumber(tt.x), tt.tag)
+	}
+
+	assert.Equal(t, "1234.5", calculation.Locale{}.FormatNumber(1234.5))
+}
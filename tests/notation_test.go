@@ -0,0 +1,92 @@
+package test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatNumber(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		x        float64
+		notation calculation.Notation
+		expected string
+	}{
+		{name: "decimal", x: 1234.5, notation: calculation.NotationDecimal, expected: "1234.5"},
+		{name: "decimal without exponent", x: 1e21, notation: calculation.NotationDecimal, expected: "1000000000000000000000"},
+		{name: "decimal small", x: 1e-7, notation: calculation.NotationDecimal, expected: "0.0000001"},
+		{name: "hex", x: 255, notation: calculation.NotationHex, expected: "0xff"},
+		{name: "negative hex", x: -4096, notation: calculation.NotationHex, expected: "-0x1000"},
+		{name: "hex zero", x: 0, notation: calculation.NotationHex, expected: "0x0"},
+		{name: "hex float", x: 3.5, notation: calculation.NotationHex, expected: "0x1.cp+01"},
+		{name: "scientific", x: 1234.5, notation: calculation.NotationScientific, expected: "1.2345e+03"},
+		{name: "scientific small", x: -0.00025, notation: calculation.NotationScientific, expected: "-2.5e-04"},
+		{name: "engineering", x: 1234.5, notation: calculation.NotationEngineering, expected: "1.2345e+03"},
+		{name: "engineering shifts the point", x: 12345, notation: calculation.NotationEngineering, expected: "12.345e+03"},
+		{name: "engineering pads with zeros", x: 100000, notation: calculation.NotationEngineering, expected: "100e+03"},
+		{name: "engineering negative exponent", x: -0.0000125, notation: calculation.NotationEngineering, expected: "-12.5e-06"},
+		{name: "engineering small units", x: 0.5, notation: calculation.NotationEngineering, expected: "500e-03"},
+		{name: "engineering zero", x: 0, notation: calculation.NotationEngineering, expected: "0e+00"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calculation.FormatNumber(tt.x, tt.notation)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestFormatInteger(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		x        string
+		notation calculation.Notation
+		expected string
+	}{
+		{x: "9223372036854775807", notation: calculation.NotationDecimal, expected: "9223372036854775807"},
+		{x: "9223372036854775807", notation: calculation.NotationHex, expected: "0x7fffffffffffffff"},
+		{x: "-9223372036854775808", notation: calculation.NotationHex, expected: "-0x8000000000000000"},
+		{x: "9223372036854775807", notation: calculation.NotationScientific, expected: "9.223372036854775807e+18"},
+		{x: "-1200", notation: calculation.NotationScientific, expected: "-1.2e+03"},
+		{x: "7", notation: calculation.NotationScientific, expected: "7e+00"},
+		{x: "9223372036854775807", notation: calculation.NotationEngineering, expected: "9.223372036854775807e+18"},
+		{x: "123456", notation: calculation.NotationEngineering, expected: "123.456e+03"},
+		{x: "0", notation: calculation.NotationEngineering, expected: "0e+00"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(string(tt.notation)+" "+tt.x, func(t *testing.T) {
+			x, ok := new(big.Int).SetString(tt.x, 10)
+			require.True(t, ok)
+			got, err := calculation.FormatInteger(x, tt.notation)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestNotationValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, calculation.Notation("").Validate())
+	assert.NoError(t, calculation.NotationHex.Validate())
+
+	err := calculation.Notation("roman").Validate()
+	require.Error(t, err)
+	assert.Equal(t, `unknown output format "roman"`, err.Error())
+
+	_, err = calculation.FormatNumber(1, "roman")
+	assert.Error(t, err)
+}
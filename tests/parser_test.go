@@ -0,0 +1,277 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTree(t *testing.T) {
+	t.Parallel()
+
+	tree, err := calculation.Parse("2 + 3 * (4 - 1)")
+	require.NoError(t, err)
+
+	add, ok := tree.(*calculation.BinaryExpr)
+	require.True(t, ok, "root should be a binary expression")
+	assert.Equal(t, "+", add.Op)
+	assert.Equal(t, calculation.Pos(2), add.OpPos)
+	assert.Equal(t, calculation.Pos(0), add.Pos())
+	assert.Equal(t, calculation.Pos(15), add.End())
+
+	mul, ok := add.Right.(*calculation.BinaryExpr)
+	require.True(t, ok, "multiplication should bind tighter than addition")
+	assert.Equal(t, "*", mul.Op)
+
+	group, ok := mul.Right.(*calculation.ParenExpr)
+	require.True(t, ok, "parentheses should be kept as a group node")
+	assert.Equal(t, calculation.Pos(8), group.Lparen)
+	assert.Equal(t, calculation.Pos(14), group.Rparen)
+}
+
+func TestParseAssociativity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		expr      string
+		leftHeavy bool
+	}{
+		{name: "subtraction is left-associative", expr: "1 - 2 - 3", leftHeavy: true},
+		{name: "division is left-associative", expr: "8 / 4 / 2", leftHeavy: true},
+		{name: "power is right-associative", expr: "2 ^ 3 ^ 2", leftHeavy: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			root, ok := tree.(*calculation.BinaryExpr)
+			require.True(t, ok)
+
+			_, leftIsBinary := root.Left.(*calculation.BinaryExpr)
+			_, rightIsBinary := root.Right.(*calculation.BinaryExpr)
+			assert.Equal(t, tt.leftHeavy, leftIsBinary)
+			assert.Equal(t, !tt.leftHeavy, rightIsBinary)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr string
+	}{
+		{name: "empty", expr: "   ", wantErr: "expression is empty"},
+		{name: "unexpected character", expr: "2 + $", wantErr: "unexpected character '$' at position 4"},
+		{name: "unknown function", expr: "2 + f(1)", wantErr: "unknown function 'f' at position 4"},
+		{name: "wrong arity", expr: "sqrt(1, 2)", wantErr: "function sqrt expects 1 argument(s), got 2 at position 0"},
+		{name: "missing operand", expr: "2 +", wantErr: "unexpected end of expression at position 3, expected number, name, '(', '[', '-', '!' or '~'"},
+		{name: "unclosed parenthesis", expr: "(2 + 3", wantErr: "missing closing parenthesis for '(' at position 0"},
+		{name: "unopened parenthesis", expr: "2 + 3)", wantErr: "unexpected token ')' at position 5"},
+		{name: "invalid number", expr: "1.2.3", wantErr: "invalid number \"1.2.3\" at position 0"},
+		{name: "unclosed bracket", expr: "[1, 2", wantErr: "missing closing bracket for '[' at position 0"},
+		{name: "empty list", expr: "[]", wantErr: "unexpected token ']' at position 1, expected number, name, '(', '[', '-', '!' or '~'"},
+		{name: "missing colon", expr: "x > 1 ? 2", wantErr: "missing ':' for '?' at position 6"},
+		{name: "binding without result", expr: "x = 1", wantErr: "script must end with an expression at position 5"},
+		{name: "binding without semicolon", expr: "x = 1 x", wantErr: "unexpected token 'x' at position 6, expected ';'"},
+		{name: "assignment inside expression", expr: "1 + x = 2", wantErr: "unexpected token '=' at position 6"},
+		{name: "if with two arguments", expr: "if(x, 1)", wantErr: "function if expects 3 argument(s), got 2 at position 0"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := calculation.Parse(tt.expr)
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		expr    string
+		want    calculation.SyntaxError
+		snippet string
+	}{
+		{
+			name:    "unexpected token",
+			expr:    "2 + * 3",
+			want:    calculation.SyntaxError{Code: calculation.CodeUnexpectedToken, Message: "unexpected token '*'", Offset: 4, Length: 1, Expected: []string{"number", "name", "'('", "'['", "'-'", "'!'", "'~'"}},
+			snippet: "2 + * 3\n    ^",
+		},
+		{
+			name:    "end of expression",
+			expr:    "2 +",
+			want:    calculation.SyntaxError{Code: calculation.CodeUnexpectedEnd, Message: "unexpected end of expression", Offset: 3, Expected: []string{"number", "name", "'('", "'['", "'-'", "'!'", "'~'"}},
+			snippet: "2 +\n   ^",
+		},
+		{
+			name:    "unknown function",
+			expr:    "1 +\tfoo(2)",
+			want:    calculation.SyntaxError{Code: calculation.CodeUnknownFunction, Message: "unknown function 'foo'", Offset: 4, Length: 3},
+			snippet: "1 +\tfoo(2)\n   \t^^^",
+		},
+		{
+			name:    "separator",
+			expr:    "max(1; 2)",
+			want:    calculation.SyntaxError{Code: calculation.CodeUnexpectedToken, Message: "unexpected token ';'", Offset: 5, Length: 1, Expected: []string{"','", "')'"}},
+			snippet: "max(1; 2)\n     ^",
+		},
+		{
+			name:    "unclosed parenthesis",
+			expr:    "(2 + 3",
+			want:    calculation.SyntaxError{Code: calculation.CodeUnclosedParen, Message: "missing closing parenthesis for '('", Offset: 0, Length: 1},
+			snippet: "(2 + 3\n^",
+		},
+		{
+			name:    "invalid number",
+			expr:    "x * 1.2.3",
+			want:    calculation.SyntaxError{Code: calculation.CodeInvalidNumber, Message: "invalid number \"1.2.3\"", Offset: 4, Length: 5},
+			snippet: "x * 1.2.3\n    ^^^^^",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := calculation.Parse(tt.expr)
+			var syntaxErr *calculation.SyntaxError
+			require.ErrorAs(t, err, &syntaxErr)
+			assert.Equal(t, tt.want, *syntaxErr)
+			assert.Equal(t, tt.snippet, syntaxErr.Snippet(tt.expr))
+		})
+	}
+}
+
+func TestParseImaginary(t *testing.T) {
+	t.Parallel()
+
+	tree, err := calculation.Parse("(1+2i)*(3-i)")
+	require.NoError(t, err)
+	assert.True(t, calculation.HasImaginary(tree))
+
+	var imag *calculation.ImagLit
+	calculation.Inspect(tree, func(n calculation.Node) bool {
+		if lit, ok := n.(*calculation.ImagLit); ok {
+			imag = lit
+		}
+		return imag == nil
+	})
+	require.NotNil(t, imag)
+	assert.Equal(t, "2i", imag.Literal)
+	assert.Equal(t, 2.0, imag.Value)
+	assert.Equal(t, calculation.Pos(3), imag.Pos())
+	assert.Equal(t, []string{"i"}, calculation.FreeVariables(tree), "bare i is parsed as an identifier")
+
+	tree, err = calculation.Parse("2 * index")
+	require.NoError(t, err)
+	assert.False(t, calculation.HasImaginary(tree))
+}
+
+func TestParseNumberLiterals(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected float64
+		decimal  string
+	}{
+		{name: "integer", expr: "42", expected: 42, decimal: "42"},
+		{name: "leading zeros stay decimal", expr: "010", expected: 10, decimal: "10"},
+		{name: "fraction", expr: ".5", expected: 0.5, decimal: "0.5"},
+		{name: "exponent", expr: "1e-9", expected: 1e-9, decimal: "1e-9"},
+		{name: "signed exponent", expr: "2.5E+3", expected: 2500, decimal: "2.5E+3"},
+		{name: "hexadecimal", expr: "0xFF", expected: 255, decimal: "255"},
+		{name: "binary", expr: "0b1010", expected: 10, decimal: "10"},
+		{name: "octal", expr: "0o17", expected: 15, decimal: "15"},
+		{name: "digit separators", expr: "1_000_000", expected: 1e6, decimal: "1000000"},
+		{name: "separators in every part", expr: "1_000.000_5e1_0", expected: 1000.0005e10, decimal: "1000.0005e10"},
+		{name: "separated hexadecimal", expr: "0xFF_FF", expected: 65535, decimal: "65535"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			lit, ok := tree.(*calculation.NumberLit)
+			require.True(t, ok, "a literal should parse to a single number")
+			assert.Equal(t, tt.expr, lit.Literal)
+			assert.Equal(t, tt.expected, lit.Value)
+			assert.Equal(t, tt.decimal, lit.Decimal())
+			assert.Equal(t, calculation.Pos(len(tt.expr)), lit.End())
+		})
+	}
+
+	got, err := calculation.EvaluateExpression("0x10 * 2e1 + 0b1")
+	require.NoError(t, err)
+	assert.Equal(t, 321.0, got)
+
+	tree, err := calculation.Parse("1e-3i")
+	require.NoError(t, err)
+	imag, ok := tree.(*calculation.ImagLit)
+	require.True(t, ok)
+	assert.Equal(t, 1e-3, imag.Value)
+	assert.Equal(t, "1e-3i", imag.Decimal())
+
+	_, err = calculation.Parse("2e")
+	var syntaxErr *calculation.SyntaxError
+	require.ErrorAs(t, err, &syntaxErr)
+	assert.Equal(t, calculation.Pos(1), syntaxErr.Offset, "e without digits is not an exponent")
+
+	for _, expr := range []string{"1__000", "1_", "1_.5", "0x", "0b102", "0o8", "0x_FF", "1e400"} {
+		_, err := calculation.Parse(expr)
+		require.ErrorAs(t, err, &syntaxErr, expr)
+		assert.Equal(t, calculation.CodeInvalidNumber, syntaxErr.Code, expr)
+		assert.Equal(t, calculation.Pos(0), syntaxErr.Offset, expr)
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	t.Parallel()
+
+	tree, err := calculation.Parse("3 m * 2 s^-1 + 4 km/h")
+	require.NoError(t, err)
+	assert.True(t, calculation.HasUnits(tree))
+
+	var units []string
+	calculation.Inspect(tree, func(n calculation.Node) bool {
+		if q, ok := n.(*calculation.QuantityExpr); ok {
+			units = append(units, q.UnitText)
+		}
+		return true
+	})
+	assert.Equal(t, []string{"m", "s^-1", "km/h"}, units)
+	assert.Equal(t, calculation.Pos(21), tree.End())
+
+	tree, err = calculation.Parse("10 m / t")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"t"}, calculation.FreeVariables(tree), "unknown symbols after an operator stay variables")
+
+	tree, err = calculation.Parse("2 * m")
+	require.NoError(t, err)
+	assert.False(t, calculation.HasUnits(tree), "units only follow numbers")
+}
+
+func TestFreeVariables(t *testing.T) {
+	t.Parallel()
+
+	tree, err := calculation.Parse("rate * hours + max(fee, rate)")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"rate", "hours", "fee"}, calculation.FreeVariables(tree))
+}
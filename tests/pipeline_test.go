@@ -0,0 +1,620 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/structxz/calc_v3/configs"
+	"github.com/structxz/calc_v3/internal/app"
+	"github.com/structxz/calc_v3/internal/app/models"
+	"github.com/structxz/calc_v3/internal/cache"
+	"github.com/structxz/calc_v3/internal/db/sqlite"
+	"github.com/structxz/calc_v3/internal/jwtutil"
+	"github.com/structxz/calc_v3/internal/logger"
+	"github.com/structxz/calc_v3/internal/orchestrator"
+	"github.com/structxz/calc_v3/internal/worker"
+	"github.com/structxz/calc_v3/pkg/api"
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/require"
+)
+
+// pipeline is the orchestrator with an agent in the same process:
+// expressions are sent through the REST API, and their tasks are taken,
+// computed and returned the way an agent does it over gRPC.
+type pipeline struct {
+	t        *testing.T
+	handler  http.Handler
+	orch     *orchestrator.OrchestratorServer
+	agent    *worker.Agent
+	token    string
+	header   http.Header // Extra headers of the requests.
+	executed []string    // Operations of the tasks computed by the agent.
+}
+
+func newPipeline(t *testing.T) *pipeline {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	log, err := logger.New(logger.Options{Level: logger.Error, Encoding: "json", OutputPath: []string{"stderr"}, ErrorPath: []string{"stderr"}})
+	require.NoError(t, err)
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "sqlite.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	storage := &sqlite.SQLiteStorage{Db: db}
+	require.NoError(t, sqlite.RunMigrations(log, db))
+
+	token, err := jwtutil.MakeJWT("tester")
+	require.NoError(t, err)
+
+	cfg := &configs.ServerConfig{RestPort: "0", GRPCPort: "0", ResultCacheSize: 100, ResultCacheTTLMS: 60000}
+	return &pipeline{
+		t:       t,
+		handler: server.New(cfg, log, storage).Handler(),
+		orch:    orchestrator.New(log, storage, cache.New(100, time.Minute)),
+		agent:   worker.New(&configs.WorkerConfig{}, log),
+		token:   token,
+	}
+}
+
+// do sends a request to the REST API and decodes the JSON response into out.
+func (p *pipeline) do(method, path string, body any, out any) int {
+	p.t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		require.NoError(p.t, json.NewEncoder(&reader).Encode(body))
+	}
+	r := httptest.NewRequest(method, path, &reader)
+	for name, values := range p.header {
+		r.Header[name] = values
+	}
+	r.Header.Set("Authorization", "Bearer "+p.token)
+	w := httptest.NewRecorder()
+	p.handler.ServeHTTP(w, r)
+
+	if out != nil {
+		require.NoError(p.t, json.Unmarshal(w.Body.Bytes(), out), w.Body.String())
+	}
+	return w.Code
+}
+
+// calculate sends the request to /api/v1/calculate, runs its tasks and
+// returns the expression once it is complete or has failed.
+func (p *pipeline) calculate(req map[string]any) models.Expression {
+	p.t.Helper()
+
+	var created models.CalculateResponse
+	code := p.do(http.MethodPost, "/api/v1/calculate", req, &created)
+	require.Equal(p.t, http.StatusCreated, code)
+	return p.run(created.ID)
+}
+
+// run computes the tasks of the expression until it is complete or has failed.
+func (p *pipeline) run(id string) models.Expression {
+	p.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var resp models.ExpressionResponse
+		require.Equal(p.t, http.StatusOK, p.do(http.MethodGet, "/api/v1/expressions/"+id, nil, &resp))
+		if resp.Expression.Status == models.StatusComplete || resp.Expression.Status == models.StatusError {
+			return resp.Expression
+		}
+
+		if !p.step() {
+			// The expression is still being split into tasks.
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	p.t.Fatalf("expression %s is not complete", id)
+	return models.Expression{}
+}
+
+// step computes one task as an agent does and reports whether there was one.
+func (p *pipeline) step() bool {
+	p.t.Helper()
+
+	resp, err := p.orch.GetTask(context.Background(), &api.AgentInfo{AgentId: p.agent.ID})
+	require.NoError(p.t, err)
+	if !resp.HasTask {
+		return false
+	}
+
+	t := resp.Task
+	task := &models.Task{
+		ID:           t.Id,
+		ExpressionID: t.ExpressionId,
+		Operation:    t.Operation,
+		Arg1:         t.Operands[0],
+		Arg2:         t.Operands[1],
+		Arg3:         t.Operands[2],
+		Precision:    t.Precision,
+		Scale:        int(t.Scale),
+	}
+	if len(t.ExactOperands) > 0 {
+		task.Arg1Exact, task.Arg2Exact, task.Arg3Exact = t.ExactOperands[0], t.ExactOperands[1], t.ExactOperands[2]
+	}
+	p.executed = append(p.executed, task.Operation)
+
+	result := &api.TaskResult{TaskId: task.ID, ExpressionId: task.ExpressionID}
+	if value, exact, err := p.agent.Calculate(task); err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Result, result.ExactResult = value, exact
+	}
+	_, err = p.orch.SubmitTaskResult(context.Background(), result)
+	require.NoError(p.t, err)
+	return true
+}
+
+func TestPipelinePrecedence(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected float64
+		ops      []string
+	}{
+		{expr: "2+3*4", expected: 14, ops: []string{"*", "+"}},
+		{expr: "(2+3)*4", expected: 20, ops: []string{"+", "*"}},
+		{expr: "2-3-4", expected: -5, ops: []string{"-", "-"}},
+		{expr: "2 * (3 + 4) - 10 / (1 + 4)", expected: 12, ops: []string{"+", "+", "*", "/", "-"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p := newPipeline(t)
+
+			expr := p.calculate(map[string]any{"expression": tt.expr})
+			require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+			require.NotNil(t, expr.Result)
+			require.Equal(t, tt.expected, *expr.Result)
+			// A task is only handed out once its operands are known, so the
+			// operations run in an order the precedence allows.
+			require.ElementsMatch(t, tt.ops, p.executed)
+		})
+	}
+}
+
+func TestPipelineModuloAndPower(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected float64
+		wantErr  string
+	}{
+		{expr: "17 % 5 + 2^3^2", expected: 514},
+		{expr: "-7 % 3", expected: -1},
+		{expr: "(1 + 1)^10 % 1000", expected: 24},
+		{expr: "5 % (2 - 2)", wantErr: "modulo by zero"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p := newPipeline(t)
+
+			expr := p.calculate(map[string]any{"expression": tt.expr})
+			if tt.wantErr != "" {
+				require.Equal(t, models.StatusError, expr.Status)
+				require.Contains(t, expr.Error, tt.wantErr)
+				return
+			}
+			require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+			require.NotNil(t, expr.Result)
+			require.Equal(t, tt.expected, *expr.Result)
+		})
+	}
+}
+
+func TestPipelineNonFiniteResult(t *testing.T) {
+	for _, expr := range []string{"0 * 10^400", "10^400 * 2", "exp(1000) - exp(1000)"} {
+		t.Run(expr, func(t *testing.T) {
+			p := newPipeline(t)
+
+			// An overflow is a task error rather than a result that cannot be
+			// stored or written as JSON.
+			got := p.calculate(map[string]any{"expression": expr})
+			require.Equal(t, models.StatusError, got.Status)
+			require.Contains(t, got.Error, "result is not a finite number")
+			require.Nil(t, got.Result)
+		})
+	}
+}
+
+func TestPipelineShortCircuit(t *testing.T) {
+	tests := []struct {
+		expr     string
+		vars     map[string]float64
+		expected float64
+		skipped  string // Operation of the right operand when it is not computed.
+	}{
+		{expr: "x != 0 && y / x > 2", vars: map[string]float64{"x": 0, "y": 5}, expected: 0, skipped: "/"},
+		{expr: "x != 0 && y / x > 2", vars: map[string]float64{"x": 2, "y": 5}, expected: 1},
+		{expr: "x == 0 || y / x > 2", vars: map[string]float64{"x": 0, "y": 5}, expected: 1, skipped: "/"},
+		{expr: "x == 0 || y / x > 2", vars: map[string]float64{"x": 5, "y": 5}, expected: 0},
+		{expr: "x + 1 && y % x", vars: map[string]float64{"x": -1, "y": 5}, expected: 0, skipped: "%"},
+		{expr: "x + 1 && y % x", vars: map[string]float64{"x": 2, "y": 5}, expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p := newPipeline(t)
+
+			// The right operand is only computed when the left one does not
+			// decide the result, so it cannot fail on a zero divisor.
+			expr := p.calculate(map[string]any{"expression": tt.expr, "variables": tt.vars})
+			require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+			require.NotNil(t, expr.Result)
+			require.Equal(t, tt.expected, *expr.Result)
+			if tt.skipped != "" {
+				require.NotContains(t, p.executed, tt.skipped)
+			}
+			if tt.skipped != "" {
+				require.NotContains(t, p.executed, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestPipelineTasksSaved(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		expected float64
+		saved    int
+		tasks    int
+	}{
+		{name: "identities", expr: "(a * 1 + 0) / 1 - 0", expected: 2, saved: 4, tasks: 0},
+		{name: "repeated operation", expr: "(a + b) * (a + b)", expected: 25, saved: 1, tasks: 2},
+		{name: "commutative repeat", expr: "a * b - b * a", expected: 0, saved: 1, tasks: 2},
+		// The a+b != 0 guard is one task shared by both branches and the
+		// selection of the value, not a repeat of itself.
+		{name: "guard of a conditional", expr: "if(a + b, a, b)", expected: 2, saved: 0, tasks: 3},
+		{name: "guards of two conditionals", expr: "(a + b ? 1 : 2) + (a * b ? 3 : 4)", expected: 4, saved: 0, tasks: 7},
+		// Only the repeated a+b counts: the second conditional reuses its guard.
+		{name: "repeated condition", expr: "(a + b ? 1 : 2) + (a + b ? 3 : 4)", expected: 4, saved: 1, tasks: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPipeline(t)
+
+			expr := p.calculate(map[string]any{"expression": tt.expr, "variables": map[string]float64{"a": 2, "b": 3}})
+			require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+			require.NotNil(t, expr.Result)
+			require.Equal(t, tt.expected, *expr.Result)
+			require.Equal(t, tt.saved, expr.TasksSaved)
+			require.Len(t, p.executed, tt.tasks)
+		})
+	}
+}
+
+func TestPipelineDerive(t *testing.T) {
+	tests := []struct {
+		expr       string
+		derivative string
+		expected   float64
+	}{
+		{expr: "x^3 + y*x", derivative: "3 * x^2 + y", expected: 13},
+		{expr: "x^x", derivative: "(log(x) + 1) * x^x", expected: 4 * (math.Log(2) + 1)},
+		{expr: "x * log(x)", derivative: "log(x) + 1", expected: math.Log(2) + 1},
+		{expr: "x / x^2", derivative: "-1 / x^2", expected: -0.25},
+		{expr: "t = x * x; t + 1", derivative: "2 * x", expected: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p := newPipeline(t)
+
+			// The derivative is simplified before it is returned and computed.
+			var resp models.DeriveResponse
+			req := map[string]any{"expression": tt.expr, "variable": "x", "at": map[string]float64{"x": 2, "y": 1}}
+			require.Equal(t, http.StatusCreated, p.do(http.MethodPost, "/api/v1/derive", req, &resp))
+			require.Equal(t, tt.derivative, resp.Derivative)
+
+			expr := p.run(resp.ID)
+			require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+			require.NotNil(t, expr.Result)
+			require.InDelta(t, tt.expected, *expr.Result, 1e-12)
+		})
+	}
+}
+
+func TestPipelineNormalized(t *testing.T) {
+	p := newPipeline(t)
+
+	expr := p.calculate(map[string]any{"expression": "x*3 + (1+2)*x", "variables": map[string]float64{"x": 2}})
+	require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+
+	// The normalized form is computed after the expression is split into tasks.
+	require.Eventually(t, func() bool {
+		var resp models.ExpressionResponse
+		p.do(http.MethodGet, "/api/v1/expressions/"+expr.ID, nil, &resp)
+		return resp.Expression.Normalized == "6 * x"
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestPipelineNormalizedInMode(t *testing.T) {
+	p := newPipeline(t)
+
+	// The sum overflows in integer mode, so the normalized form keeps it unfolded.
+	expr := p.calculate(map[string]any{"expression": "9223372036854775807 + 1", "precision": "integer"})
+	require.Equal(t, models.StatusError, expr.Status)
+
+	require.Eventually(t, func() bool {
+		var resp models.ExpressionResponse
+		p.do(http.MethodGet, "/api/v1/expressions/"+expr.ID, nil, &resp)
+		return resp.Expression.Normalized == "9223372036854775807 + 1"
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestPipelineLongExpressionIsNotNormalizedInline(t *testing.T) {
+	p := newPipeline(t)
+
+	// Simplifying thousands of factors takes seconds; the request must not wait for it.
+	long := "x" + strings.Repeat(" * x", 5000)
+	start := time.Now()
+	var created models.CalculateResponse
+	code := p.do(http.MethodPost, "/api/v1/calculate", map[string]any{"expression": long, "variables": map[string]float64{"x": 1}}, &created)
+	require.Equal(t, http.StatusCreated, code)
+	require.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestPipelineSimplifyLimits(t *testing.T) {
+	p := newPipeline(t)
+
+	// Folding the constant would build a number with millions of digits.
+	var simplified models.SimplifyResponse
+	require.Equal(t, http.StatusOK, p.do(http.MethodPost, "/api/v1/simplify", map[string]any{"expression": "(9^10000)^1000"}, &simplified))
+	require.Equal(t, "(9^10000)^1000", simplified.Simplified)
+
+	long := "x" + strings.Repeat(" * x", 500)
+	require.Equal(t, http.StatusUnprocessableEntity, p.do(http.MethodPost, "/api/v1/simplify", map[string]any{"expression": long}, nil))
+	require.Equal(t, http.StatusUnprocessableEntity, p.do(http.MethodPost, "/api/v1/derive", map[string]any{"expression": long, "variable": "x"}, nil))
+}
+
+func TestPipelineIntegerOrder(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected string
+	}{
+		{expr: "-1 + -1 + 9223372036854775807 + 1", expected: "9223372036854775806"},
+		{expr: "-2 * 3 + 9223372036854775807 + 2 * 3", expected: "9223372036854775807"},
+		{expr: "-9223372036854775807 + -1 + 1 + 1 + max(1, 2, 3, 4)", expected: "-9223372036854775802"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			// Checked integer addition is not associative, so the chain must not
+			// be regrouped: (-1 + -1) + (2^63-1 + 1) would overflow.
+			for _, strict := range []bool{false, true} {
+				p := newPipeline(t)
+
+				expr := p.calculate(map[string]any{"expression": tt.expr, "precision": "integer", "strict_order": strict})
+				require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+				require.Equal(t, tt.expected, expr.ExactResult)
+			}
+		})
+	}
+}
+
+func TestPipelineDecimalOrder(t *testing.T) {
+	tests := []struct {
+		expr  string
+		scale int
+	}{
+		{expr: "0.05 * 0.1 * 10", scale: 2},
+		{expr: "0.5 * 0.5 * 0.5 * 8", scale: 2},
+		{expr: "1 / 3 * 3 * 2 * 5", scale: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			// Decimal multiplication rounds to the scale, so regrouping the
+			// chain would change what is lost: the result must match local
+			// left-to-right evaluation.
+			arith, err := calculation.NewArithmetic(calculation.ModeDecimal, tt.scale)
+			require.NoError(t, err)
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+			expected, err := arith.Evaluate(tree, nil)
+			require.NoError(t, err)
+
+			for _, strict := range []bool{false, true} {
+				p := newPipeline(t)
+
+				expr := p.calculate(map[string]any{"expression": tt.expr, "precision": "decimal", "scale": tt.scale, "strict_order": strict})
+				require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+				require.Equal(t, expected, expr.ExactResult)
+			}
+		})
+	}
+}
+
+func TestPipelineIntegerFormat(t *testing.T) {
+	tests := []struct {
+		expr      string
+		format    string
+		formatted string
+		localized string
+	}{
+		{expr: "9223372036854775807", format: "hex", formatted: "0x7fffffffffffffff", localized: "9\u00a0223\u00a0372\u00a0036\u00a0854\u00a0775\u00a0807"},
+		{expr: "9223372036854775806 + 1", format: "hex", formatted: "0x7fffffffffffffff", localized: "9\u00a0223\u00a0372\u00a0036\u00a0854\u00a0775\u00a0807"},
+		{expr: "-9223372036854775807 - 1", format: "decimal", formatted: "-9223372036854775808", localized: "-9\u00a0223\u00a0372\u00a0036\u00a0854\u00a0775\u00a0808"},
+		{expr: "(1 << 62) + 1", format: "scientific", formatted: "4.611686018427387905e+18", localized: "4\u00a0611\u00a0686\u00a0018\u00a0427\u00a0387\u00a0905"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p := newPipeline(t)
+
+			// Integer results are written from the exact value: the float
+			// result rounds 2^63-1 up to 2^63.
+			expr := p.calculate(map[string]any{"expression": tt.expr, "precision": "integer", "output_format": tt.format, "locale": "ru-RU"})
+			require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+			require.Equal(t, tt.formatted, expr.Formatted)
+			require.Equal(t, tt.localized, expr.Localized)
+		})
+	}
+}
+
+func TestPipelineListExpressions(t *testing.T) {
+	p := newPipeline(t)
+
+	first := p.calculate(map[string]any{"expression": "2 + 2", "locale": "ru-RU", "output_format": "hex"})
+	second := p.calculate(map[string]any{"expression": "1 + 2"})
+
+	var resp models.ExpressionsResponse
+	require.Equal(t, http.StatusOK, p.do(http.MethodGet, "/api/v1/expressions", nil, &resp))
+	require.Len(t, resp.Expressions, 2)
+
+	byID := make(map[string]models.Expression)
+	for _, expr := range resp.Expressions {
+		byID[expr.ID] = expr
+	}
+	require.Equal(t, "ru-RU", byID[first.ID].Locale)
+	require.Equal(t, "0x4", byID[first.ID].Formatted)
+	require.Equal(t, models.StatusComplete, byID[second.ID].Status)
+	require.Equal(t, 3.0, *byID[second.ID].Result)
+}
+
+func TestPipelineLocale(t *testing.T) {
+	tests := []struct {
+		expr      string
+		expected  float64
+		localized string
+	}{
+		{expr: "3,5 * 2", expected: 7, localized: "7"},
+		{expr: "max(1; 2)", expected: 2, localized: "2"},
+		{expr: "1,5 + max(1; 2)", expected: 3.5, localized: "3,5"},
+		{expr: "percentile([10; 20; 30]; 50)", expected: 20, localized: "20"},
+		{expr: "sum([1,5; 2; 3])", expected: 6.5, localized: "6,5"},
+		{expr: "dot([1; 2]; [3; 4]) * 1000", expected: 11000, localized: "11\u00a0000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p := newPipeline(t)
+
+			expr := p.calculate(map[string]any{"expression": tt.expr, "locale": "ru-RU"})
+			require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+			require.Equal(t, tt.expected, *expr.Result)
+			require.Equal(t, tt.localized, expr.Localized)
+			// The expression is kept as it was sent.
+			require.Equal(t, tt.expr, expr.Expression)
+		})
+	}
+}
+
+func TestPipelineDecimalLocale(t *testing.T) {
+	tests := []struct {
+		expr      string
+		localized string
+	}{
+		{expr: "1 / 3", localized: "0,333333333333333333333333333333"},
+		{expr: "12345678901234567890 + 0,25", localized: "12\u00a0345\u00a0678\u00a0901\u00a0234\u00a0567\u00a0890,25"},
+		{expr: "-0,1 * 3", localized: "-0,3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p := newPipeline(t)
+
+			// The localized result is written from the exact decimal, which
+			// float64 would round.
+			expr := p.calculate(map[string]any{"expression": tt.expr, "locale": "ru-RU", "precision": "decimal", "scale": 30})
+			require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+			require.Equal(t, tt.localized, expr.Localized)
+		})
+	}
+}
+
+func TestPipelineLocaleAmbiguousComma(t *testing.T) {
+	p := newPipeline(t)
+
+	// In a locale with the decimal comma a comma cannot separate arguments.
+	var resp models.ErrorResponse
+	code := p.do(http.MethodPost, "/api/v1/calculate", map[string]any{"expression": "max(1,2,3)", "locale": "ru-RU"}, &resp)
+	require.Equal(t, http.StatusUnprocessableEntity, code)
+	require.NotNil(t, resp.SyntaxError)
+	require.Equal(t, "ambiguous_comma", resp.SyntaxError.Code)
+	require.Equal(t, 7, resp.SyntaxError.Offset)
+}
+
+func TestPipelineAcceptLanguageIsIgnored(t *testing.T) {
+	p := newPipeline(t)
+	p.header = http.Header{"Accept-Language": {"ru-RU,ru;q=0.9"}}
+
+	// Only the locale field changes how the expression is read.
+	expr := p.calculate(map[string]any{"expression": "max(1,2) + sum([1,2,3])"})
+	require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+	require.Equal(t, 8.0, *expr.Result)
+	require.Empty(t, expr.Localized)
+}
+
+func TestPipelineFormulaBodyIsNotDelocalized(t *testing.T) {
+	p := newPipeline(t)
+
+	formula := map[string]any{"name": "score", "body": "max(x, min(1,2)) + percentile([10,20,30],50)", "parameters": []string{"x"}}
+	require.Equal(t, http.StatusCreated, p.do(http.MethodPost, "/api/v1/formulas", formula, nil))
+
+	// The body is written with points and commas whatever the locale; the
+	// locale only changes how the result is written.
+	var created models.CalculateResponse
+	req := map[string]any{"parameters": map[string]float64{"x": 0.5}, "locale": "ru-RU"}
+	require.Equal(t, http.StatusCreated, p.do(http.MethodPost, "/api/v1/formulas/score/evaluate", req, &created))
+
+	expr := p.run(created.ID)
+	require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+	require.Equal(t, 21.0, *expr.Result)
+	require.Equal(t, "21", expr.Localized)
+}
+
+func TestPipelineDeleteFunctionUsedByFormula(t *testing.T) {
+	p := newPipeline(t)
+
+	require.Equal(t, http.StatusCreated, p.do(http.MethodPost, "/api/v1/functions", map[string]any{"definition": "sq(x) = x * x"}, nil))
+	formula := map[string]any{"name": "area", "body": "sq(r) * 3", "parameters": []string{"r"}}
+	require.Equal(t, http.StatusCreated, p.do(http.MethodPost, "/api/v1/formulas", formula, nil))
+
+	// Deleting the function would leave the formula unusable.
+	require.Equal(t, http.StatusConflict, p.do(http.MethodDelete, "/api/v1/functions/sq", nil, nil))
+
+	var created models.CalculateResponse
+	req := map[string]any{"parameters": map[string]float64{"r": 2}}
+	require.Equal(t, http.StatusCreated, p.do(http.MethodPost, "/api/v1/formulas/area/evaluate", req, &created))
+	expr := p.run(created.ID)
+	require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+	require.Equal(t, 12.0, *expr.Result)
+
+	require.Equal(t, http.StatusNoContent, p.do(http.MethodDelete, "/api/v1/formulas/area", nil, nil))
+	require.Equal(t, http.StatusNoContent, p.do(http.MethodDelete, "/api/v1/functions/sq", nil, nil))
+}
+
+func TestPipelineResultCache(t *testing.T) {
+	p := newPipeline(t)
+
+	expr := p.calculate(map[string]any{"expression": "2 * 3 + 4"})
+	require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+	require.Equal(t, []string{"*", "+"}, p.executed)
+
+	// The cache key is stored with the task when it is handed out, and the
+	// result is cached under it when the agent reports back.
+	p.executed = nil
+	expr = p.calculate(map[string]any{"expression": "2 * 3 + 4"})
+	require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+	require.Equal(t, 10.0, *expr.Result)
+	require.Empty(t, p.executed)
+
+	expr = p.calculate(map[string]any{"expression": "2 * 3 + 4", "no_cache": true})
+	require.Equal(t, models.StatusComplete, expr.Status, expr.Error)
+	require.Equal(t, []string{"*", "+"}, p.executed)
+}
@@ -0,0 +1,207 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecimalArithmetic(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		expr     string
+		scale    int
+		expected string
+		wantErr  string
+	}{
+		{name: "no binary rounding error", expr: "0.1 + 0.2", scale: 28, expected: "0.3"},
+		{name: "division rounds to scale", expr: "1 / 3", scale: 28, expected: "0.3333333333333333333333333333"},
+		{name: "half to even", expr: "0.125 + 0", scale: 2, expected: "0.12"},
+		{name: "every step is rounded", expr: "1 / 3 * 3", scale: 5, expected: "0.99999"},
+		{name: "square root", expr: "sqrt(2)", scale: 30, expected: "1.41421356237309504880168872421"},
+		{name: "negative exponent", expr: "2 ^ -2", scale: 28, expected: "0.25"},
+		{name: "fractional modulo", expr: "-5.5 % 2", scale: 28, expected: "-1.5"},
+		{name: "large integers stay exact", expr: "2 ^ 100", scale: 0, expected: "1267650600228229401496703205376"},
+		{name: "variadic functions", expr: "max(0.1, 0.3, 0.2) - min(0.1, 0.3)", scale: 4, expected: "0.2"},
+		{name: "round half away from zero", expr: "round(-2.5)", scale: 28, expected: "-3"},
+		{name: "exponent literals are exact", expr: "1e-20 + 0.1E1", scale: 28, expected: "1.00000000000000000001"},
+		{name: "prefixed literals", expr: "0xFF + 0b1010 + 0o17 + 1_000 + 010", scale: 0, expected: "1290"},
+		{name: "division by zero", expr: "1 / 0", scale: 28, wantErr: "division by zero"},
+		{name: "fractional exponent", expr: "2 ^ 0.5", scale: 28, wantErr: "exponent must be an integer in exact arithmetic"},
+		{name: "unsupported function", expr: "1 + sin(0)", scale: 28, wantErr: "function sin is not supported in decimal mode at position 4"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			arith, err := calculation.NewArithmetic(calculation.ModeDecimal, tt.scale)
+			require.NoError(t, err)
+
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			got, err := arith.Evaluate(tree, nil)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestDecimalApply(t *testing.T) {
+	t.Parallel()
+
+	arith, err := calculation.NewArithmetic(calculation.ModeDecimal, 10)
+	require.NoError(t, err)
+
+	got, err := arith.Apply("/", "10", "4")
+	require.NoError(t, err)
+	assert.Equal(t, "2.5", got)
+
+	got, err = arith.Apply("sqrt", "0.0001")
+	require.NoError(t, err)
+	assert.Equal(t, "0.01", got)
+
+	value, err := arith.FromFloat(0.1)
+	require.NoError(t, err)
+	assert.Equal(t, "0.1", value)
+
+	approx, err := arith.Float("0.1")
+	require.NoError(t, err)
+	assert.Equal(t, 0.1, approx)
+}
+
+func TestNewArithmetic(t *testing.T) {
+	t.Parallel()
+
+	arith, err := calculation.NewArithmetic(calculation.ModeFloat, calculation.DefaultScale)
+	require.NoError(t, err)
+	assert.Nil(t, arith, "float mode uses plain float64 operations")
+
+	_, err = calculation.NewArithmetic("quad", 10)
+	assert.EqualError(t, err, `unknown precision mode "quad"`)
+
+	_, err = calculation.NewArithmetic(calculation.ModeDecimal, -1)
+	assert.EqualError(t, err, "scale must be between 0 and 1000")
+}
+
+func TestRationalArithmetic(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+		wantErr  string
+	}{
+		{name: "thirds add up exactly", expr: "1/3 + 1/3", expected: "2/3"},
+		{name: "result in lowest terms", expr: "6/4", expected: "3/2"},
+		{name: "integers have no denominator", expr: "1/3 * 3", expected: "1"},
+		{name: "decimal literals are exact", expr: "0.1 + 0.2", expected: "3/10"},
+		{name: "negative exponent", expr: "(2/3) ^ -2", expected: "9/4"},
+		{name: "perfect square root", expr: "sqrt(4/9)", expected: "2/3"},
+		{name: "modulo", expr: "(7/2) % 1", expected: "1/2"},
+		{name: "irrational root", expr: "sqrt(2)", wantErr: "result is not a rational number: sqrt(2)"},
+		{name: "division by zero", expr: "1 / (1/2 - 0.5)", wantErr: "division by zero"},
+		{name: "unsupported function", expr: "exp(1)", wantErr: "function exp is not supported in rational mode at position 0"},
+		{name: "large power", expr: "2^10000 / 2^9999", expected: "2"},
+		{name: "nested powers", expr: "(9^10000)^1000", wantErr: "result is too large, the limit is 131072 bits"},
+		{name: "product of large powers", expr: "9^10000 * 9^10000 * 9^10000 * 9^10000 * 9^10000", wantErr: "result is too large, the limit is 131072 bits"},
+		{name: "sum of fractions", expr: "1/3^10000 + 1/7^10000 + 1/11^10000 + 1/13^10000 + 1/17^10000", wantErr: "result is too large, the limit is 131072 bits"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			arith, err := calculation.NewArithmetic(calculation.ModeRational, 0)
+			require.NoError(t, err)
+
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			got, err := arith.Evaluate(tree, nil)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestRationalFloat(t *testing.T) {
+	t.Parallel()
+
+	arith, err := calculation.NewArithmetic(calculation.ModeRational, calculation.DefaultScale)
+	require.NoError(t, err)
+	assert.Equal(t, 0, arith.Scale(), "scale does not apply to fractions")
+
+	approx, err := arith.Float("2/3")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.6666666666666666, approx, 1e-15)
+}
+
+func TestComplexArithmetic(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		expr     string
+		vars     calculation.Variables
+		expected string
+		wantErr  string
+	}{
+		{name: "product", expr: "(1+2i)*(3-i)", expected: "5+5i"},
+		{name: "root of negative number", expr: "sqrt(-4)", expected: "2i"},
+		{name: "imaginary unit squared", expr: "i^2", expected: "-1"},
+		{name: "division", expr: "(5+5i) / (1+2i)", expected: "3-1i"},
+		{name: "modulus", expr: "abs(3+4i)", expected: "5"},
+		{name: "bound variable shadows the unit", expr: "i * 2", vars: calculation.Variables{"i": 3}, expected: "6"},
+		{name: "real results stay real", expr: "max(1, 2) + 0.5", expected: "2.5"},
+		{name: "ordering complex values", expr: "max(1, 2i)", wantErr: "complex numbers cannot be compared"},
+		{name: "complex modulo", expr: "(1+i) % 2", wantErr: "modulo is not defined for complex numbers"},
+		{name: "division by zero", expr: "1 / (i - i)", wantErr: "division by zero"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			arith, err := calculation.NewArithmetic(calculation.ModeComplex, 0)
+			require.NoError(t, err)
+
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			got, err := arith.Evaluate(tree, tt.vars)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestImaginaryOutsideComplexMode(t *testing.T) {
+	t.Parallel()
+
+	arith, err := calculation.NewArithmetic(calculation.ModeDecimal, 10)
+	require.NoError(t, err)
+
+	tree, err := calculation.Parse("1 + 2i")
+	require.NoError(t, err)
+	assert.EqualError(t, arith.Check(tree), "imaginary number 2i at position 4 requires complex mode")
+}
@@ -0,0 +1,76 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebalanceDepth(t *testing.T) {
+	t.Parallel()
+
+	values := make([]string, 1000)
+	for i := range values {
+		values[i] = fmt.Sprint(i + 1)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		ops        int
+		depth      int
+	}{
+		{"sum chain", strings.Join(values, " + "), 999, 10},
+		{"product chain", strings.Join(values[:16], "*"), 15, 4},
+		{"through parentheses", "((1 + 2) + 3) + (4 + 5)", 4, 3},
+		{"mixed operators", "1 + 2 + 3 - 4 + 5 + 6", 5, 4},
+		{"nested chains", "(1*2*3*4) + 5 + 6 + 7", 6, 4},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, err := calculation.Parse(tc.expression)
+			require.NoError(t, err)
+
+			alg := &depthAlgebra{}
+			depth, err := calculation.Fold[int](calculation.Rebalance(tree), alg)
+			require.NoError(t, err)
+			assert.Equal(t, tc.ops, alg.ops, "rebalancing keeps the number of operations")
+			assert.Equal(t, tc.depth, depth.Elems[0])
+		})
+	}
+}
+
+func TestRebalanceKeepsValue(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"1 + 2 + 3 + 4 + 5", 15},
+		{"2 * 3 * 4 * 5", 120},
+		{"10 - 2 - 3 - 4", 1},
+		{"1 + 2 + 3 - 4 + 5 + 6", 13},
+		{"2 ^ 3 ^ 2 * 2 * 2", 2048},
+		{"-(1 + 2 + 3) * 4 * 5", -120},
+		{"max(1 + 2 + 3, 4 * 5 * 6)", 120},
+		{"sum([1 + 2 + 3, 4, 5])", 15},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expression, func(t *testing.T) {
+			tree, err := calculation.Parse(tc.expression)
+			require.NoError(t, err)
+
+			result, err := calculation.Evaluate(calculation.Rebalance(tree))
+			require.NoError(t, err)
+			assert.InDelta(t, tc.expected, result, 1e-9)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScript(t *testing.T) {
+	t.Parallel()
+
+	tree, err := calculation.Parse("x = 3*4; y = x + 2; y * x")
+	require.NoError(t, err)
+
+	script, ok := tree.(*calculation.Script)
+	require.True(t, ok)
+	require.Len(t, script.Bindings, 2)
+	x, y := script.Bindings[0], script.Bindings[1]
+	assert.Equal(t, "x", x.Name)
+	assert.Equal(t, calculation.Pos(7), x.Semi)
+	assert.Equal(t, calculation.Pos(0), script.Pos())
+	assert.Equal(t, calculation.Pos(25), script.End())
+
+	sum, ok := y.Value.(*calculation.BinaryExpr)
+	require.True(t, ok)
+	ref, ok := sum.Left.(*calculation.Ref)
+	require.True(t, ok)
+	assert.Same(t, x, ref.Binding)
+
+	product, ok := script.Result.(*calculation.BinaryExpr)
+	require.True(t, ok)
+	assert.Same(t, y, product.Left.(*calculation.Ref).Binding)
+	assert.Same(t, x, product.Right.(*calculation.Ref).Binding)
+
+	// A name is bound from the next statement on, so x on the right of the
+	// first binding is still the variable x.
+	tree, err = calculation.Parse("x = x * 2; x = x + 1; x;")
+	require.NoError(t, err)
+	script = tree.(*calculation.Script)
+	assert.Equal(t, []string{"x"}, calculation.FreeVariables(tree))
+	assert.Same(t, script.Bindings[0], script.Bindings[1].Value.(*calculation.BinaryExpr).Left.(*calculation.Ref).Binding)
+	assert.Same(t, script.Bindings[1], script.Result.(*calculation.Ref).Binding)
+
+	tree, err = calculation.Parse("x * 2;")
+	require.NoError(t, err)
+	_, ok = tree.(*calculation.BinaryExpr)
+	assert.True(t, ok, "an expression without bindings is not a script")
+}
+
+func TestEvaluateScript(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr     string
+		vars     calculation.Variables
+		expected float64
+	}{
+		{expr: "x = 3*4; y = x + 2; y * x", expected: 168},
+		{expr: "x = x * 2; x = x + 1; x", vars: calculation.Variables{"x": 5}, expected: 11},
+		{expr: "rate = 0.2; price = 150; price > 100 ? price * (1 - rate) : price", expected: 120},
+		{expr: "s = sum([1, 2, 3]); s / 3", expected: 2},
+		{expr: "unused = 1/0; 7", expected: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			result, err := calculation.EvaluateWith(tree, tt.vars)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, result, 1e-9)
+
+			result, err = calculation.EvaluateWith(calculation.Rebalance(tree), tt.vars)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, result, 1e-9)
+		})
+	}
+
+	tree, err := calculation.Parse("third = 1/3; third + third * 2")
+	require.NoError(t, err)
+	arith, err := calculation.NewArithmetic(calculation.ModeRational, 0)
+	require.NoError(t, err)
+	got, err := arith.Evaluate(tree, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "1", got)
+}
+
+func TestScriptSharesBindings(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		expr string
+		ops  int
+	}{
+		{"binding used twice", "x = 1 + 2; y = x * x; y + y", 3},
+		{"unused bindings", "a = 1 + 2; b = 3 * 4; c = a - 1; b", 1},
+		{"binding used in both branches", "x = 2 * 3; x > 5 ? x : -x", 3},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, err := calculation.Parse(tc.expr)
+			require.NoError(t, err)
+
+			alg := &depthAlgebra{}
+			_, err = calculation.Fold[int](calculation.Rebalance(tree), alg)
+			require.NoError(t, err)
+			assert.Equal(t, tc.ops, alg.ops)
+		})
+	}
+
+	// Forty squarings would be 2^40 operations if every reference were
+	// expanded.
+	statements := []string{"x0 = 1"}
+	for i := 1; i <= 40; i++ {
+		statements = append(statements, fmt.Sprintf("x%d = x%d * x%d", i, i-1, i-1))
+	}
+	tree, err := calculation.Parse(strings.Join(statements, "; ") + "; x40")
+	require.NoError(t, err)
+
+	alg := &depthAlgebra{}
+	depth, err := calculation.Fold[int](calculation.Rebalance(tree), alg)
+	require.NoError(t, err)
+	assert.Equal(t, 40, alg.ops)
+	assert.Equal(t, 40, depth.Elems[0])
+
+	_, err = calculation.Dimensions(tree, nil)
+	require.NoError(t, err)
+	arith, err := calculation.NewArithmetic(calculation.ModeDecimal, 4)
+	require.NoError(t, err)
+	_, err = arith.Evaluate(tree, nil)
+	require.NoError(t, err)
+}
+
+func TestScriptDimensions(t *testing.T) {
+	t.Parallel()
+
+	tree, err := calculation.Parse("d = 3 km; t = 30 min; d / t")
+	require.NoError(t, err)
+	dim, err := calculation.Dimensions(tree, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "m/s", dim.String())
+
+	tree, err = calculation.Parse("d = 3 km; t = 30 min; d + t")
+	require.NoError(t, err)
+	_, err = calculation.Dimensions(tree, nil)
+	require.EqualError(t, err, "incompatible units m and s for '+' at position 24")
+}
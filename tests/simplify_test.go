@@ -0,0 +1,164 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimplify(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "((x))", want: "x"},
+		{expr: "x   +x", want: "2 * x"},
+		{expr: "b + a", want: "a + b"},
+		{expr: "2*x + 3*x - x", want: "4 * x"},
+		{expr: "x*y + y*x", want: "2 * x * y"},
+		{expr: "1 + x^2 + 3*x", want: "x^2 + 3 * x + 1"},
+		{expr: "x * x * x", want: "x^3"},
+		{expr: "(2*x)^2", want: "4 * x^2"},
+		{expr: "(x + 1) * (x + 1)", want: "(x + 1)^2"},
+		{expr: "2 * (x + 1) - 2 * x", want: "2"},
+		{expr: "x - x", want: "0"},
+		{expr: "x / 2", want: "0.5 * x"},
+		{expr: "2*x/3", want: "2 * x / 3"},
+		{expr: "1/3 + 1/3", want: "2 / 3"},
+		{expr: "0.1 + 0.2", want: "0.3"},
+		{expr: "x^-1", want: "1 / x"},
+		{expr: "2^10 - 1", want: "1023"},
+		{expr: "1/0", want: "1 / 0"},
+		{expr: "-x/3", want: "-x / 3"},
+		{expr: "1 < 2 ? a : b", want: "a"},
+		{expr: "x > 0 ? 1 + 1 : 2*x*x", want: "x > 0 ? 2 : 2 * x^2"},
+		{expr: "max(1+1, x)", want: "max(2, x)"},
+		{expr: "[1, 2] + x - [1, 2]", want: "[x, x]"},
+		{expr: "t = x*x; t + t", want: "t = x^2; 2 * t"},
+		{expr: "(9^10000)^1000", want: "(9^10000)^1000"},
+		{expr: "9^1000 * 9^1000", want: "(9^1000)^2"},
+		{expr: "2^2000 / 2^1999", want: "2^2000 / 2^1999"},
+		{expr: "-(2^2000)", want: "-(2^2000)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+			simplified, err := calculation.Simplify(tree)
+			require.NoError(t, err)
+			got := calculation.Format(simplified)
+			assert.Equal(t, tt.want, got)
+
+			// The canonical form is a fixed point.
+			tree, err = calculation.Parse(got)
+			require.NoError(t, err)
+			simplified, err = calculation.Simplify(tree)
+			require.NoError(t, err)
+			assert.Equal(t, got, calculation.Format(simplified))
+		})
+	}
+}
+
+func TestSimplifyKeepsValue(t *testing.T) {
+	t.Parallel()
+
+	vars := calculation.Variables{"x": 1.7, "y": -0.4}
+	for _, expr := range []string{
+		"(x + y) * (x + y) / (x + y) - y",
+		"x^0.5 * x^0.5 * 3 / (2 * y)",
+		"sqrt(x*x) + abs(y - y*2)",
+		"2 * (x - 1) - (x + 3) / 4",
+		"x > y && !(y > 0) ? x^3 / x : 0",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			tree, err := calculation.Parse(expr)
+			require.NoError(t, err)
+			want, err := calculation.EvaluateWith(tree, vars)
+			require.NoError(t, err)
+
+			simplified, err := calculation.Simplify(tree)
+			require.NoError(t, err)
+			got, err := calculation.EvaluateWith(simplified, vars)
+			require.NoError(t, err)
+			assert.InDelta(t, want, got, 1e-9)
+		})
+	}
+}
+
+func TestSimplifyWith(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		mode  calculation.Mode
+		scale int
+		expr  string
+		want  string
+	}{
+		{mode: calculation.ModeFloat, expr: "2 * x * 3", want: "6 * x"},
+		{mode: calculation.ModeFloat, expr: "1e308 * 10 > 0", want: "1" + strings.Repeat("0", 308) + " * 10 > 0"},
+		{mode: calculation.ModeFloat, expr: "3.5 % 2", want: "3.5 % 2"},
+		{mode: calculation.ModeFloat, expr: "1 << 70", want: "1 << 70"},
+		{mode: calculation.ModeInteger, expr: "9223372036854775807 + 1", want: "9223372036854775807 + 1"},
+		{mode: calculation.ModeInteger, expr: "9223372036854775806 + 1", want: "9223372036854775807"},
+		{mode: calculation.ModeInteger, expr: "x / 2 * 2", want: "2 * (x / 2)"},
+		{mode: calculation.ModeInteger, expr: "7 / 2 > 3", want: "7 / 2 > 3"},
+		{mode: calculation.ModeDecimal, scale: 2, expr: "0.05 * 0.1 * 10", want: "10 * (0.05 * 0.1)"},
+		{mode: calculation.ModeDecimal, scale: 2, expr: "0.25 + 0.5", want: "0.75"},
+		{mode: calculation.ModeRational, expr: "1/3 * 3 + x", want: "x + 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode)+" "+tt.expr, func(t *testing.T) {
+			arith, err := calculation.NewArithmetic(tt.mode, tt.scale)
+			require.NoError(t, err)
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+			simplified, err := calculation.SimplifyWith(tree, arith)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, calculation.Format(simplified))
+		})
+	}
+}
+
+func TestFormatLaTeX(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "x^2 + 3*x/2 - 1", want: `x^{2} + \frac{3 \cdot x}{2} - 1`},
+		{expr: "(a/b)^2", want: `\left(\frac{a}{b}\right)^{2}`},
+		{expr: "sqrt(x + 1) * abs(rate)", want: `\sqrt{x + 1} \cdot \left|\mathrm{rate}\right|`},
+		{expr: "x <= 1 ? log(x) : 0", want: `\begin{cases} \ln\left(x\right) & \text{if } x \le 1 \\ 0 & \text{otherwise} \end{cases}`},
+		{expr: "[[1, 2], [3, 4]]", want: `\begin{bmatrix} 1 & 2 \\ 3 & 4 \end{bmatrix}`},
+		{expr: "~x & m xor y << 2", want: `\mathord{\sim}x \mathbin{\&} m \oplus y \ll 2`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, calculation.FormatLaTeX(tree))
+		})
+	}
+}
+
+func TestFormatMathML(t *testing.T) {
+	t.Parallel()
+
+	tree, err := calculation.Parse("x^2 / (y - 1) >= 0")
+	require.NoError(t, err)
+	assert.Equal(t,
+		`<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow>`+
+			`<mfrac><msup><mi>x</mi><mn>2</mn></msup><mrow><mo>(</mo><mrow><mi>y</mi><mo>&#x2212;</mo><mn>1</mn></mrow><mo>)</mo></mrow></mfrac>`+
+			`<mo>&#x2265;</mo><mn>0</mn></mrow></math>`,
+		calculation.FormatMathML(tree))
+}
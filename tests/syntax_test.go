@@ -0,0 +1,104 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSyntax(t *testing.T) {
+	t.Parallel()
+
+	funcs := definitions(t, "hyp(a, b) = sqrt(a^2 + b^2)")
+
+	tests := []struct {
+		name   string
+		syntax calculation.Syntax
+		expr   string
+		infix  string
+	}{
+		{name: "rpn operators", syntax: calculation.SyntaxRPN, expr: "3 4 + 2 *", infix: "(3 + 4) * 2"},
+		{name: "rpn operand order", syntax: calculation.SyntaxRPN, expr: "10 4 - 2 /", infix: "(10 - 4) / 2"},
+		{name: "rpn negation", syntax: calculation.SyntaxRPN, expr: "x neg 2 ^ -1 +", infix: "(-x)^2 + -1"},
+		{name: "rpn functions", syntax: calculation.SyntaxRPN, expr: "16 sqrt 1 5 max:3", infix: "max(sqrt(16), 1, 5)"},
+		{name: "rpn user function", syntax: calculation.SyntaxRPN, expr: "3 4 hyp", infix: "hyp(3, 4)"},
+		{name: "rpn conditional", syntax: calculation.SyntaxRPN, expr: "x 0 > x x neg ?", infix: "x > 0 ? x : -x"},
+		{name: "rpn bitwise", syntax: calculation.SyntaxRPN, expr: "0xF0 ~ 1 4 << &", infix: "~0xF0 & 1 << 4"},
+		{name: "sexpr operators", syntax: calculation.SyntaxSExpr, expr: "(* (+ 3 4) 2)", infix: "(3 + 4) * 2"},
+		{name: "sexpr chains group to the left", syntax: calculation.SyntaxSExpr, expr: "(- 10 (+ 1 2 3) 4)", infix: "10 - (1 + 2 + 3) - 4"},
+		{name: "sexpr negation", syntax: calculation.SyntaxSExpr, expr: "(+ (- x) -2 (neg y))", infix: "-x + -2 + -y"},
+		{name: "sexpr functions", syntax: calculation.SyntaxSExpr, expr: "(max 1 (sqrt 16) (hyp 3 4))", infix: "max(1, sqrt(16), hyp(3, 4))"},
+		{name: "sexpr conditional", syntax: calculation.SyntaxSExpr, expr: "(if (&& (> x 0) (! y)) 1 (? y 2 3))", infix: "if(x > 0 && !y, 1, y ? 2 : 3)"},
+		{name: "sexpr atom", syntax: calculation.SyntaxSExpr, expr: "42", infix: "42"},
+		{name: "infix", syntax: "", expr: "(3 + 4) * 2", infix: "(3 + 4) * 2"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := calculation.ParseSyntax(tt.expr, tt.syntax, funcs)
+			require.NoError(t, err)
+			assert.Equal(t, tt.infix, calculation.Format(tree))
+
+			// The tree is the one the infix form gives, so both evaluate alike.
+			infix, err := calculation.ParseWith(tt.infix, funcs)
+			require.NoError(t, err)
+			vars := calculation.Variables{"x": 3, "y": 0}
+			want, err := calculation.EvaluateWith(infix, vars)
+			require.NoError(t, err)
+			got, err := calculation.EvaluateWith(tree, vars)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestParseSyntaxErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		syntax   calculation.Syntax
+		expr     string
+		wantCode string
+		wantErr  string
+	}{
+		{name: "rpn missing operand", syntax: calculation.SyntaxRPN, expr: "3 4 + +", wantCode: calculation.CodeMissingOperand, wantErr: "not enough operands for '+': needs 2, got 1 at position 6"},
+		{name: "rpn extra operand", syntax: calculation.SyntaxRPN, expr: "1 2 3 +", wantCode: calculation.CodeExtraOperand, wantErr: "operand without an operator at position 2"},
+		{name: "rpn variadic without count", syntax: calculation.SyntaxRPN, expr: "1 2 max", wantCode: calculation.CodeWrongArity, wantErr: "function max takes a variable number of arguments, give their count as max:2 at position 4"},
+		{name: "rpn wrong count", syntax: calculation.SyntaxRPN, expr: "1 2 sqrt:2", wantCode: calculation.CodeWrongArity, wantErr: "function sqrt expects 1 argument(s), got 2 at position 4"},
+		{name: "rpn vector", syntax: calculation.SyntaxRPN, expr: "[1, 2]", wantCode: calculation.CodeUnexpectedToken, wantErr: "unexpected token '[' at position 0"},
+		{name: "sexpr unclosed list", syntax: calculation.SyntaxSExpr, expr: "(+ 1 2", wantCode: calculation.CodeUnclosedParen, wantErr: "missing closing parenthesis for '(' at position 0"},
+		{name: "sexpr trailing tokens", syntax: calculation.SyntaxSExpr, expr: "(+ 1 2) 3", wantCode: calculation.CodeUnexpectedToken, wantErr: "unexpected token '3' at position 8"},
+		{name: "sexpr number as head", syntax: calculation.SyntaxSExpr, expr: "(3 4)", wantCode: calculation.CodeUnexpectedToken, wantErr: "unexpected token '3' at position 1, expected operator or name"},
+		{name: "sexpr too many operands", syntax: calculation.SyntaxSExpr, expr: "(^ 2 3 4)", wantCode: calculation.CodeWrongArity, wantErr: "operator '^' expects 2 operand(s), got 3 at position 1"},
+		{name: "sexpr missing operand", syntax: calculation.SyntaxSExpr, expr: "(* 2)", wantCode: calculation.CodeMissingOperand, wantErr: "not enough operands for '*': needs 2, got 1 at position 1"},
+		{name: "sexpr unknown function", syntax: calculation.SyntaxSExpr, expr: "(foo 1)", wantCode: calculation.CodeUnknownFunction, wantErr: "unknown function 'foo' at position 1"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := calculation.ParseSyntax(tt.expr, tt.syntax, nil)
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+
+			var syntaxErr *calculation.SyntaxError
+			require.True(t, errors.As(err, &syntaxErr))
+			assert.Equal(t, tt.wantCode, syntaxErr.Code)
+		})
+	}
+}
+
+func TestSyntaxValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []calculation.Syntax{"", calculation.SyntaxInfix, calculation.SyntaxRPN, calculation.SyntaxSExpr} {
+		assert.NoError(t, s.Validate(), s)
+	}
+	assert.EqualError(t, calculation.Syntax("lisp").Validate(), `unknown syntax "lisp"`)
+}
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDimensions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		expr     string
+		vars     calculation.Variables
+		unit     string
+		expected float64
+		wantErr  string
+	}{
+		{name: "mixed units of speed", expr: "3 m * 2 s^-1 + 4 km/h", unit: "m/s", expected: 7.111111111111111},
+		{name: "named derived unit", expr: "10 N * 2 m", unit: "J", expected: 20},
+		{name: "prefixes", expr: "5 V / 2 kohm", unit: "A", expected: 0.0025},
+		{name: "square root halves exponents", expr: "sqrt(16 m^2)", unit: "m", expected: 4},
+		{name: "exponent from a variable", expr: "(2 m)^n", vars: calculation.Variables{"n": 3}, unit: "m^3", expected: 8},
+		{name: "angles are dimensionless", expr: "sin(90 deg)", unit: "", expected: 1},
+		{name: "several units in the denominator", expr: "1 J / 1 kg / 1 K", unit: "m^2*s^-2*K^-1", expected: 1},
+		{name: "adding metres to seconds", expr: "1 m + 1 s", wantErr: "incompatible units m and s for '+' at position 4"},
+		{name: "dimensionless operand", expr: "1 m + 1", wantErr: "incompatible units m and dimensionless for '+' at position 4"},
+		{name: "function of a quantity", expr: "exp(2 s)", wantErr: "function exp expects a dimensionless argument, got s at position 0"},
+		{name: "irrational power of a unit", expr: "(2 m)^0.5", wantErr: "cannot raise m to the power 0.5 at position 5"},
+		{name: "mixed min arguments", expr: "min(1 m, 1 s)", wantErr: "incompatible units m and s in min at position 0"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+
+			dim, err := calculation.Dimensions(tree, tt.vars)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.unit, dim.String())
+
+			got, err := calculation.EvaluateWith(tree, tt.vars)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, got, 1e-12)
+		})
+	}
+}
+
+func TestParseUnit(t *testing.T) {
+	t.Parallel()
+
+	unit, err := calculation.ParseUnit("km/h")
+	require.NoError(t, err)
+	assert.InDelta(t, 1/3.6, unit.Factor, 1e-15)
+	assert.Equal(t, "m/s", unit.Dim.String())
+
+	unit, err = calculation.ParseUnit("kg*m^2/s^2")
+	require.NoError(t, err)
+	assert.Equal(t, "J", unit.Dim.String())
+
+	unit, err = calculation.ParseUnit("min")
+	require.NoError(t, err)
+	assert.Equal(t, 60.0, unit.Factor, "exact symbols win over prefixes")
+
+	_, err = calculation.ParseUnit("furlong")
+	assert.EqualError(t, err, `unknown unit "furlong"`)
+
+	_, err = calculation.ParseUnit("m/")
+	assert.EqualError(t, err, `invalid unit "m/"`)
+}
+
+func TestConvertUnit(t *testing.T) {
+	t.Parallel()
+
+	speed, err := calculation.ParseUnit("m/s")
+	require.NoError(t, err)
+
+	unit, err := calculation.ConvertUnit(speed.Dim, "km/h")
+	require.NoError(t, err)
+	assert.InDelta(t, 25.2, 7/unit.Factor, 1e-12)
+
+	_, err = calculation.ConvertUnit(speed.Dim, "kg")
+	assert.EqualError(t, err, "incompatible units: cannot convert m/s to kg")
+}
+
+func TestUnitsOutsideFloatMode(t *testing.T) {
+	t.Parallel()
+
+	arith, err := calculation.NewArithmetic(calculation.ModeDecimal, 10)
+	require.NoError(t, err)
+
+	tree, err := calculation.Parse("1 + 2 km")
+	require.NoError(t, err)
+	assert.EqualError(t, arith.Check(tree), "unit km at position 6 is only supported in float mode")
+}
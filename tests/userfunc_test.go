@@ -0,0 +1,187 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/structxz/calc_v3/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDefinition(t *testing.T) {
+	t.Parallel()
+
+	def, err := calculation.ParseDefinition("f(x, y) = x^2 + y", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "f", def.Name)
+	assert.Equal(t, []string{"x", "y"}, def.Params)
+	assert.Equal(t, "x^2 + y", def.Body)
+	assert.Equal(t, "f(x, y) = x^2 + y", def.String())
+
+	def, err = calculation.ParseDefinition("answer() = 42", nil)
+	require.NoError(t, err)
+	assert.Empty(t, def.Params)
+}
+
+func TestParseDefinitionErrors(t *testing.T) {
+	t.Parallel()
+
+	funcs := definitions(t, "sq(x) = x * x", "quad(x) = sq(sq(x))")
+
+	tests := []struct {
+		name       string
+		definition string
+		wantErr    string
+	}{
+		{name: "built-in name", definition: "sqrt(x) = x", wantErr: "cannot redefine built-in function sqrt at position 0"},
+		{name: "duplicate parameter", definition: "f(x, x) = x", wantErr: "duplicate parameter x at position 5"},
+		{name: "missing equals sign", definition: "f(x) x", wantErr: "unexpected token 'x' at position 5, expected '='"},
+		{name: "missing parenthesis", definition: "f = 1", wantErr: "unexpected token '=' at position 2, expected '('"},
+		{name: "undeclared parameter", definition: "f(x) = x + y", wantErr: "undeclared parameter y in function f"},
+		{name: "invalid body", definition: "f(x) = x +", wantErr: "unexpected end of expression at position 3, expected number, name, '(', '[', '-', '!' or '~' in the body of f"},
+		{name: "direct recursion", definition: "f(n) = n * f(n - 1)", wantErr: "recursive function call f -> f in the body of f"},
+		{name: "indirect recursion", definition: "sq(x) = quad(x)", wantErr: "recursive function call sq -> quad -> sq in the body of sq"},
+		{name: "unknown function", definition: "f(x) = g(x)", wantErr: "unknown function 'g' at position 0 in the body of f"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := calculation.ParseDefinition(tt.definition, funcs)
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestEvaluateUserCall(t *testing.T) {
+	t.Parallel()
+
+	funcs := definitions(t,
+		"f(x, y) = x^2 + y",
+		"sq(x) = x * x",
+		"g(a) = f(sq(a), a) + sq(a)",
+		"answer() = 42",
+		"inv(x) = x != 0 ? 1 / x : 0",
+		"hyp(a, b) = s = sq(a) + sq(b); sqrt(s)",
+	)
+
+	tests := []struct {
+		expr     string
+		vars     calculation.Variables
+		expected float64
+	}{
+		{expr: "f(3, 4)", expected: 13},
+		{expr: "g(2)", expected: 22},
+		{expr: "answer() + inv(0) + inv(4)", expected: 42.25},
+		{expr: "hyp(3, 4)", expected: 5},
+		{expr: "x = 2; f(x, x) * sq(x)", expected: 24},
+		{expr: "f(y, x)", vars: calculation.Variables{"x": 1, "y": 5}, expected: 26},
+		{expr: "sum(f([1, 2], 1))", expected: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			tree, err := calculation.ParseWith(tt.expr, funcs)
+			require.NoError(t, err)
+
+			result, err := calculation.EvaluateWith(tree, tt.vars)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, result, 1e-9)
+
+			result, err = calculation.EvaluateWith(calculation.Rebalance(tree), tt.vars)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, result, 1e-9)
+		})
+	}
+
+	tree, err := calculation.ParseWith("g(1/3)", funcs)
+	require.NoError(t, err)
+	arith, err := calculation.NewArithmetic(calculation.ModeRational, 0)
+	require.NoError(t, err)
+	got, err := arith.Evaluate(tree, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "37/81", got)
+
+	tree, err = calculation.ParseWith("sq(2 m)", funcs)
+	require.NoError(t, err)
+	dim, err := calculation.Dimensions(tree, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "m^2", dim.String())
+}
+
+func TestUserCallSharesArguments(t *testing.T) {
+	t.Parallel()
+
+	funcs := definitions(t, "sq(x) = x * x", "unused(x, y) = y")
+
+	tests := []struct {
+		expr string
+		ops  int
+	}{
+		{"sq(1 + 2)", 2},
+		{"sq(sq(sq(1 + 2)))", 4},
+		{"unused(1 + 2, 3)", 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			tree, err := calculation.ParseWith(tc.expr, funcs)
+			require.NoError(t, err)
+
+			alg := &depthAlgebra{}
+			_, err = calculation.Fold[int](calculation.Rebalance(tree), alg)
+			require.NoError(t, err)
+			assert.Equal(t, tc.ops, alg.ops)
+		})
+	}
+}
+
+func TestUserCallErrors(t *testing.T) {
+	t.Parallel()
+
+	// Each of d1 ... d8 calls the previous one twice, so d8 expands to 511
+	// calls.
+	texts := []string{"f(x, y) = x + y", "d0(x) = x"}
+	for i := 1; i <= 8; i++ {
+		texts = append(texts, fmt.Sprintf("d%d(x) = d%d(x) + d%d(x)", i, i-1, i-1))
+	}
+	funcs := definitions(t, texts...)
+	// Definitions that ParseDefinition would reject can still come from
+	// elsewhere, so calls are checked as they are expanded.
+	funcs["loop"] = &calculation.Definition{Name: "loop", Params: []string{"x"}, Body: "loop(x)"}
+
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "f(1)", wantErr: "function f expects 2 argument(s), got 1 at position 0"},
+		{expr: "2 + f(1, 2, 3)", wantErr: "function f expects 2 argument(s), got 3 at position 4"},
+		{expr: "1 + loop(2)", wantErr: "recursive function call loop -> loop in call of loop at position 4"},
+		{expr: "d8(1) + d8(1)", wantErr: "too many calls of user-defined functions (1000) in call of d8 at position 8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := calculation.ParseWith(tt.expr, funcs)
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+
+	_, err := calculation.ParseDefinition("d9(x) = d8(x) + d8(x)", funcs)
+	require.EqualError(t, err, "too many calls of user-defined functions (1000) in the body of d9")
+}
+
+// definitions parses function definitions in order, so each one can call
+// the previous ones.
+func definitions(t *testing.T, texts ...string) calculation.Definitions {
+	t.Helper()
+
+	funcs := calculation.Definitions{}
+	for _, text := range texts {
+		def, err := calculation.ParseDefinition(text, funcs)
+		require.NoError(t, err)
+		funcs[def.Name] = def
+	}
+	return funcs
+}